@@ -0,0 +1,388 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// types of activity recorded in the activity events table
+const (
+	eventMessage    = "message"
+	eventReaction   = "reaction"
+	eventJoin       = "join"
+	eventVoiceJoin  = "voice_join"
+	eventVoiceLeave = "voice_leave"
+	eventScan       = "scan"
+)
+
+// ActivityEvent : one thing a member did, as kept in the activity events table
+type ActivityEvent struct {
+	Type      string
+	ChannelID string
+	CreatedAt time.Time
+}
+
+// HistorySet : a paginated ~activity history message and the timeline it pages through
+type HistorySet struct {
+	Message *discordgo.Message
+	Title   string
+	Counts  string
+	Lines   []string
+	Index   int
+}
+
+// activity history is kept this many days unless the guild sets activity_retention
+const defaultActivityRetentionDays = 90
+
+// how many days ~activity history looks back when no number is given
+const defaultHistoryDays = 30
+
+// how many timeline entries each page of ~activity history shows
+const historyPageSize = 15
+
+var globalHistorySet []*HistorySet
+var globalHistorySetLock sync.Mutex
+
+/**
+Describes an event the way the activity table's description column does.
+*/
+func describeActivityEvent(event ActivityEvent) string {
+	switch event.Type {
+	case eventMessage:
+		return "Wrote a message in <#" + event.ChannelID + ">"
+	case eventReaction:
+		return "Reacted to a message in <#" + event.ChannelID + ">"
+	case eventJoin:
+		return "Joined the server"
+	case eventVoiceJoin:
+		return "Joined <#" + event.ChannelID + ">"
+	case eventVoiceLeave:
+		if event.ChannelID == "" {
+			return "Left a voice channel"
+		}
+		return "Left <#" + event.ChannelID + ">"
+	case eventScan:
+		return "Detected in a scan"
+	}
+	return event.Type
+}
+
+/**
+Appends an event to the member's activity history.
+*/
+func recordActivityEvent(guildID string, memberID string, event ActivityEvent) {
+	insertSQL := fmt.Sprintf("INSERT INTO %s (guild_id, member_id, event_type, channel_id, created_at) VALUES ('%s', '%s', '%s', '%s', '%s');",
+		activityEventsTable, guildID, memberID, event.Type, event.ChannelID, sqlTimestamp(event.CreatedAt))
+	queryWithoutResults(insertSQL, "Unable to record activity event!")
+}
+
+/**
+Reads the member's activity events since the given time, newest first.
+*/
+func getActivityEvents(guildID string, memberID string, since time.Time) ([]ActivityEvent, error) {
+	var events []ActivityEvent
+	selectSQL := fmt.Sprintf("SELECT event_type, channel_id, created_at FROM %s WHERE (guild_id = '%s' AND member_id = '%s' AND created_at >= '%s') ORDER BY created_at DESC, entry DESC;",
+		activityEventsTable, guildID, memberID, sqlTimestamp(since))
+	query, err := connection_pool.Query(selectSQL)
+	if err != nil {
+		return events, err
+	}
+	defer query.Close()
+	for query.Next() {
+		var event ActivityEvent
+		var createdAt string
+		err = query.Scan(&event.Type, &event.ChannelID, &createdAt)
+		if err != nil {
+			return events, err
+		}
+		event.CreatedAt, err = parseSQLTimestamp(createdAt)
+		if err != nil {
+			return events, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+/**
+Counts events by type and formats them, most common first, e.g. "message: 12\nreaction: 3".
+*/
+func countActivityEvents(events []ActivityEvent) string {
+	counts := map[string]int{}
+	for _, event := range events {
+		counts[event.Type]++
+	}
+	var types []string
+	for eventType := range counts {
+		types = append(types, eventType)
+	}
+	sort.Slice(types, func(i, j int) bool {
+		if counts[types[i]] != counts[types[j]] {
+			return counts[types[i]] > counts[types[j]]
+		}
+		return types[i] < types[j]
+	})
+	var lines []string
+	for _, eventType := range types {
+		lines = append(lines, fmt.Sprintf("%s: %d", eventType, counts[eventType]))
+	}
+	if len(lines) == 0 {
+		return "None"
+	}
+	return strings.Join(lines, "\n")
+}
+
+/**
+Formats each event as a line of the timeline.
+*/
+func historyLines(events []ActivityEvent) []string {
+	var lines []string
+	for _, event := range events {
+		lines = append(lines, "`"+event.CreatedAt.UTC().Format("01/02/2006 15:04")+"` "+describeActivityEvent(event))
+	}
+	return lines
+}
+
+/**
+Returns the number of pages needed to show the given number of lines.
+*/
+func historyPageCount(lines int) int {
+	pages := lines / historyPageSize
+	if lines%historyPageSize != 0 || pages == 0 {
+		pages++
+	}
+	return pages
+}
+
+/**
+Builds the embed for the set's current page.
+*/
+func (set *HistorySet) embed() *discordgo.MessageEmbed {
+	var embed discordgo.MessageEmbed
+	embed.Type = "rich"
+	embed.Title = set.Title
+	start := set.Index * historyPageSize
+	end := start + historyPageSize
+	if end > len(set.Lines) {
+		end = len(set.Lines)
+	}
+	embed.Description = "No activity recorded."
+	if start < end {
+		embed.Description = strings.Join(set.Lines[start:end], "\n")
+	}
+	embed.Fields = []*discordgo.MessageEmbedField{createField("Counts", set.Counts, false)}
+	embed.Footer = &discordgo.MessageEmbedFooter{Text: fmt.Sprintf("Page %d of %d", set.Index+1, historyPageCount(len(set.Lines)))}
+	return &embed
+}
+
+/**
+Keeps the history message navigable for 30 minutes, then removes its reactions.
+*/
+func appendToGlobalHistorySet(s *discordgo.Session, newset *HistorySet) {
+	globalHistorySetLock.Lock()
+	globalHistorySet = append(globalHistorySet, newset)
+	globalHistorySetLock.Unlock()
+
+	time.Sleep(30 * time.Minute)
+
+	globalHistorySetLock.Lock()
+	for i, set := range globalHistorySet {
+		if set == newset {
+			globalHistorySet = append(globalHistorySet[:i], globalHistorySet[i+1:]...)
+			break
+		}
+	}
+	globalHistorySetLock.Unlock()
+	err := s.MessageReactionsRemoveAll(newset.Message.ChannelID, newset.Message.ID)
+	if err != nil {
+		logError("Failed to remove reactions from the activity history! " + err.Error())
+	}
+}
+
+/**
+Turns the page of an ~activity history message when someone reacts with ◀️ or ▶️.
+*/
+func navigateHistory(s *discordgo.Session, m *discordgo.MessageReactionAdd) {
+	if m.UserID == s.State.User.ID || (m.Emoji.Name != "◀️" && m.Emoji.Name != "▶️") {
+		return
+	}
+	globalHistorySetLock.Lock()
+	var found *HistorySet
+	for _, set := range globalHistorySet {
+		if set.Message.ID == m.MessageID {
+			found = set
+			break
+		}
+	}
+	if found == nil {
+		globalHistorySetLock.Unlock()
+		return
+	}
+	if m.Emoji.Name == "◀️" && found.Index > 0 {
+		found.Index--
+	} else if m.Emoji.Name == "▶️" && found.Index < historyPageCount(len(found.Lines))-1 {
+		found.Index++
+	}
+	embed := found.embed()
+	globalHistorySetLock.Unlock()
+
+	_, err := s.ChannelMessageEditEmbed(m.ChannelID, m.MessageID, embed)
+	if err != nil {
+		logError("Failed to edit activity history! " + err.Error())
+		return
+	}
+	err = s.MessageReactionRemove(m.ChannelID, m.MessageID, m.Emoji.Name, m.UserID)
+	if err != nil {
+		logError("Failed to remove user's reaction! " + err.Error())
+	}
+}
+
+/**
+Deletes activity events older than each guild's retention period, every hour.
+*/
+func runActivityRetention() {
+	for {
+		query, err := connection_pool.Query(fmt.Sprintf("SELECT DISTINCT guild_id FROM %s;", activityEventsTable))
+		if err != nil {
+			logError("SELECT query error: " + err.Error())
+		} else {
+			var guildIDs []string
+			for query.Next() {
+				var guildID string
+				if err = query.Scan(&guildID); err == nil {
+					guildIDs = append(guildIDs, guildID)
+				}
+			}
+			query.Close()
+			for _, guildID := range guildIDs {
+				deleteSQL := fmt.Sprintf("DELETE FROM %s WHERE (guild_id = '%s' AND created_at < '%s');", activityEventsTable, guildID, sqlTimestamp(time.Now().AddDate(0, 0, -getActivityRetention(guildID))))
+				queryWithoutResults(deleteSQL, "Unable to delete expired activity events!")
+			}
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+/**
+Returns how many days of activity history the guild keeps.
+*/
+func getActivityRetention(guildID string) int {
+	days, err := strconv.Atoi(getGuildSetting(guildID, "activity_retention"))
+	if err != nil || days < 1 {
+		return defaultActivityRetentionDays
+	}
+	return days
+}
+
+/****
+COMMANDS
+****/
+
+/**
+Shows a paginated timeline of what a member has done in the last few days, with counts by type.
+**/
+func handleActivityHistory(s *discordgo.Session, m *discordgo.MessageCreate, command []string) {
+	if (len(command) != 3 && len(command) != 4) || len(m.Mentions) != 1 {
+		_, err := s.ChannelMessageSend(m.ChannelID, "Usage: ```~activity history <@user> <days: optional>```")
+		if err != nil {
+			logError("Failed to send usage message! " + err.Error())
+		}
+		return
+	}
+	days := defaultHistoryDays
+	if len(command) == 4 {
+		var err error
+		days, err = strconv.Atoi(command[3])
+		if err != nil || days < 1 {
+			_, err = s.ChannelMessageSend(m.ChannelID, "Please input a valid number.")
+			if err != nil {
+				logError("Failed to send 'invalid number' message! " + err.Error())
+			}
+			return
+		}
+	}
+	if retention := getActivityRetention(m.GuildID); days > retention {
+		days = retention
+	}
+
+	user := m.Mentions[0]
+	events, err := getActivityEvents(m.GuildID, user.ID, time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		logError("Unable to read activity history! " + err.Error())
+		_, err = s.ChannelMessageSend(m.ChannelID, "An error occurred. Please try again in a moment.")
+		if err != nil {
+			logError("Failed to send error message! " + err.Error())
+		}
+		return
+	}
+
+	set := &HistorySet{
+		Title:  fmt.Sprintf("Activity of %s#%s (last %d days)", user.Username, user.Discriminator, days),
+		Counts: countActivityEvents(events),
+		Lines:  historyLines(events),
+	}
+	message, err := s.ChannelMessageSendEmbed(m.ChannelID, set.embed())
+	if err != nil {
+		logError("Failed to send activity history! " + err.Error())
+		return
+	}
+	if historyPageCount(len(set.Lines)) == 1 {
+		logSuccess("Returned activity history")
+		return
+	}
+	set.Message = message
+	go appendToGlobalHistorySet(s, set)
+	for _, emoji := range []string{"◀️", "▶️"} {
+		err = s.MessageReactionAdd(m.ChannelID, message.ID, emoji)
+		if err != nil {
+			logError("Failed to add reaction to activity history! " + err.Error())
+			return
+		}
+	}
+	logSuccess("Returned interactable activity history")
+}
+
+/**
+Shows or changes how many days of activity history the server keeps.
+**/
+func handleActivityRetention(s *discordgo.Session, m *discordgo.MessageCreate, command []string) {
+	if !userHasValidPermissions(s, m, discordgo.PermissionManageServer) {
+		logWarning("User attempted to change activity retention without proper permissions")
+		_, err := s.ChannelMessageSend(m.ChannelID, "Sorry, you don't have the `Manage Server` permission.")
+		if err != nil {
+			logError("Failed to send permissions message! " + err.Error())
+		}
+		return
+	}
+	if len(command) == 2 {
+		_, err := s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Activity history is kept for %d days.", getActivityRetention(m.GuildID)))
+		if err != nil {
+			logError("Failed to send activity retention message! " + err.Error())
+		}
+		return
+	}
+	days, err := strconv.Atoi(command[2])
+	if len(command) != 3 || err != nil || days < 1 {
+		_, err = s.ChannelMessageSend(m.ChannelID, "Usage: ```~activity retention <days>```")
+		if err != nil {
+			logError("Failed to send usage message! " + err.Error())
+		}
+		return
+	}
+	response := fmt.Sprintf("Activity history will now be kept for %d days.", days)
+	if !setGuildSetting(m.GuildID, "activity_retention", command[2]) {
+		response = "An error occurred. Please try again in a moment."
+	}
+	_, err = s.ChannelMessageSend(m.ChannelID, response)
+	if err != nil {
+		logError("Failed to send activity retention message! " + err.Error())
+		return
+	}
+	logSuccess("Updated activity retention")
+}
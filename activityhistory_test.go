@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+/**
+Test anything that uses calculation outside of the discordgo commands.
+Those commands don't need to be tested since they are verified to work
+at github.com/bwmarrin/discordgo
+**/
+func TestActivityHistory(t *testing.T) {
+	now := time.Date(2021, 6, 30, 12, 0, 0, 0, time.UTC)
+	events := []ActivityEvent{
+		{Type: eventMessage, ChannelID: "1", CreatedAt: now},
+		{Type: eventVoiceLeave, CreatedAt: now.Add(-time.Hour)},
+		{Type: eventMessage, ChannelID: "2", CreatedAt: now.Add(-2 * time.Hour)},
+		{Type: eventReaction, ChannelID: "1", CreatedAt: now.Add(-3 * time.Hour)},
+	}
+
+	t.Run("Events are described like the activity summary", func(t *testing.T) {
+		if description := describeActivityEvent(events[0]); description != "Wrote a message in <#1>" {
+			t.Logf("Unexpected description '%s'", description)
+			t.Fail()
+		}
+		if description := describeActivityEvent(events[1]); description != "Left a voice channel" {
+			t.Logf("Unexpected description '%s'", description)
+			t.Fail()
+		}
+	})
+
+	t.Run("Events are counted by type, most common first", func(t *testing.T) {
+		if counts := countActivityEvents(events); counts != "message: 2\nreaction: 1\nvoice_leave: 1" {
+			t.Logf("Unexpected counts '%s'", counts)
+			t.Fail()
+		}
+		if counts := countActivityEvents(nil); counts != "None" {
+			t.Logf("Expected None, got '%s'", counts)
+			t.Fail()
+		}
+	})
+
+	t.Run("The timeline is split into pages", func(t *testing.T) {
+		if historyPageCount(0) != 1 || historyPageCount(historyPageSize) != 1 || historyPageCount(historyPageSize+1) != 2 {
+			t.Log("Page counts were calculated incorrectly")
+			t.Fail()
+		}
+		var lines []string
+		for i := 0; i < historyPageSize+2; i++ {
+			lines = append(lines, historyLines(events[:1])...)
+		}
+		set := HistorySet{Lines: lines, Index: 1}
+		embed := set.embed()
+		if embed.Description != lines[0]+"\n"+lines[0] || embed.Footer.Text != "Page 2 of 2" {
+			t.Logf("Unexpected last page '%s' (%s)", embed.Description, embed.Footer.Text)
+			t.Fail()
+		}
+	})
+}
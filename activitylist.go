@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// ActivityListOptions : which members ~activity list shows, in what order, and whether to export them
+type ActivityListOptions struct {
+	Days               int
+	Sort               string
+	RoleID             string
+	ExcludeWhitelisted bool
+	JoinedOnly         bool
+	Export             string
+}
+
+// ActivityExportRow : one member in an exported activity list
+type ActivityExportRow struct {
+	MemberID    string `json:"member_id"`
+	MemberName  string `json:"member_name"`
+	LastActive  string `json:"last_active"`
+	Description string `json:"description"`
+	Whitelisted bool   `json:"whitelisted"`
+}
+
+const activityListUsage = "Usage: ```~activity list <number of days of inactivity> [--sort last-active/name] [--role @role] [--exclude-whitelisted] [--joined-only] [--export csv/json]```"
+
+/**
+Parses the number of days and flags given to ~activity list.
+*/
+func parseActivityListOptions(args []string) (ActivityListOptions, error) {
+	var options ActivityListOptions
+	if len(args) == 0 {
+		return options, fmt.Errorf("missing number of days")
+	}
+	days, err := strconv.Atoi(args[0])
+	if err != nil {
+		return options, fmt.Errorf("'%s' is not a number", args[0])
+	}
+	options.Days = days
+	for i := 1; i < len(args); i++ {
+		flag := args[i]
+		switch flag {
+		case "--exclude-whitelisted":
+			options.ExcludeWhitelisted = true
+			continue
+		case "--joined-only":
+			options.JoinedOnly = true
+			continue
+		case "--sort", "--role", "--export":
+		default:
+			return options, fmt.Errorf("unknown flag '%s'", flag)
+		}
+		if i+1 >= len(args) {
+			return options, fmt.Errorf("%s needs a value", flag)
+		}
+		i++
+		value := args[i]
+		switch flag {
+		case "--sort":
+			if value != "last-active" && value != "name" {
+				return options, fmt.Errorf("can only sort by last-active or name")
+			}
+			options.Sort = value
+		case "--role":
+			if !strings.HasPrefix(value, "<@&") || !strings.HasSuffix(value, ">") {
+				return options, fmt.Errorf("--role needs a role mention")
+			}
+			options.RoleID = strings.TrimSuffix(strings.TrimPrefix(value, "<@&"), ">")
+		case "--export":
+			if value != "csv" && value != "json" {
+				return options, fmt.Errorf("can only export as csv or json")
+			}
+			options.Export = value
+		}
+	}
+	return options, nil
+}
+
+/**
+Keeps the members matching the options and sorts them. Members whose last activity can't be
+read are left out when filtering by days or sorting by last activity.
+hasRole reports whether a member has options.RoleID.
+*/
+func filterActivityList(members []MemberActivity, options ActivityListOptions, now time.Time, hasRole func(memberID string) bool) []MemberActivity {
+	joined := describeActivityEvent(ActivityEvent{Type: eventJoin})
+	lastActive := map[string]time.Time{}
+	var filtered []MemberActivity
+	for _, member := range members {
+		if options.ExcludeWhitelisted && member.Whitelisted == 1 {
+			continue
+		}
+		if options.JoinedOnly && member.Description != joined {
+			continue
+		}
+		if options.RoleID != "" && !hasRole(member.MemberID) {
+			continue
+		}
+		if options.Days > 0 || options.Sort == "last-active" {
+			active, err := parseLastActive(member.LastActive)
+			if err != nil {
+				logWarning("Unable to parse last activity of " + member.MemberID + ". " + err.Error())
+				continue
+			}
+			if options.Days > 0 && !active.AddDate(0, 0, options.Days).Before(now) {
+				continue
+			}
+			lastActive[member.MemberID] = active
+		}
+		filtered = append(filtered, member)
+	}
+
+	switch options.Sort {
+	case "last-active":
+		sort.SliceStable(filtered, func(i, j int) bool {
+			return lastActive[filtered[i].MemberID].Before(lastActive[filtered[j].MemberID])
+		})
+	case "name":
+		sort.SliceStable(filtered, func(i, j int) bool {
+			return strings.ToLower(filtered[i].MemberName) < strings.ToLower(filtered[j].MemberName)
+		})
+	}
+	return filtered
+}
+
+/**
+Converts the members into rows for exporting, with last activity as an RFC 3339 timestamp.
+*/
+func activityExportRows(members []MemberActivity) []ActivityExportRow {
+	var rows []ActivityExportRow
+	for _, member := range members {
+		row := ActivityExportRow{
+			MemberID:    member.MemberID,
+			MemberName:  member.MemberName,
+			LastActive:  member.LastActive,
+			Description: member.Description,
+			Whitelisted: member.Whitelisted == 1,
+		}
+		if active, err := parseLastActive(member.LastActive); err == nil {
+			row.LastActive = active.UTC().Format(time.RFC3339)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+/**
+Encodes the members as a CSV or JSON file.
+*/
+func exportActivityList(members []MemberActivity, format string) ([]byte, error) {
+	rows := activityExportRows(members)
+	if format == "json" {
+		if rows == nil {
+			rows = []ActivityExportRow{}
+		}
+		return json.MarshalIndent(rows, "", "  ")
+	}
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+	err := writer.Write([]string{"member_id", "member_name", "last_active", "description", "whitelisted"})
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		err = writer.Write([]string{row.MemberID, row.MemberName, row.LastActive, row.Description, strconv.FormatBool(row.Whitelisted)})
+		if err != nil {
+			return nil, err
+		}
+	}
+	writer.Flush()
+	return buffer.Bytes(), writer.Error()
+}
+
+/**
+Returns a function reporting whether a member has the role. Members missing from the bot's state
+are fetched from Discord, since large guilds only have some members cached.
+*/
+func memberHasRole(s *discordgo.Session, guildID string, roleID string) func(string) bool {
+	return func(memberID string) bool {
+		member, err := s.State.Member(guildID, memberID)
+		if err != nil {
+			member, err = s.GuildMember(guildID, memberID)
+			if err != nil {
+				logWarning("Unable to check member's roles! " + err.Error())
+				return false
+			}
+		}
+		for _, memberRole := range member.Roles {
+			if memberRole == roleID {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+/**
+Uploads the list as a file so staff can review it offline.
+*/
+func sendActivityExport(s *discordgo.Session, m *discordgo.MessageCreate, members []MemberActivity, options ActivityListOptions) {
+	data, err := exportActivityList(members, options.Export)
+	if err != nil {
+		logError("Unable to export activity list! " + err.Error())
+		_, err = s.ChannelMessageSend(m.ChannelID, "An error occurred. Please try again in a moment.")
+		if err != nil {
+			logError("Failed to send error message! " + err.Error())
+		}
+		return
+	}
+	contentType := "text/csv"
+	if options.Export == "json" {
+		contentType = "application/json"
+	}
+	_, err = s.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{
+		Content: fmt.Sprintf("Exported %d members.", len(members)),
+		Files: []*discordgo.File{{
+			Name:        "activity-" + time.Now().UTC().Format("2006-01-02") + "." + options.Export,
+			ContentType: contentType,
+			Reader:      bytes.NewReader(data),
+		}},
+	})
+	if err != nil {
+		logError("Failed to send activity export! " + err.Error())
+		return
+	}
+	logSuccess("Exported activity list")
+}
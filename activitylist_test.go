@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+/**
+Test anything that uses calculation outside of the discordgo commands.
+Those commands don't need to be tested since they are verified to work
+at github.com/bwmarrin/discordgo
+**/
+func TestActivityList(t *testing.T) {
+	now := time.Date(2021, 6, 30, 12, 0, 0, 0, time.UTC)
+	members := []MemberActivity{
+		{MemberID: "1", MemberName: "carol", LastActive: now.AddDate(0, 0, -10).String(), Description: "Joined the server"},
+		{MemberID: "2", MemberName: "Alice", LastActive: now.AddDate(0, 0, -40).String(), Description: "Wrote a message in <#1>", Whitelisted: 1},
+		{MemberID: "3", MemberName: "bob", LastActive: now.AddDate(0, 0, -20).String(), Description: "Joined the server"},
+		{MemberID: "4", MemberName: "dave", LastActive: now.AddDate(0, 0, -1).String(), Description: "Wrote a message in <#1>"},
+	}
+	ids := func(list []MemberActivity) string {
+		var ids []string
+		for _, member := range list {
+			ids = append(ids, member.MemberID)
+		}
+		return strings.Join(ids, ",")
+	}
+
+	t.Run("Flags are parsed", func(t *testing.T) {
+		options, err := parseActivityListOptions(strings.Split("7 --sort name --role <@&55> --exclude-whitelisted --joined-only --export csv", " "))
+		if err != nil || options.Days != 7 || options.Sort != "name" || options.RoleID != "55" || !options.ExcludeWhitelisted || !options.JoinedOnly || options.Export != "csv" {
+			t.Logf("Unexpected options %+v (%v)", options, err)
+			t.Fail()
+		}
+		for _, args := range []string{"", "seven", "7 --sort", "7 --sort age", "7 --export xml", "7 --bogus"} {
+			if _, err := parseActivityListOptions(strings.Fields(args)); err == nil {
+				t.Logf("Expected '%s' to be rejected", args)
+				t.Fail()
+			}
+		}
+	})
+
+	t.Run("Members are filtered and sorted", func(t *testing.T) {
+		none := func(string) bool { return false }
+		if list := ids(filterActivityList(members, ActivityListOptions{Days: 5, Sort: "last-active"}, now, none)); list != "2,3,1" {
+			t.Logf("Expected 2,3,1, got %s", list)
+			t.Fail()
+		}
+		if list := ids(filterActivityList(members, ActivityListOptions{Sort: "name", ExcludeWhitelisted: true}, now, none)); list != "3,1,4" {
+			t.Logf("Expected 3,1,4, got %s", list)
+			t.Fail()
+		}
+		if list := ids(filterActivityList(members, ActivityListOptions{JoinedOnly: true}, now, none)); list != "1,3" {
+			t.Logf("Expected 1,3, got %s", list)
+			t.Fail()
+		}
+		hasRole := func(memberID string) bool { return memberID == "4" }
+		if list := ids(filterActivityList(members, ActivityListOptions{RoleID: "55"}, now, hasRole)); list != "4" {
+			t.Logf("Expected 4, got %s", list)
+			t.Fail()
+		}
+	})
+
+	t.Run("Lists are exported as csv and json", func(t *testing.T) {
+		data, err := exportActivityList(members[1:2], "csv")
+		expected := "member_id,member_name,last_active,description,whitelisted\n2,Alice,2021-05-21T12:00:00Z,Wrote a message in <#1>,true\n"
+		if err != nil || string(data) != expected {
+			t.Logf("Unexpected csv '%s' (%v)", data, err)
+			t.Fail()
+		}
+		data, err = exportActivityList(nil, "json")
+		if err != nil || string(data) != "[]" {
+			t.Logf("Unexpected json '%s' (%v)", data, err)
+			t.Fail()
+		}
+	})
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// AntiraidConfig : when a guild's join rate counts as a raid, and what to do about it
+type AntiraidConfig struct {
+	Enabled      bool   `json:"enabled"`
+	Joins        int    `json:"joins"`
+	Seconds      int    `json:"seconds"`
+	NewAccounts  int    `json:"new_accounts"`
+	AccountDays  int    `json:"account_days"`
+	AutoLockdown bool   `json:"auto_lockdown"`
+	Action       string `json:"action"`
+	AlertRole    string `json:"alert_role"`
+}
+
+// LockdownState : what lockdown changed, so it can be put back afterwards
+type LockdownState struct {
+	VerificationLevel discordgo.VerificationLevel `json:"verification_level"`
+	Channels          []LockedChannel             `json:"channels"`
+}
+
+// LockedChannel : a channel's @everyone overwrite before lockdown
+type LockedChannel struct {
+	ChannelID string `json:"channel_id"`
+	Existed   bool   `json:"existed"`
+	Allow     int64  `json:"allow"`
+	Deny      int64  `json:"deny"`
+}
+
+// a recent join, kept in memory for join-rate detection
+type raidJoin struct {
+	UserID         string
+	JoinedAt       time.Time
+	AccountCreated time.Time
+}
+
+// how long after the last suspicious join a raid is considered over
+const raidDuration = 5 * time.Minute
+
+var defaultAntiraidConfig = AntiraidConfig{
+	Joins:       10,
+	Seconds:     10,
+	NewAccounts: 5,
+	AccountDays: 7,
+	Action:      "none",
+}
+
+var antiraidConfigs = map[string]AntiraidConfig{}
+var raidJoins = map[string][]raidJoin{}
+var raidActiveUntil = map[string]time.Time{}
+var antiraidLock sync.Mutex
+
+/**
+Returns the guild's anti-raid settings, loading them from the guild settings table if they aren't cached.
+Must be called with antiraidLock held.
+*/
+func getAntiraidConfig(guildID string) AntiraidConfig {
+	if config, ok := antiraidConfigs[guildID]; ok {
+		return config
+	}
+	config := defaultAntiraidConfig
+	if raw := getGuildSetting(guildID, "antiraid"); raw != "" {
+		err := json.Unmarshal([]byte(raw), &config)
+		if err != nil {
+			logWarning("Unable to read anti-raid settings, using the defaults. " + err.Error())
+		}
+	}
+	antiraidConfigs[guildID] = config
+	return config
+}
+
+/**
+Saves the guild's anti-raid settings and updates the cache.
+*/
+func setAntiraidConfig(guildID string, config AntiraidConfig) bool {
+	raw, err := json.Marshal(config)
+	if err != nil {
+		logError("Unable to encode anti-raid settings! " + err.Error())
+		return false
+	}
+	if !setGuildSetting(guildID, "antiraid", string(raw)) {
+		return false
+	}
+	antiraidLock.Lock()
+	antiraidConfigs[guildID] = config
+	antiraidLock.Unlock()
+	return true
+}
+
+/**
+Checks the joins within the configured window against the guild's thresholds. Returns
+why they look like a raid, or "" if they don't.
+*/
+func detectRaid(config AntiraidConfig, joins []raidJoin, now time.Time) string {
+	if config.Joins > 0 && len(joins) > config.Joins {
+		return fmt.Sprintf("%d accounts joined in %d seconds", len(joins), config.Seconds)
+	}
+	if config.NewAccounts > 0 {
+		young := 0
+		for _, join := range joins {
+			if now.Sub(join.AccountCreated) < time.Duration(config.AccountDays)*24*time.Hour {
+				young++
+			}
+		}
+		if young >= config.NewAccounts {
+			return fmt.Sprintf("%d accounts younger than %d days joined in %d seconds", young, config.AccountDays, config.Seconds)
+		}
+	}
+	return ""
+}
+
+/**
+Records the join and raises the raid alarm if the guild's thresholds are crossed.
+Members who join while a raid is ongoing are kicked or banned if the guild asks for it.
+*/
+func checkRaid(s *discordgo.Session, m *discordgo.GuildMemberAdd) {
+	if m.User == nil || m.User.Bot {
+		return
+	}
+	created, err := discordgo.SnowflakeTimestamp(m.User.ID)
+	if err != nil {
+		logWarning("Unable to read account age. " + err.Error())
+		return
+	}
+
+	antiraidLock.Lock()
+	config := getAntiraidConfig(m.GuildID)
+	if !config.Enabled {
+		antiraidLock.Unlock()
+		return
+	}
+	now := time.Now()
+	window := time.Duration(config.Seconds) * time.Second
+	joins := raidJoins[m.GuildID]
+	for len(joins) > 0 && now.Sub(joins[0].JoinedAt) > window {
+		joins = joins[1:]
+	}
+	joins = append(joins, raidJoin{m.User.ID, now, created})
+	raidJoins[m.GuildID] = joins
+
+	ongoing := now.Before(raidActiveUntil[m.GuildID])
+	reason := detectRaid(config, joins, now)
+	var wave []raidJoin
+	if reason != "" {
+		raidActiveUntil[m.GuildID] = now.Add(raidDuration)
+		if ongoing {
+			wave = []raidJoin{joins[len(joins)-1]}
+		} else {
+			wave = append(wave, joins...)
+		}
+	} else if ongoing {
+		wave = []raidJoin{joins[len(joins)-1]}
+	}
+	antiraidLock.Unlock()
+
+	if reason != "" && !ongoing {
+		logWarning("Raid detected: " + reason)
+		raiseRaidAlarm(s, m.GuildID, config, reason, len(wave))
+	}
+	if len(wave) == 0 || config.Action == "none" {
+		return
+	}
+	moderator := s.State.User.Username + "#" + s.State.User.Discriminator
+	for _, join := range wave {
+		if config.Action == "ban" {
+			err = banMember(s, m.GuildID, join.UserID, moderator, "Raid protection")
+		} else {
+			err = kickMember(s, m.GuildID, join.UserID, moderator, "Raid protection")
+		}
+		if err != nil {
+			logError("Failed to remove raid account! " + err.Error())
+		}
+	}
+}
+
+/**
+Alerts staff in the mod log and, if the guild asks for it, locks the server down.
+*/
+func raiseRaidAlarm(s *discordgo.Session, guildID string, config AntiraidConfig, reason string, waveSize int) {
+	var embed discordgo.MessageEmbed
+	embed.Title = ":rotating_light: Possible raid"
+	embed.Description = reason
+	var actions []string
+	if config.Action != "none" {
+		actions = append(actions, fmt.Sprintf("Removing the %d accounts in the raid wave (%s)", waveSize, config.Action))
+	}
+	if config.AutoLockdown {
+		locked, err := enableLockdown(s, guildID)
+		if err != nil {
+			logError("Failed to enable lockdown! " + err.Error())
+			actions = append(actions, "Lockdown failed: "+err.Error())
+		} else {
+			actions = append(actions, fmt.Sprintf("Locked down the server (%d channels). Use `~lockdown off` when it's over.", locked))
+		}
+	}
+	if len(actions) == 0 {
+		actions = append(actions, "No automatic action taken. Use `~lockdown on` to lock the server down.")
+	}
+	embed.Fields = []*discordgo.MessageEmbedField{createField("Action", strings.Join(actions, "\n"), false)}
+	embed.Timestamp = time.Now().Format(time.RFC3339)
+
+	content := ""
+	if config.AlertRole != "" {
+		content = "<@&" + config.AlertRole + ">"
+	}
+	sendModAlert(s, guildID, content, &embed)
+}
+
+/**
+Raises the guild's verification level and stops @everyone from sending messages in
+every channel they can currently see. Returns how many channels were locked.
+*/
+func enableLockdown(s *discordgo.Session, guildID string) (int, error) {
+	if getGuildSetting(guildID, "lockdown") != "" {
+		return 0, fmt.Errorf("the server is already locked down")
+	}
+	guild, err := s.Guild(guildID)
+	if err != nil {
+		return 0, err
+	}
+	channels, err := s.GuildChannels(guildID)
+	if err != nil {
+		return 0, err
+	}
+
+	state := LockdownState{VerificationLevel: guild.VerificationLevel}
+	if guild.VerificationLevel < discordgo.VerificationLevelHigh {
+		level := discordgo.VerificationLevelHigh
+		_, err = s.GuildEdit(guildID, discordgo.GuildParams{VerificationLevel: &level})
+		if err != nil {
+			logWarning("Unable to raise verification level. " + err.Error())
+		}
+	}
+
+	for _, channel := range channels {
+		if channel.Type != discordgo.ChannelTypeGuildText && channel.Type != discordgo.ChannelTypeGuildNews {
+			continue
+		}
+		locked := LockedChannel{ChannelID: channel.ID}
+		for _, overwrite := range channel.PermissionOverwrites {
+			// the @everyone role shares the guild's ID
+			if overwrite.ID == guildID {
+				locked.Existed = true
+				locked.Allow = overwrite.Allow
+				locked.Deny = overwrite.Deny
+			}
+		}
+		if locked.Deny&(discordgo.PermissionViewChannel|discordgo.PermissionSendMessages) != 0 {
+			continue
+		}
+		err = s.ChannelPermissionSet(channel.ID, guildID, discordgo.PermissionOverwriteTypeRole, locked.Allow&^discordgo.PermissionSendMessages, locked.Deny|discordgo.PermissionSendMessages)
+		if err != nil {
+			logWarning("Unable to lock channel " + channel.ID + ". " + err.Error())
+			continue
+		}
+		state.Channels = append(state.Channels, locked)
+	}
+
+	raw, err := json.Marshal(state)
+	if err != nil {
+		return len(state.Channels), err
+	}
+	if !setGuildSetting(guildID, "lockdown", string(raw)) {
+		return len(state.Channels), fmt.Errorf("unable to save the lockdown, so it will have to be undone by hand")
+	}
+	return len(state.Channels), nil
+}
+
+/**
+Puts back the verification level and channel overwrites that lockdown changed.
+Returns how many channels were unlocked.
+*/
+func disableLockdown(s *discordgo.Session, guildID string) (int, error) {
+	raw := getGuildSetting(guildID, "lockdown")
+	if raw == "" {
+		return 0, fmt.Errorf("the server isn't locked down")
+	}
+	var state LockdownState
+	err := json.Unmarshal([]byte(raw), &state)
+	if err != nil {
+		return 0, err
+	}
+
+	level := state.VerificationLevel
+	_, err = s.GuildEdit(guildID, discordgo.GuildParams{VerificationLevel: &level})
+	if err != nil {
+		logWarning("Unable to restore verification level. " + err.Error())
+	}
+	unlocked := 0
+	for _, locked := range state.Channels {
+		if locked.Existed {
+			err = s.ChannelPermissionSet(locked.ChannelID, guildID, discordgo.PermissionOverwriteTypeRole, locked.Allow, locked.Deny)
+		} else {
+			err = s.ChannelPermissionDelete(locked.ChannelID, guildID)
+		}
+		if err != nil {
+			logWarning("Unable to unlock channel " + locked.ChannelID + ". " + err.Error())
+			continue
+		}
+		unlocked++
+	}
+
+	antiraidLock.Lock()
+	delete(raidActiveUntil, guildID)
+	antiraidLock.Unlock()
+	if !deleteGuildSetting(guildID, "lockdown") {
+		return unlocked, fmt.Errorf("unable to clear the saved lockdown")
+	}
+	return unlocked, nil
+}
+
+/****
+COMMANDS
+****/
+
+/**
+Shows or changes the guild's anti-raid settings.
+**/
+func handleAntiraid(s *discordgo.Session, m *discordgo.MessageCreate, command []string) {
+	logInfo(strings.Join(command, " "))
+	if !userHasValidPermissions(s, m, discordgo.PermissionManageServer) {
+		logWarning("User attempted to change anti-raid without proper permissions")
+		_, err := s.ChannelMessageSend(m.ChannelID, "Sorry, you don't have the `Manage Server` permission.")
+		if err != nil {
+			logError("Failed to send permissions message! " + err.Error())
+		}
+		return
+	}
+
+	antiraidLock.Lock()
+	config := getAntiraidConfig(m.GuildID)
+	antiraidLock.Unlock()
+
+	if len(command) == 1 {
+		status := "off"
+		if config.Enabled {
+			status = "on"
+		}
+		lockdown := "off"
+		if config.AutoLockdown {
+			lockdown = "on"
+		}
+		alert := "none"
+		if config.AlertRole != "" {
+			alert = "<@&" + config.AlertRole + ">"
+		}
+		var embed discordgo.MessageEmbed
+		embed.Type = "rich"
+		embed.Title = "Anti-raid is " + status
+		embed.Description = "Alerts are posted in the mod log channel. A threshold of 0 turns that check off."
+		embed.Fields = []*discordgo.MessageEmbedField{
+			createField("Join Rate", fmt.Sprintf("more than %d joins in %d seconds", config.Joins, config.Seconds), true),
+			createField("New Accounts", fmt.Sprintf("%d accounts younger than %d days", config.NewAccounts, config.AccountDays), true),
+			createField("Auto Lockdown", lockdown, true),
+			createField("Raid Wave Action", config.Action, true),
+			createField("Alert Role", alert, true),
+		}
+		_, err := s.ChannelMessageSendEmbed(m.ChannelID, &embed)
+		if err != nil {
+			logError("Failed to send anti-raid settings! " + err.Error())
+			return
+		}
+		logSuccess("Sent anti-raid settings")
+		return
+	}
+
+	usage := "Usages: ```~antiraid\n~antiraid on/off\n~antiraid joins <count> <seconds>\n~antiraid newaccounts <count> <days>\n~antiraid autolockdown on/off\n~antiraid action none/kick/ban\n~antiraid alert <@role/off>```"
+	valid := true
+	switch {
+	case len(command) == 2 && (command[1] == "on" || command[1] == "off"):
+		config.Enabled = command[1] == "on"
+	case len(command) == 4 && command[1] == "joins":
+		joins, err := strconv.Atoi(command[2])
+		seconds, err2 := strconv.Atoi(command[3])
+		valid = err == nil && err2 == nil && joins >= 0 && seconds > 0
+		config.Joins = joins
+		config.Seconds = seconds
+	case len(command) == 4 && command[1] == "newaccounts":
+		count, err := strconv.Atoi(command[2])
+		days, err2 := strconv.Atoi(command[3])
+		valid = err == nil && err2 == nil && count >= 0 && days > 0
+		config.NewAccounts = count
+		config.AccountDays = days
+	case len(command) == 3 && command[1] == "autolockdown":
+		valid = command[2] == "on" || command[2] == "off"
+		config.AutoLockdown = command[2] == "on"
+	case len(command) == 3 && command[1] == "action":
+		valid = command[2] == "none" || command[2] == "kick" || command[2] == "ban"
+		config.Action = command[2]
+	case len(command) == 3 && command[1] == "alert":
+		if command[2] == "off" {
+			config.AlertRole = ""
+		} else {
+			valid = strings.HasPrefix(command[2], "<@&") && strings.HasSuffix(command[2], ">")
+			config.AlertRole = strings.TrimSuffix(strings.TrimPrefix(command[2], "<@&"), ">")
+		}
+	default:
+		valid = false
+	}
+	if !valid {
+		_, err := s.ChannelMessageSend(m.ChannelID, usage)
+		if err != nil {
+			logError("Failed to send usage message! " + err.Error())
+		}
+		return
+	}
+
+	response := "Updated the anti-raid settings."
+	if !setAntiraidConfig(m.GuildID, config) {
+		response = "An error occurred. Please try again in a moment."
+	}
+	_, err := s.ChannelMessageSend(m.ChannelID, response)
+	if err != nil {
+		logError("Failed to send anti-raid result message! " + err.Error())
+		return
+	}
+	logSuccess("Updated anti-raid settings")
+}
+
+/**
+Manually locks the server down or lifts the lockdown.
+**/
+func handleLockdown(s *discordgo.Session, m *discordgo.MessageCreate, command []string) {
+	logInfo(strings.Join(command, " "))
+	if !userHasValidPermissions(s, m, discordgo.PermissionManageServer) {
+		logWarning("User attempted to use lockdown without proper permissions")
+		_, err := s.ChannelMessageSend(m.ChannelID, "Sorry, you don't have the `Manage Server` permission.")
+		if err != nil {
+			logError("Failed to send permissions message! " + err.Error())
+		}
+		return
+	}
+
+	if len(command) == 1 {
+		response := "The server is not locked down."
+		if getGuildSetting(m.GuildID, "lockdown") != "" {
+			response = "The server is locked down. Use `~lockdown off` to lift it."
+		}
+		_, err := s.ChannelMessageSend(m.ChannelID, response)
+		if err != nil {
+			logError("Failed to send lockdown status message! " + err.Error())
+		}
+		return
+	}
+	if len(command) != 2 || (command[1] != "on" && command[1] != "off") {
+		_, err := s.ChannelMessageSend(m.ChannelID, "Usage: `~lockdown on/off`")
+		if err != nil {
+			logError("Failed to send usage message! " + err.Error())
+		}
+		return
+	}
+
+	var response string
+	var embed discordgo.MessageEmbed
+	embed.Timestamp = time.Now().Format(time.RFC3339)
+	if command[1] == "on" {
+		locked, err := enableLockdown(s, m.GuildID)
+		response = fmt.Sprintf(":lock: Locked down %d channels and raised the verification level.", locked)
+		if err != nil {
+			response = "Couldn't lock the server down: " + err.Error()
+		}
+		embed.Title = ":lock: Lockdown enabled"
+	} else {
+		unlocked, err := disableLockdown(s, m.GuildID)
+		response = fmt.Sprintf(":unlock: Unlocked %d channels and restored the verification level.", unlocked)
+		if err != nil {
+			response = "Couldn't lift the lockdown: " + err.Error()
+		}
+		embed.Title = ":unlock: Lockdown lifted"
+	}
+	_, err := s.ChannelMessageSend(m.ChannelID, response)
+	if err != nil {
+		logError("Failed to send lockdown result message! " + err.Error())
+		return
+	}
+	embed.Description = fmt.Sprintf("By <@%s>. %s", m.Author.ID, response)
+	sendModLog(s, m.GuildID, &embed)
+	logSuccess("Changed lockdown")
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+/**
+Test anything that uses calculation outside of the discordgo commands.
+Those commands don't need to be tested since they are verified to work
+at github.com/bwmarrin/discordgo
+**/
+func TestAntiraid(t *testing.T) {
+	now := time.Now()
+	config := defaultAntiraidConfig
+	oldAccount := now.AddDate(-2, 0, 0)
+
+	t.Run("Join floods are detected", func(t *testing.T) {
+		var joins []raidJoin
+		for i := 0; i < config.Joins; i++ {
+			joins = append(joins, raidJoin{JoinedAt: now, AccountCreated: oldAccount})
+		}
+		if reason := detectRaid(config, joins, now); reason != "" {
+			t.Logf("Flagged joins at the limit: %s", reason)
+			t.Fail()
+		}
+		joins = append(joins, raidJoin{JoinedAt: now, AccountCreated: oldAccount})
+		if detectRaid(config, joins, now) == "" {
+			t.Logf("Failed to flag a join flood")
+			t.Fail()
+		}
+	})
+
+	t.Run("Waves of new accounts are detected", func(t *testing.T) {
+		var joins []raidJoin
+		for i := 0; i < config.NewAccounts; i++ {
+			joins = append(joins, raidJoin{JoinedAt: now, AccountCreated: now.Add(-time.Hour)})
+		}
+		if detectRaid(config, joins, now) == "" {
+			t.Logf("Failed to flag new accounts")
+			t.Fail()
+		}
+		joins[0].AccountCreated = oldAccount
+		if reason := detectRaid(config, joins, now); reason != "" {
+			t.Logf("Flagged too few new accounts: %s", reason)
+			t.Fail()
+		}
+	})
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// AntispamConfig : a guild's spam thresholds. A threshold of 0 turns that check off.
+type AntispamConfig struct {
+	Enabled          bool   `json:"enabled"`
+	Messages         int    `json:"messages"`
+	Seconds          int    `json:"seconds"`
+	Duplicates       int    `json:"duplicates"`
+	DuplicateSeconds int    `json:"duplicate_seconds"`
+	Mentions         int    `json:"mentions"`
+	Emoji            int    `json:"emoji"`
+	CapsPercent      int    `json:"caps_percent"`
+	Action           string `json:"action"`
+	Cooldown         string `json:"cooldown"`
+	MuteDuration     string `json:"mute_duration"`
+}
+
+// a message remembered for flood and duplicate detection
+type trackedMessage struct {
+	ID        string
+	ChannelID string
+	Content   string
+	SentAt    time.Time
+}
+
+// messages shorter than this are never treated as a caps flood
+const capsMinimumLetters = 10
+
+var customEmojiRegex = regexp.MustCompile(`<a?:\w+:[0-9]+>`)
+
+var defaultAntispamConfig = AntispamConfig{
+	Messages:         5,
+	Seconds:          5,
+	Duplicates:       3,
+	DuplicateSeconds: 60,
+	Mentions:         5,
+	Emoji:            10,
+	CapsPercent:      70,
+	Action:           "delete",
+	Cooldown:         "30s",
+	MuteDuration:     "10m",
+}
+
+// how often members who stopped posting are dropped from memory
+const antispamPruneInterval = time.Minute
+
+var antispamConfigs = map[string]AntispamConfig{}
+var antispamHistory = map[string][]trackedMessage{}
+var antispamCooldowns = map[string]time.Time{}
+var antispamLastPrune time.Time
+var antispamLock sync.Mutex
+
+/**
+Returns how long messages need to be remembered for: the longer of the flood and duplicate windows.
+*/
+func (config AntispamConfig) historyWindow() time.Duration {
+	window := time.Duration(config.Seconds) * time.Second
+	if duplicates := time.Duration(config.DuplicateSeconds) * time.Second; config.Duplicates > 0 && duplicates > window {
+		window = duplicates
+	}
+	return window
+}
+
+/**
+Returns the guild's anti-spam settings, loading them from the guild settings table if they aren't cached.
+Must be called with antispamLock held.
+*/
+func getAntispamConfig(guildID string) AntispamConfig {
+	if config, ok := antispamConfigs[guildID]; ok {
+		return config
+	}
+	config := defaultAntispamConfig
+	if raw := getGuildSetting(guildID, "antispam"); raw != "" {
+		err := json.Unmarshal([]byte(raw), &config)
+		if err != nil {
+			logWarning("Unable to read anti-spam settings, using the defaults. " + err.Error())
+		}
+	}
+	antispamConfigs[guildID] = config
+	return config
+}
+
+/**
+Saves the guild's anti-spam settings and updates the cache.
+*/
+func setAntispamConfig(guildID string, config AntispamConfig) bool {
+	raw, err := json.Marshal(config)
+	if err != nil {
+		logError("Unable to encode anti-spam settings! " + err.Error())
+		return false
+	}
+	if !setGuildSetting(guildID, "antispam", string(raw)) {
+		return false
+	}
+	antispamLock.Lock()
+	antispamConfigs[guildID] = config
+	antispamLock.Unlock()
+	return true
+}
+
+/**
+Counts custom emoji and unicode pictographs in the message.
+*/
+func countEmoji(content string) int {
+	count := len(customEmojiRegex.FindAllString(content, -1))
+	for _, r := range customEmojiRegex.ReplaceAllString(content, "") {
+		if (r >= 0x1F300 && r <= 0x1FAFF) || (r >= 0x2600 && r <= 0x27BF) {
+			count++
+		}
+	}
+	return count
+}
+
+/**
+Returns the percentage of letters in the message that are upper case, or 0 if
+the message is too short to judge.
+*/
+func capsPercentage(content string) int {
+	letters := 0
+	upper := 0
+	for _, r := range content {
+		if unicode.IsLetter(r) {
+			letters++
+			if unicode.IsUpper(r) {
+				upper++
+			}
+		}
+	}
+	if letters < capsMinimumLetters {
+		return 0
+	}
+	return upper * 100 / letters
+}
+
+/**
+Checks the newest message (the last in history) against the guild's thresholds. Floods only count
+messages within the flood window and duplicates only count messages within the duplicate window,
+so slow repeats are still caught. Returns why it counts as spam, or "" if it doesn't, along with
+the messages that make up the offence.
+*/
+func detectSpam(config AntispamConfig, history []trackedMessage, mentions int) (string, []trackedMessage) {
+	if len(history) == 0 {
+		return "", nil
+	}
+	latest := history[len(history)-1]
+	if config.Messages > 0 {
+		flood := trimHistory(history, time.Duration(config.Seconds)*time.Second, latest.SentAt)
+		if len(flood) >= config.Messages {
+			return fmt.Sprintf("Sent %d messages in %d seconds", len(flood), config.Seconds), flood
+		}
+	}
+	if config.Duplicates > 0 && latest.Content != "" {
+		var duplicates []trackedMessage
+		for _, message := range trimHistory(history, time.Duration(config.DuplicateSeconds)*time.Second, latest.SentAt) {
+			if strings.EqualFold(message.Content, latest.Content) {
+				duplicates = append(duplicates, message)
+			}
+		}
+		if len(duplicates) >= config.Duplicates {
+			return fmt.Sprintf("Sent the same message %d times", len(duplicates)), duplicates
+		}
+	}
+	single := []trackedMessage{latest}
+	if config.Mentions > 0 && mentions > config.Mentions {
+		return fmt.Sprintf("Mentioned %d users or roles in one message", mentions), single
+	}
+	if emoji := countEmoji(latest.Content); config.Emoji > 0 && emoji > config.Emoji {
+		return fmt.Sprintf("Sent %d emoji in one message", emoji), single
+	}
+	if caps := capsPercentage(latest.Content); config.CapsPercent > 0 && caps >= config.CapsPercent {
+		return fmt.Sprintf("Sent a message that was %d%% capital letters", caps), single
+	}
+	return "", nil
+}
+
+/**
+Returns the history without the given messages.
+*/
+func withoutMessages(history []trackedMessage, removed []trackedMessage) []trackedMessage {
+	var kept []trackedMessage
+	for _, message := range history {
+		found := false
+		for _, other := range removed {
+			if message.ID == other.ID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			kept = append(kept, message)
+		}
+	}
+	return kept
+}
+
+/**
+Drops messages older than the window from the front of the history.
+*/
+func trimHistory(history []trackedMessage, window time.Duration, now time.Time) []trackedMessage {
+	for len(history) > 0 && now.Sub(history[0].SentAt) > window {
+		history = history[1:]
+	}
+	return history
+}
+
+/**
+Forgets members whose history has emptied and whose cooldown is over, and expired cooldowns.
+window returns how long the guild keeps history for. Must be called with antispamLock held.
+*/
+func pruneAntispam(history map[string][]trackedMessage, cooldowns map[string]time.Time, window func(guildID string) time.Duration, now time.Time) {
+	for key, messages := range history {
+		guildID := strings.SplitN(key, ":", 2)[0]
+		if messages = trimHistory(messages, window(guildID), now); len(messages) > 0 {
+			history[key] = messages
+		} else {
+			delete(history, key)
+		}
+	}
+	for key, until := range cooldowns {
+		if _, tracked := history[key]; !tracked && !now.Before(until) {
+			delete(cooldowns, key)
+		}
+	}
+}
+
+/**
+Tracks the message and, if the author has crossed one of the guild's thresholds,
+deletes the offending messages and applies the configured action.
+*/
+func checkSpam(s *discordgo.Session, m *discordgo.MessageCreate) {
+	if m.GuildID == "" || m.Author == nil || m.Author.Bot {
+		return
+	}
+	antispamLock.Lock()
+	config := getAntispamConfig(m.GuildID)
+	if !config.Enabled {
+		antispamLock.Unlock()
+		return
+	}
+	key := m.GuildID + ":" + m.Author.ID
+	now := time.Now()
+	if now.Sub(antispamLastPrune) > antispamPruneInterval {
+		pruneAntispam(antispamHistory, antispamCooldowns, func(guildID string) time.Duration {
+			return getAntispamConfig(guildID).historyWindow()
+		}, now)
+		antispamLastPrune = now
+	}
+	history := trimHistory(antispamHistory[key], config.historyWindow(), now)
+	history = append(history, trackedMessage{m.ID, m.ChannelID, m.Content, now})
+	antispamHistory[key] = history
+
+	mentions := len(m.Mentions) + len(m.MentionRoles)
+	if m.MentionEveryone {
+		mentions++
+	}
+	reason, offence := detectSpam(config, history, mentions)
+	if reason == "" {
+		antispamLock.Unlock()
+		return
+	}
+	// the offending messages are removed from the history so they aren't counted twice
+	if remaining := withoutMessages(history, offence); len(remaining) > 0 {
+		antispamHistory[key] = remaining
+	} else {
+		delete(antispamHistory, key)
+	}
+	cooldown, err := parseDuration(config.Cooldown)
+	if err != nil {
+		cooldown = 0
+	}
+	onCooldown := now.Before(antispamCooldowns[key])
+	if !onCooldown {
+		antispamCooldowns[key] = now.Add(cooldown)
+	}
+	antispamLock.Unlock()
+
+	// staff are never treated as spammers
+	perms, err := s.UserChannelPermissions(m.Author.ID, m.ChannelID)
+	if err == nil && perms&discordgo.PermissionManageMessages != 0 {
+		return
+	}
+	logInfo("Spam detected: " + reason)
+
+	byChannel := map[string][]string{}
+	for _, message := range offence {
+		byChannel[message.ChannelID] = append(byChannel[message.ChannelID], message.ID)
+	}
+	for channelID, messageIDs := range byChannel {
+		if len(messageIDs) == 1 {
+			err = s.ChannelMessageDelete(channelID, messageIDs[0])
+		} else {
+			err = s.ChannelMessagesBulkDelete(channelID, messageIDs)
+		}
+		if err != nil {
+			logError("Failed to delete spam messages! " + err.Error())
+		}
+	}
+	// repeat offences within the cooldown are deleted without punishing or logging again
+	if onCooldown {
+		return
+	}
+
+	result := fmt.Sprintf("Deleted %d messages", len(offence))
+	switch config.Action {
+	case "mute":
+		duration, err := parseDuration(config.MuteDuration)
+		if err == nil {
+			err = muteMember(s, m.GuildID, m.Author.ID, duration, s.State.User, "Spam: "+reason)
+		}
+		result += " and muted the user for " + config.MuteDuration
+		if err != nil {
+			logError("Failed to mute spammer! " + err.Error())
+			result += fmt.Sprintf(" (failed: %s)", err.Error())
+		}
+	case "kick":
+		err = kickMember(s, m.GuildID, m.Author.ID, s.State.User.Username+"#"+s.State.User.Discriminator, "Spam: "+reason)
+		result += " and kicked the user"
+		if err != nil {
+			logError("Failed to kick spammer! " + err.Error())
+			result += fmt.Sprintf(" (failed: %s)", err.Error())
+		}
+	}
+
+	var embed discordgo.MessageEmbed
+	embed.Title = "Anti-spam: " + reason
+	embed.Description = fmt.Sprintf("<@%s> in <#%s>", m.Author.ID, m.ChannelID)
+	embed.Fields = []*discordgo.MessageEmbedField{createField("Action", result, false)}
+	if m.Content != "" {
+		embed.Fields = append([]*discordgo.MessageEmbedField{createField("Latest message", "```"+strings.ReplaceAll(truncateField(m.Content, 1000), "`", "'")+"```", false)}, embed.Fields...)
+	}
+	embed.Timestamp = now.Format(time.RFC3339)
+	sendModLog(s, m.GuildID, &embed)
+}
+
+/****
+COMMANDS
+****/
+
+/**
+Shows or changes the guild's anti-spam settings.
+**/
+func handleAntispam(s *discordgo.Session, m *discordgo.MessageCreate, command []string) {
+	logInfo(strings.Join(command, " "))
+	if !userHasValidPermissions(s, m, discordgo.PermissionManageServer) {
+		logWarning("User attempted to change anti-spam without proper permissions")
+		_, err := s.ChannelMessageSend(m.ChannelID, "Sorry, you don't have the `Manage Server` permission.")
+		if err != nil {
+			logError("Failed to send permissions message! " + err.Error())
+		}
+		return
+	}
+
+	antispamLock.Lock()
+	config := getAntispamConfig(m.GuildID)
+	antispamLock.Unlock()
+
+	if len(command) == 1 {
+		status := "off"
+		if config.Enabled {
+			status = "on"
+		}
+		var embed discordgo.MessageEmbed
+		embed.Type = "rich"
+		embed.Title = "Anti-spam is " + status
+		embed.Description = "A threshold of 0 turns that check off."
+		embed.Fields = []*discordgo.MessageEmbedField{
+			createField("Flood", fmt.Sprintf("%d messages in %d seconds", config.Messages, config.Seconds), true),
+			createField("Duplicates", fmt.Sprintf("%d identical messages in %d seconds", config.Duplicates, config.DuplicateSeconds), true),
+			createField("Mentions", "more than "+strconv.Itoa(config.Mentions), true),
+			createField("Emoji", "more than "+strconv.Itoa(config.Emoji), true),
+			createField("Caps", strconv.Itoa(config.CapsPercent)+"% of letters", true),
+			createField("Action", config.Action, true),
+			createField("Cooldown", config.Cooldown, true),
+			createField("Mute Duration", config.MuteDuration, true),
+		}
+		_, err := s.ChannelMessageSendEmbed(m.ChannelID, &embed)
+		if err != nil {
+			logError("Failed to send anti-spam settings! " + err.Error())
+			return
+		}
+		logSuccess("Sent anti-spam settings")
+		return
+	}
+
+	usage := "Usages: ```~antispam\n~antispam on/off\n~antispam rate <messages> <seconds>\n~antispam duplicates <threshold> <seconds: optional>\n~antispam mentions/emoji/caps <threshold>\n~antispam action delete/mute/kick\n~antispam cooldown/muteduration <duration>```"
+	valid := true
+	switch {
+	case len(command) == 2 && (command[1] == "on" || command[1] == "off"):
+		config.Enabled = command[1] == "on"
+	case len(command) == 4 && command[1] == "rate":
+		messages, err := strconv.Atoi(command[2])
+		seconds, err2 := strconv.Atoi(command[3])
+		valid = err == nil && err2 == nil && messages >= 0 && seconds > 0
+		config.Messages = messages
+		config.Seconds = seconds
+	case len(command) == 4 && command[1] == "duplicates":
+		threshold, err := strconv.Atoi(command[2])
+		seconds, err2 := strconv.Atoi(command[3])
+		valid = err == nil && err2 == nil && threshold >= 0 && seconds > 0
+		config.Duplicates = threshold
+		config.DuplicateSeconds = seconds
+	case len(command) == 3 && (command[1] == "duplicates" || command[1] == "mentions" || command[1] == "emoji" || command[1] == "caps"):
+		threshold, err := strconv.Atoi(command[2])
+		valid = err == nil && threshold >= 0
+		switch command[1] {
+		case "duplicates":
+			config.Duplicates = threshold
+		case "mentions":
+			config.Mentions = threshold
+		case "emoji":
+			config.Emoji = threshold
+		case "caps":
+			valid = valid && threshold <= 100
+			config.CapsPercent = threshold
+		}
+	case len(command) == 3 && command[1] == "action":
+		valid = command[2] == "delete" || command[2] == "mute" || command[2] == "kick"
+		config.Action = command[2]
+	case len(command) == 3 && (command[1] == "cooldown" || command[1] == "muteduration"):
+		_, err := parseDuration(command[2])
+		valid = err == nil
+		if command[1] == "cooldown" {
+			config.Cooldown = command[2]
+		} else {
+			config.MuteDuration = command[2]
+		}
+	default:
+		valid = false
+	}
+	if !valid {
+		_, err := s.ChannelMessageSend(m.ChannelID, usage)
+		if err != nil {
+			logError("Failed to send usage message! " + err.Error())
+		}
+		return
+	}
+
+	response := "Updated the anti-spam settings."
+	if !setAntispamConfig(m.GuildID, config) {
+		response = "An error occurred. Please try again in a moment."
+	}
+	_, err := s.ChannelMessageSend(m.ChannelID, response)
+	if err != nil {
+		logError("Failed to send anti-spam result message! " + err.Error())
+		return
+	}
+	logSuccess("Updated anti-spam settings")
+}
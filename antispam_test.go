@@ -0,0 +1,142 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+/**
+Test anything that uses calculation outside of the discordgo commands.
+Those commands don't need to be tested since they are verified to work
+at github.com/bwmarrin/discordgo
+**/
+func TestAntispam(t *testing.T) {
+	now := time.Now()
+	config := defaultAntispamConfig
+
+	t.Run("Floods are detected once the message limit is reached", func(t *testing.T) {
+		var history []trackedMessage
+		for i := 0; i < config.Messages-1; i++ {
+			history = append(history, trackedMessage{Content: string(rune('a' + i)), SentAt: now})
+		}
+		if reason, _ := detectSpam(config, history, 0); reason != "" {
+			t.Logf("Flagged a message below the limit: %s", reason)
+			t.Fail()
+		}
+		history = append(history, trackedMessage{Content: "z", SentAt: now})
+		if reason, _ := detectSpam(config, history, 0); reason == "" {
+			t.Logf("Failed to flag a flood")
+			t.Fail()
+		}
+	})
+
+	t.Run("Repeated messages are detected", func(t *testing.T) {
+		history := []trackedMessage{{Content: "buy now"}, {Content: "BUY NOW"}, {Content: "buy now"}}
+		if reason, _ := detectSpam(config, history, 0); reason == "" {
+			t.Logf("Failed to flag duplicate messages")
+			t.Fail()
+		}
+	})
+
+	t.Run("Slow repeats are caught by the duplicate window", func(t *testing.T) {
+		history := []trackedMessage{
+			{Content: "buy now", SentAt: now.Add(-40 * time.Second)},
+			{Content: "buy now", SentAt: now.Add(-20 * time.Second)},
+			{Content: "buy now", SentAt: now},
+		}
+		if reason, _ := detectSpam(config, history, 0); reason == "" {
+			t.Logf("Failed to flag duplicates sent slower than the flood window")
+			t.Fail()
+		}
+		history[0].SentAt = now.Add(-2 * time.Minute)
+		if reason, _ := detectSpam(config, history, 0); reason != "" {
+			t.Logf("Counted a duplicate outside the duplicate window: %s", reason)
+			t.Fail()
+		}
+	})
+
+	t.Run("Floods only count messages in the flood window", func(t *testing.T) {
+		var history []trackedMessage
+		for i := 0; i < config.Messages; i++ {
+			history = append(history, trackedMessage{Content: string(rune('a' + i)), SentAt: now.Add(time.Duration(i-config.Messages) * 10 * time.Second)})
+		}
+		if reason, _ := detectSpam(config, history, 0); reason != "" {
+			t.Logf("Flagged messages spread over a minute as a flood: %s", reason)
+			t.Fail()
+		}
+	})
+
+	t.Run("Mentions, emoji and caps floods are detected", func(t *testing.T) {
+		if reason, _ := detectSpam(config, []trackedMessage{{Content: "hi"}}, 6); reason == "" {
+			t.Logf("Failed to flag mass mentions")
+			t.Fail()
+		}
+		if reason, _ := detectSpam(config, []trackedMessage{{Content: "😀😀😀😀😀<:pog:123><a:pog:456>😀😀😀😀"}}, 0); reason == "" {
+			t.Logf("Failed to flag an emoji flood")
+			t.Fail()
+		}
+		if reason, _ := detectSpam(config, []trackedMessage{{Content: "WHY IS NOBODY ANSWERING ME"}}, 0); reason == "" {
+			t.Logf("Failed to flag a caps flood")
+			t.Fail()
+		}
+		if reason, _ := detectSpam(config, []trackedMessage{{Content: "OK LOL"}}, 0); reason != "" {
+			t.Logf("Flagged a short message as a caps flood")
+			t.Fail()
+		}
+	})
+
+	t.Run("Only the offending messages are deleted", func(t *testing.T) {
+		history := []trackedMessage{
+			{ID: "1", Content: "hello", SentAt: now.Add(-30 * time.Second)},
+			{ID: "2", Content: "buy now", SentAt: now.Add(-20 * time.Second)},
+			{ID: "3", Content: "buy now", SentAt: now.Add(-10 * time.Second)},
+			{ID: "4", Content: "buy now", SentAt: now},
+		}
+		if _, offence := detectSpam(config, history, 0); len(offence) != 3 || offence[0].ID != "2" {
+			t.Logf("Expected only the 3 duplicates, got %v", offence)
+			t.Fail()
+		}
+		if _, offence := detectSpam(config, history[:2], 6); len(offence) != 1 || offence[0].ID != "2" {
+			t.Logf("Expected only the message with the mentions, got %v", offence)
+			t.Fail()
+		}
+		if remaining := withoutMessages(history, history[1:]); len(remaining) != 1 || remaining[0].ID != "1" {
+			t.Logf("Expected the unrelated message to stay in the history, got %v", remaining)
+			t.Fail()
+		}
+	})
+
+	t.Run("Disabled checks are skipped", func(t *testing.T) {
+		disabled := config
+		disabled.Mentions = 0
+		if reason, _ := detectSpam(disabled, []trackedMessage{{Content: "hi"}}, 50); reason != "" {
+			t.Logf("Flagged mentions while the check was off")
+			t.Fail()
+		}
+	})
+
+	t.Run("Old messages fall out of the window", func(t *testing.T) {
+		history := []trackedMessage{{SentAt: now.Add(-time.Minute)}, {SentAt: now.Add(-time.Second)}}
+		if trimmed := trimHistory(history, 5*time.Second, now); len(trimmed) != 1 {
+			t.Logf("Expected 1 message to remain, got %d", len(trimmed))
+			t.Fail()
+		}
+	})
+
+	t.Run("Members who stopped posting are forgotten", func(t *testing.T) {
+		history := map[string][]trackedMessage{
+			"1:quiet":  {{SentAt: now.Add(-time.Hour)}},
+			"1:active": {{SentAt: now.Add(-time.Second)}},
+		}
+		cooldowns := map[string]time.Time{"1:quiet": now.Add(-time.Minute), "1:gone": now.Add(-time.Second), "1:muted": now.Add(time.Minute)}
+		pruneAntispam(history, cooldowns, func(string) time.Duration { return time.Minute }, now)
+		if _, ok := history["1:quiet"]; ok || len(history["1:active"]) != 1 {
+			t.Logf("Pruned the wrong histories: %v", history)
+			t.Fail()
+		}
+		if _, ok := cooldowns["1:muted"]; len(cooldowns) != 1 || !ok {
+			t.Logf("Expected only the running cooldown to remain, got %v", cooldowns)
+			t.Fail()
+		}
+	})
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// how many messages ~archive exports when no limit is given, and the most it will export
+const defaultArchiveLimit = 1000
+const maxArchiveLimit = 10000
+
+// ArchivedMessage : the parts of a message kept in a transcript
+type ArchivedMessage struct {
+	ID          string                    `json:"id"`
+	AuthorID    string                    `json:"author_id"`
+	Author      string                    `json:"author"`
+	Timestamp   string                    `json:"timestamp"`
+	Edited      string                    `json:"edited_timestamp,omitempty"`
+	Content     string                    `json:"content"`
+	ReplyTo     string                    `json:"reply_to,omitempty"`
+	Attachments []ArchivedAttachment      `json:"attachments,omitempty"`
+	Embeds      []*discordgo.MessageEmbed `json:"embeds,omitempty"`
+	Reactions   []ArchivedReaction        `json:"reactions,omitempty"`
+}
+
+type ArchivedAttachment struct {
+	Filename string `json:"filename"`
+	URL      string `json:"url"`
+	Size     int    `json:"size"`
+}
+
+type ArchivedReaction struct {
+	Emoji string `json:"emoji"`
+	Count int    `json:"count"`
+}
+
+// Transcript : a channel's exported history
+type Transcript struct {
+	GuildID     string            `json:"guild_id"`
+	ChannelID   string            `json:"channel_id"`
+	ChannelName string            `json:"channel_name"`
+	ExportedAt  string            `json:"exported_at"`
+	Messages    []ArchivedMessage `json:"messages"`
+}
+
+var transcriptTemplate = template.Must(template.New("transcript").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>#{{.ChannelName}}</title>
+<style>
+body { font-family: sans-serif; background: #36393f; color: #dcddde; }
+.message { padding: 6px 12px; border-bottom: 1px solid #40444b; }
+.author { font-weight: bold; color: #fff; }
+.timestamp, .reply, .reactions { color: #72767d; font-size: 0.8em; }
+.content { white-space: pre-wrap; }
+.embed { border-left: 4px solid #4f545c; margin: 4px 0; padding: 4px 8px; background: #2f3136; }
+a { color: #00b0f4; }
+</style>
+</head>
+<body>
+<h1>#{{.ChannelName}}</h1>
+<p class="timestamp">Exported {{.ExportedAt}} - {{len .Messages}} messages</p>
+{{range .Messages}}<div class="message" id="{{.ID}}">
+<span class="author">{{.Author}}</span> <span class="timestamp">{{.Timestamp}}{{if .Edited}} (edited {{.Edited}}){{end}}</span>
+{{if .ReplyTo}}<div class="reply">Replying to <a href="{{.ReplyTo}}">{{.ReplyTo}}</a></div>{{end}}
+<div class="content">{{.Content}}</div>
+{{range .Attachments}}<div class="attachment"><a href="{{.URL}}">{{.Filename}}</a> ({{.Size}} bytes)</div>{{end}}
+{{range .Embeds}}<div class="embed">{{if .Title}}<strong>{{.Title}}</strong><br>{{end}}{{.Description}}{{range .Fields}}<br><strong>{{.Name}}</strong>: {{.Value}}{{end}}</div>{{end}}
+{{if .Reactions}}<div class="reactions">{{range .Reactions}}{{.Emoji}} x{{.Count}} {{end}}</div>{{end}}
+</div>
+{{end}}</body>
+</html>
+`))
+
+/**
+Converts a message into the form stored in transcripts.
+*/
+func archiveMessage(guildID string, message *discordgo.Message) ArchivedMessage {
+	archived := ArchivedMessage{
+		ID:        message.ID,
+		Timestamp: string(message.Timestamp),
+		Edited:    string(message.EditedTimestamp),
+		Content:   message.Content,
+		Embeds:    message.Embeds,
+	}
+	if message.Author != nil {
+		archived.AuthorID = message.Author.ID
+		archived.Author = message.Author.Username + "#" + message.Author.Discriminator
+	}
+	if timestamp, err := message.Timestamp.Parse(); err == nil {
+		archived.Timestamp = timestamp.UTC().Format("2006-01-02 15:04:05")
+	}
+	if edited, err := message.EditedTimestamp.Parse(); err == nil {
+		archived.Edited = edited.UTC().Format("2006-01-02 15:04:05")
+	}
+	if message.MessageReference != nil {
+		archived.ReplyTo = fmt.Sprintf("https://discord.com/channels/%s/%s/%s", guildID, message.MessageReference.ChannelID, message.MessageReference.MessageID)
+	}
+	for _, attachment := range message.Attachments {
+		archived.Attachments = append(archived.Attachments, ArchivedAttachment{attachment.Filename, attachment.URL, attachment.Size})
+	}
+	for _, reactionSet := range message.Reactions {
+		archived.Reactions = append(archived.Reactions, ArchivedReaction{reactionSet.Emoji.Name, reactionSet.Count})
+	}
+	return archived
+}
+
+/**
+Renders the transcript in the requested format (html, json or txt).
+*/
+func renderTranscript(transcript Transcript, format string) ([]byte, error) {
+	switch format {
+	case "json":
+		return json.MarshalIndent(transcript, "", "  ")
+	case "html":
+		var buffer bytes.Buffer
+		err := transcriptTemplate.Execute(&buffer, transcript)
+		return buffer.Bytes(), err
+	case "txt":
+		var builder strings.Builder
+		fmt.Fprintf(&builder, "#%s - exported %s - %d messages\n\n", transcript.ChannelName, transcript.ExportedAt, len(transcript.Messages))
+		for _, message := range transcript.Messages {
+			fmt.Fprintf(&builder, "[%s] %s: %s\n", message.Timestamp, message.Author, message.Content)
+			if message.ReplyTo != "" {
+				fmt.Fprintf(&builder, "    [reply to] %s\n", message.ReplyTo)
+			}
+			for _, attachment := range message.Attachments {
+				fmt.Fprintf(&builder, "    [attachment] %s %s\n", attachment.Filename, attachment.URL)
+			}
+			for _, embed := range message.Embeds {
+				fmt.Fprintf(&builder, "    [embed] %s %s\n", embed.Title, embed.Description)
+			}
+			if len(message.Reactions) > 0 {
+				var reactions []string
+				for _, reaction := range message.Reactions {
+					reactions = append(reactions, reaction.Emoji+" x"+strconv.Itoa(reaction.Count))
+				}
+				fmt.Fprintf(&builder, "    [reactions] %s\n", strings.Join(reactions, ", "))
+			}
+		}
+		return []byte(builder.String()), nil
+	}
+	return nil, fmt.Errorf("unknown format '%s'", format)
+}
+
+/**
+Exports a channel's history as an HTML, JSON or plain text file and uploads it. If
+ARCHIVE_DIRECTORY is set, the transcript is also saved there.
+*/
+func handleArchive(s *discordgo.Session, m *discordgo.MessageCreate, command []string) {
+	logInfo(strings.Join(command, " "))
+	if !userHasValidPermissions(s, m, discordgo.PermissionManageMessages) {
+		logWarning("User attempted to use archive without proper permissions")
+		_, err := s.ChannelMessageSend(m.ChannelID, "Sorry, you aren't allowed to manage messages.")
+		if err != nil {
+			logError("Failed to send permissions message! " + err.Error())
+		}
+		return
+	}
+
+	usage := "Usage: `~archive <#channel> (optional: --limit <number <= 10000>) (optional: --format html/json/txt)`"
+	if len(command) < 2 || !strings.HasPrefix(command[1], "<#") || !strings.HasSuffix(command[1], ">") {
+		_, err := s.ChannelMessageSend(m.ChannelID, usage)
+		if err != nil {
+			logError("Failed to send usage message! " + err.Error())
+		}
+		return
+	}
+	channelID := strings.TrimSuffix(strings.TrimPrefix(command[1], "<#"), ">")
+	limit := defaultArchiveLimit
+	format := "html"
+	for i := 2; i < len(command); i += 2 {
+		if i+1 >= len(command) {
+			limit = -1
+			break
+		}
+		switch command[i] {
+		case "--limit":
+			parsed, err := strconv.Atoi(command[i+1])
+			if err != nil {
+				parsed = -1
+			}
+			limit = parsed
+		case "--format":
+			format = strings.ToLower(command[i+1])
+		default:
+			limit = -1
+		}
+	}
+	if limit < 1 || limit > maxArchiveLimit || (format != "html" && format != "json" && format != "txt") {
+		_, err := s.ChannelMessageSend(m.ChannelID, usage)
+		if err != nil {
+			logError("Failed to send usage message! " + err.Error())
+		}
+		return
+	}
+
+	// don't let anyone export a channel they can't read themselves
+	channel, err := s.Channel(channelID)
+	perms, permErr := s.UserChannelPermissions(m.Author.ID, channelID)
+	if err != nil || permErr != nil || channel.GuildID != m.GuildID || perms&discordgo.PermissionReadMessageHistory == 0 {
+		_, err = s.ChannelMessageSend(m.ChannelID, "I couldn't find that channel, or you can't read its history.")
+		if err != nil {
+			logError("Failed to send error message! " + err.Error())
+		}
+		return
+	}
+
+	messages, err := fetchMessagesBefore(s, channelID, "", limit)
+	if err != nil {
+		logError("Failed to retrieve messages! " + err.Error())
+		_, err = s.ChannelMessageSend(m.ChannelID, "Ran into an error retrieving messages. :slight_frown:")
+		if err != nil {
+			logError("Failed to send error message! " + err.Error())
+		}
+		return
+	}
+
+	now := time.Now()
+	transcript := Transcript{
+		GuildID:     m.GuildID,
+		ChannelID:   channelID,
+		ChannelName: channel.Name,
+		ExportedAt:  now.UTC().Format("2006-01-02 15:04:05") + " UTC",
+	}
+	for _, message := range messages {
+		transcript.Messages = append(transcript.Messages, archiveMessage(m.GuildID, message))
+	}
+	rendered, err := renderTranscript(transcript, format)
+	if err != nil {
+		logError("Failed to render transcript! " + err.Error())
+		_, err = s.ChannelMessageSend(m.ChannelID, "Ran into an error writing the transcript. :slight_frown:")
+		if err != nil {
+			logError("Failed to send error message! " + err.Error())
+		}
+		return
+	}
+	filename := fmt.Sprintf("%s-%s.%s", channel.Name, now.UTC().Format("2006-01-02-150405"), format)
+
+	response := fmt.Sprintf(":file_cabinet: Archived %d messages from <#%s>.", len(transcript.Messages), channelID)
+	archiveDirectory := os.Getenv("ARCHIVE_DIRECTORY")
+	if archiveDirectory != "" {
+		path := filepath.Join(archiveDirectory, m.GuildID+"-"+filename)
+		err = ioutil.WriteFile(path, rendered, 0644)
+		if err != nil {
+			logError("Failed to save transcript! " + err.Error())
+			response += " I couldn't save a copy on the server, though."
+		} else {
+			response += " A copy was saved on the server."
+		}
+	}
+
+	if len(rendered) > maxUploadSize {
+		response += " The transcript is too large to upload here; try a smaller --limit."
+		_, err = s.ChannelMessageSend(m.ChannelID, response)
+	} else {
+		_, err = s.ChannelFileSendWithMessage(m.ChannelID, response, filename, bytes.NewReader(rendered))
+	}
+	if err != nil {
+		logError("Failed to send transcript! " + err.Error())
+		return
+	}
+	logSuccess("Archived channel")
+}
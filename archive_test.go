@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+/**
+Test anything that uses calculation outside of the discordgo commands.
+Those commands don't need to be tested since they are verified to work
+at github.com/bwmarrin/discordgo
+**/
+func TestArchive(t *testing.T) {
+	message := &discordgo.Message{
+		ID:          "300",
+		Content:     "<b>look at this</b>",
+		Timestamp:   "2021-05-01T12:00:00.000000+00:00",
+		Author:      &discordgo.User{ID: "1", Username: "sage", Discriminator: "5429"},
+		Attachments: []*discordgo.MessageAttachment{{Filename: "shrine.png", URL: "https://cdn.example/shrine.png", Size: 10}},
+		Embeds:      []*discordgo.MessageEmbed{{Title: "Shrine of Secrets"}},
+		Reactions:   []*discordgo.MessageReactions{{Emoji: &discordgo.Emoji{Name: "👍"}, Count: 3}},
+	}
+	transcript := Transcript{ChannelName: "general", Messages: []ArchivedMessage{archiveMessage("1", message)}}
+
+	t.Run("Text transcripts include everything attemptCopy reads", func(t *testing.T) {
+		rendered, err := renderTranscript(transcript, "txt")
+		text := string(rendered)
+		if err != nil || !strings.Contains(text, "[2021-05-01 12:00:00] sage#5429: <b>look at this</b>") ||
+			!strings.Contains(text, "shrine.png") || !strings.Contains(text, "Shrine of Secrets") || !strings.Contains(text, "👍 x3") {
+			t.Logf("Rendered text transcript incorrectly: %s", text)
+			t.Fail()
+		}
+	})
+
+	t.Run("HTML transcripts escape message content", func(t *testing.T) {
+		rendered, err := renderTranscript(transcript, "html")
+		if err != nil || strings.Contains(string(rendered), "<b>look") {
+			t.Logf("Failed to escape HTML transcript")
+			t.Fail()
+		}
+	})
+
+	t.Run("JSON transcripts can be read back", func(t *testing.T) {
+		rendered, err := renderTranscript(transcript, "json")
+		var decoded Transcript
+		if err != nil || json.Unmarshal(rendered, &decoded) != nil || len(decoded.Messages) != 1 || decoded.Messages[0].Author != "sage#5429" {
+			t.Logf("Rendered JSON transcript incorrectly: %s", string(rendered))
+			t.Fail()
+		}
+	})
+}
@@ -0,0 +1,1231 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// AutokickPlan : who an autokick run warns, who it kicks, who it skips and why, and anything that
+// went wrong. Aborted explains why the run didn't go ahead at all.
+type AutokickPlan struct {
+	Warn    []AutokickWarning
+	Kick    []MemberActivity
+	Skipped []string
+	Errors  []string
+	Aborted string
+}
+
+// AutokickSchedule : how often a guild's autokick runs, and the hours (UTC) a run may start
+// between. The window wraps past midnight when Start is after End, and is ignored when they match.
+type AutokickSchedule struct {
+	Start    int
+	End      int
+	Interval time.Duration
+}
+
+const defaultAutokickInterval = 6 * time.Hour
+
+// how often the scheduler checks whether any guild is due
+const autokickCheckInterval = 10 * time.Minute
+
+// how long an admin has to confirm ~activity autokick run
+const autokickConfirmWindow = time.Minute
+
+// pendingAutokickRun : a ~activity autokick run waiting for the admin who asked for it to react
+type pendingAutokickRun struct {
+	GuildID   string
+	ChannelID string
+	UserID    string
+	Days      int
+	Expires   time.Time
+}
+
+var pendingAutokickRuns = map[string]pendingAutokickRun{}
+var pendingAutokickRunsLock sync.Mutex
+
+// the most of the server a run may kick, as a percentage, unless the guild sets autokick_safeguard
+const defaultAutokickSafeguard = 10
+
+// AutokickWarning : a member told they're about to be kicked. Cycle is the last activity the
+// warning was about, so a member is warned again only after they've been active since.
+type AutokickWarning struct {
+	Member   MemberActivity
+	Cycle    string
+	WarnedAt time.Time
+	Deadline time.Time
+}
+
+// AutokickOptions : everything besides each member's activity that decides what a run does to them
+type AutokickOptions struct {
+	Days      int
+	WarnDays  int
+	Warnings  map[string]AutokickWarning
+	Inactive  map[string]bool
+	Source    string
+	LastVoice func(memberID string) (time.Time, bool)
+	Exempt    func(memberID string) (string, error)
+}
+
+const defaultAutokickWarning = "You haven't been active in **<<server>>** for a while. If you don't send a message or join a voice channel before <<deadline>>, you will be automatically kicked."
+const defaultInactiveRoleWarning = "You haven't been active in **<<server>>** for a while. If you don't send a message or join a voice channel before <<deadline>>, you will be marked inactive."
+
+// the format last_active is stored in, which is time.Now().String() without the monotonic clock
+const activityDateFormat = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+/**
+Parses a last_active value from the activity table.
+*/
+func parseLastActive(raw string) (time.Time, error) {
+	return time.Parse(activityDateFormat, strings.Split(raw, " m=")[0])
+}
+
+/**
+Returns the time a member's inactivity is counted from. source is "active" for their last activity,
+"online" for when they were last seen online, or "either" for whichever is later. Members who have
+never been seen online fall back to their last activity.
+*/
+func autokickReference(member MemberActivity, source string) (time.Time, error) {
+	lastActive, err := parseLastActive(member.LastActive)
+	if (source != "online" && source != "either") || member.LastOnline == "" {
+		return lastActive, err
+	}
+	lastOnline, onlineErr := parseSQLTimestamp(member.LastOnline)
+	if onlineErr != nil {
+		return lastActive, err
+	}
+	if source == "online" || err != nil || lastOnline.After(lastActive) {
+		return lastOnline, nil
+	}
+	return lastActive, nil
+}
+
+/**
+Works out who has been inactive for at least options.Days days. Whitelisted members and members
+with an exempt role are skipped, as are members already marked inactive. Members whose last
+activity can't be read are reported as errors rather than kicked. If options.WarnDays is set,
+members are warned that many days before their deadline and are only kicked once they've had
+that much notice. options.Source picks what inactivity is counted from (see autokickReference).
+options.LastVoice, if given, returns when the member was last in voice, and options.Exempt, if
+given, returns the name of the exempt role a member has. It's only asked about members who are
+due a warning or kick, and members whose roles can't be checked are reported as errors.
+*/
+func planAutokick(members []MemberActivity, options AutokickOptions, now time.Time) AutokickPlan {
+	var plan AutokickPlan
+	days, warnDays, warnings, lastVoice := options.Days, options.WarnDays, options.Warnings, options.LastVoice
+	for _, member := range members {
+		if options.Inactive[member.MemberID] {
+			continue
+		}
+		lastActive, err := autokickReference(member, options.Source)
+		if err != nil {
+			plan.Errors = append(plan.Errors, fmt.Sprintf("%s: unreadable last activity '%s'", member.MemberName, member.LastActive))
+			continue
+		}
+		if lastVoice != nil {
+			if voice, ok := lastVoice(member.MemberID); ok && voice.After(lastActive) {
+				lastActive = voice
+			}
+		}
+		deadline := lastActive.AddDate(0, 0, days)
+		exemptRole := ""
+		if options.Exempt != nil && member.Whitelisted != 1 && !now.Before(deadline.AddDate(0, 0, -warnDays)) {
+			exemptRole, err = options.Exempt(member.MemberID)
+			if err != nil {
+				plan.Errors = append(plan.Errors, fmt.Sprintf("%s: couldn't check for exempt roles (%s)", member.MemberName, err.Error()))
+				continue
+			}
+		}
+		if warnDays > 0 && member.Whitelisted != 1 && exemptRole == "" {
+			cycle := sqlTimestamp(lastActive)
+			warning, warned := warnings[member.MemberID]
+			if !warned || warning.Cycle != cycle {
+				if now.Before(deadline.AddDate(0, 0, -warnDays)) {
+					continue
+				}
+				// always give the full notice, even if the deadline has already passed
+				if notice := now.AddDate(0, 0, warnDays); notice.After(deadline) {
+					deadline = notice
+				}
+				plan.Warn = append(plan.Warn, AutokickWarning{Member: member, Cycle: cycle, WarnedAt: now, Deadline: deadline})
+				continue
+			}
+			if notice := warning.WarnedAt.AddDate(0, 0, warnDays); notice.After(deadline) {
+				deadline = notice
+			}
+		}
+		if !deadline.Before(now) {
+			continue
+		}
+		if member.Whitelisted == 1 {
+			plan.Skipped = append(plan.Skipped, member.MemberName+" (whitelisted)")
+			continue
+		}
+		if exemptRole != "" {
+			plan.Skipped = append(plan.Skipped, member.MemberName+" (has "+exemptRole+")")
+			continue
+		}
+		plan.Kick = append(plan.Kick, member)
+	}
+	return plan
+}
+
+/**
+Returns the number of days of inactivity before members of the guild are kicked, or 0 if autokick is off.
+*/
+func getAutokickDays(guildID string) int {
+	selectSQL := fmt.Sprintf("SELECT days_until_kick FROM %s WHERE (guild_id = '%s');", autokickTable, guildID)
+	var days int
+	err := connection_pool.QueryRow(selectSQL).Scan(&days)
+	if err != nil {
+		return 0
+	}
+	return days
+}
+
+/**
+Reads every member still in the guild from the activity table.
+*/
+func getGuildActivity(guildID string) ([]MemberActivity, error) {
+	var members []MemberActivity
+	selectSQL := fmt.Sprintf("SELECT %s FROM %s WHERE (guild_id = '%s' AND left_at IS NULL);", activityColumns, activityTable, guildID)
+	query, err := connection_pool.Query(selectSQL)
+	if err != nil {
+		return members, err
+	}
+	defer query.Close()
+	for query.Next() {
+		memberActivity, err := scanMemberActivity(query)
+		if err != nil {
+			return members, err
+		}
+		members = append(members, memberActivity)
+	}
+	return members, nil
+}
+
+/**
+Returns what the guild counts inactivity from: "active", "online" or "either".
+*/
+func getAutokickSource(guildID string) string {
+	source := getGuildSetting(guildID, "autokick_source")
+	if source != "online" && source != "either" {
+		return "active"
+	}
+	return source
+}
+
+/**
+Returns how many days before the kick deadline members are warned, or 0 if they aren't.
+*/
+func getAutokickWarnDays(guildID string) int {
+	days, err := strconv.Atoi(getGuildSetting(guildID, "autokick_warn_days"))
+	if err != nil || days < 1 {
+		return 0
+	}
+	return days
+}
+
+/**
+Reads the autokick warnings sent in the guild, by member ID.
+*/
+func getAutokickWarnings(guildID string) (map[string]AutokickWarning, error) {
+	warnings := map[string]AutokickWarning{}
+	selectSQL := fmt.Sprintf("SELECT member_id, last_active, warned_at FROM %s WHERE (guild_id = '%s');", autokickWarningsTable, guildID)
+	query, err := connection_pool.Query(selectSQL)
+	if err != nil {
+		return warnings, err
+	}
+	defer query.Close()
+	for query.Next() {
+		var memberID, warnedAt string
+		var warning AutokickWarning
+		err = query.Scan(&memberID, &warning.Cycle, &warnedAt)
+		if err != nil {
+			return warnings, err
+		}
+		warning.WarnedAt, err = parseSQLTimestamp(warnedAt)
+		if err != nil {
+			return warnings, err
+		}
+		warnings[memberID] = warning
+	}
+	return warnings, nil
+}
+
+/**
+Fills in the guild's warning message for the member.
+*/
+func formatAutokickWarning(template string, guildName string, memberID string, days int, deadline time.Time) string {
+	message := strings.ReplaceAll(template, "<<server>>", guildName)
+	message = strings.ReplaceAll(message, "<<ping>>", "<@"+memberID+">")
+	message = strings.ReplaceAll(message, "<<days>>", strconv.Itoa(days))
+	message = strings.ReplaceAll(message, "<<deadline>>", deadline.UTC().Format("2006-01-02 15:04")+" UTC")
+	return message
+}
+
+/**
+DMs the member their warning, pings them in the warning channel if there is one, and records
+that they've been warned this cycle.
+*/
+func sendAutokickWarning(s *discordgo.Session, guildID string, days int, warning AutokickWarning) {
+	template := getGuildSetting(guildID, "autokick_warn_message")
+	if template == "" {
+		template = defaultAutokickWarning
+		if getGuildSetting(guildID, "autokick_inactive_role") != "" {
+			template = defaultInactiveRoleWarning
+		}
+	}
+	message := formatAutokickWarning(template, getGuildName(s, guildID), warning.Member.MemberID, days, warning.Deadline)
+	dmUser(s, warning.Member.MemberID, message)
+	if channelID := getGuildSetting(guildID, "autokick_warn_channel"); channelID != "" {
+		if !strings.Contains(template, "<<ping>>") {
+			message = "<@" + warning.Member.MemberID + "> " + message
+		}
+		_, err := s.ChannelMessageSend(channelID, message)
+		if err != nil {
+			logError("Failed to send autokick warning to channel! " + err.Error())
+		}
+	}
+	replaceSQL := fmt.Sprintf("REPLACE INTO %s (guild_id, member_id, last_active, warned_at) VALUES ('%s', '%s', '%s', '%s');",
+		autokickWarningsTable, guildID, warning.Member.MemberID, warning.Cycle, sqlTimestamp(warning.WarnedAt))
+	queryWithoutResults(replaceSQL, "Unable to record autokick warning!")
+}
+
+/**
+Returns the roles whose members are never autokicked.
+*/
+func getAutokickExemptRoles(guildID string) []string {
+	setting := getGuildSetting(guildID, "autokick_exempt_roles")
+	if setting == "" {
+		return nil
+	}
+	return strings.Split(setting, ",")
+}
+
+/**
+Returns a function that names the first exempt role a member has. Members missing from the bot's
+state are fetched from Discord, since large guilds only have some members cached.
+*/
+func autokickExemption(s *discordgo.Session, guildID string) func(string) (string, error) {
+	exemptRoles := getAutokickExemptRoles(guildID)
+	if len(exemptRoles) == 0 {
+		return nil
+	}
+	return func(memberID string) (string, error) {
+		member, err := s.State.Member(guildID, memberID)
+		if err != nil {
+			member, err = s.GuildMember(guildID, memberID)
+			if err != nil {
+				return "", err
+			}
+		}
+		for _, roleID := range member.Roles {
+			for _, exemptID := range exemptRoles {
+				if roleID != exemptID {
+					continue
+				}
+				if role, err := s.State.Role(guildID, roleID); err == nil {
+					return role.Name, nil
+				}
+				return "<@&" + roleID + ">", nil
+			}
+		}
+		return "", nil
+	}
+}
+
+/**
+Loads the guild's members and plans the next autokick run.
+*/
+func buildAutokickPlan(s *discordgo.Session, guildID string, days int) (AutokickPlan, error) {
+	members, err := getGuildActivity(guildID)
+	if err != nil {
+		return AutokickPlan{}, err
+	}
+	options := AutokickOptions{Days: days, WarnDays: getAutokickWarnDays(guildID), Source: getAutokickSource(guildID), Exempt: autokickExemption(s, guildID)}
+	if options.WarnDays > 0 {
+		options.Warnings, err = getAutokickWarnings(guildID)
+		if err != nil {
+			return AutokickPlan{}, err
+		}
+	}
+	if getGuildSetting(guildID, "autokick_inactive_role") != "" {
+		inactive, err := getInactiveMembers(guildID)
+		if err != nil {
+			return AutokickPlan{}, err
+		}
+		// copied so the plan doesn't read the cache while members are being restored
+		options.Inactive = map[string]bool{}
+		inactiveMembersLock.Lock()
+		for memberID := range inactive {
+			options.Inactive[memberID] = true
+		}
+		inactiveMembersLock.Unlock()
+	}
+	if voiceCountsAsActivity(guildID) {
+		options.LastVoice = func(memberID string) (time.Time, bool) {
+			return lastVoiceActivity(guildID, memberID)
+		}
+	}
+	return planAutokick(members, options, time.Now()), nil
+}
+
+/**
+Kicks everyone in the plan, or gives them the inactive role if the guild uses one, unless this is
+a dry run or the plan trips the guild's safeguard. Posts a report of the run to the guild's report
+channel. Returns the plan with failed kicks moved to Skipped.
+*/
+func runAutokick(s *discordgo.Session, guildID string, days int, dryRun bool) AutokickPlan {
+	plan, err := buildAutokickPlan(s, guildID, days)
+	if err != nil {
+		logError("Unable to plan autokick! " + err.Error())
+		plan.Errors = append(plan.Errors, "Unable to read the activity table: "+err.Error())
+		sendAutokickReport(s, guildID, days, dryRun, plan)
+		return plan
+	}
+	plan.Aborted = checkAutokickSafeguard(s, guildID, plan)
+	if plan.Aborted != "" {
+		logWarning("Aborted autokick for guild " + guildID + ". " + plan.Aborted)
+		sendAutokickReport(s, guildID, days, dryRun, plan)
+		return plan
+	}
+
+	if !dryRun {
+		for _, warning := range plan.Warn {
+			sendAutokickWarning(s, guildID, days, warning)
+		}
+		guildName := getGuildName(s, guildID)
+		inactiveRole := getGuildSetting(guildID, "autokick_inactive_role")
+		var kicked []MemberActivity
+		for _, member := range plan.Kick {
+			if inactiveRole != "" {
+				err = markMemberInactive(s, guildID, member.MemberID, inactiveRole)
+			} else {
+				err = s.GuildMemberDeleteWithReason(guildID, member.MemberID, fmt.Sprintf("Bot detected %d or more days of inactivity.", days))
+			}
+			if err != nil {
+				logError("Unable to kick user! " + err.Error())
+				action := "kick"
+				if inactiveRole != "" {
+					action = "inactive role"
+				}
+				plan.Skipped = append(plan.Skipped, fmt.Sprintf("%s (%s failed: %s)", member.MemberName, action, err.Error()))
+				continue
+			}
+			kicked = append(kicked, member)
+			deleteSQL := fmt.Sprintf("DELETE FROM %s WHERE (guild_id = '%s' AND member_id = '%s');", autokickWarningsTable, guildID, member.MemberID)
+			queryWithoutResults(deleteSQL, "Unable to delete autokick warning!")
+			if inactiveRole != "" {
+				dmUser(s, member.MemberID, fmt.Sprintf("You have been marked inactive in **%s** due to %d or more days of inactivity. Send a message or join a voice channel to get your roles back.", guildName, days))
+			} else {
+				dmUser(s, member.MemberID, fmt.Sprintf("You have been automatically kicked from **%s** due to %d or more days of inactivity.", guildName, days))
+			}
+		}
+		plan.Kick = kicked
+	}
+	sendAutokickReport(s, guildID, days, dryRun, plan)
+	return plan
+}
+
+/**
+Works out the roles a member should have once marked inactive: the inactive role plus any managed
+roles (bot and booster roles can't be removed). Also returns the roles that were stripped.
+*/
+func inactiveRoles(current []string, inactiveRole string, managed func(roleID string) bool) ([]string, []string) {
+	roles := []string{inactiveRole}
+	var stripped []string
+	for _, roleID := range current {
+		switch {
+		case roleID == inactiveRole:
+		case managed(roleID):
+			roles = append(roles, roleID)
+		default:
+			stripped = append(stripped, roleID)
+		}
+	}
+	return roles, stripped
+}
+
+/**
+Works out the roles a member should have once active again: everything they have now except the
+inactive role, plus the roles that were stripped from them.
+*/
+func restoredRoles(current []string, stripped []string, inactiveRole string) []string {
+	var roles []string
+	seen := map[string]bool{inactiveRole: true}
+	for _, roleID := range append(append([]string{}, current...), stripped...) {
+		if roleID == "" || seen[roleID] {
+			continue
+		}
+		seen[roleID] = true
+		roles = append(roles, roleID)
+	}
+	return roles
+}
+
+var inactiveMembers = map[string]map[string]bool{}
+var inactiveMembersLock sync.Mutex
+
+/**
+Returns the members of the guild that currently have the inactive role because of autokick.
+*/
+func getInactiveMembers(guildID string) (map[string]bool, error) {
+	inactiveMembersLock.Lock()
+	defer inactiveMembersLock.Unlock()
+	if members, ok := inactiveMembers[guildID]; ok {
+		return members, nil
+	}
+	members := map[string]bool{}
+	selectSQL := fmt.Sprintf("SELECT member_id FROM %s WHERE (guild_id = '%s');", inactiveMembersTable, guildID)
+	query, err := connection_pool.Query(selectSQL)
+	if err != nil {
+		return members, err
+	}
+	defer query.Close()
+	for query.Next() {
+		var memberID string
+		err = query.Scan(&memberID)
+		if err != nil {
+			return members, err
+		}
+		members[memberID] = true
+	}
+	inactiveMembers[guildID] = members
+	return members, nil
+}
+
+/**
+Remembers whether the member is marked inactive without going back to the database.
+*/
+func setMemberInactive(guildID string, memberID string, inactive bool) {
+	inactiveMembersLock.Lock()
+	defer inactiveMembersLock.Unlock()
+	if _, ok := inactiveMembers[guildID]; !ok {
+		return
+	}
+	if inactive {
+		inactiveMembers[guildID][memberID] = true
+	} else {
+		delete(inactiveMembers[guildID], memberID)
+	}
+}
+
+/**
+Gives the member the inactive role and takes away their other roles, remembering them so
+they can be given back.
+*/
+func markMemberInactive(s *discordgo.Session, guildID string, memberID string, inactiveRole string) error {
+	member, err := s.GuildMember(guildID, memberID)
+	if err != nil {
+		return err
+	}
+	roles, stripped := inactiveRoles(member.Roles, inactiveRole, func(roleID string) bool {
+		role, err := s.State.Role(guildID, roleID)
+		return err == nil && role.Managed
+	})
+	replaceSQL := fmt.Sprintf("REPLACE INTO %s (guild_id, member_id, inactive_role, roles, marked_at) VALUES ('%s', '%s', '%s', '%s', '%s');",
+		inactiveMembersTable, guildID, memberID, inactiveRole, strings.Join(stripped, ","), sqlTimestamp(time.Now()))
+	if !queryWithoutResults(replaceSQL, "Unable to record inactive member!") {
+		return fmt.Errorf("unable to save the member's roles")
+	}
+	err = s.GuildMemberEdit(guildID, memberID, roles)
+	if err != nil {
+		deleteSQL := fmt.Sprintf("DELETE FROM %s WHERE (guild_id = '%s' AND member_id = '%s');", inactiveMembersTable, guildID, memberID)
+		queryWithoutResults(deleteSQL, "Unable to delete inactive member!")
+		return err
+	}
+	setMemberInactive(guildID, memberID, true)
+	return nil
+}
+
+/**
+If the member was marked inactive, takes the inactive role away and gives their old roles back.
+Called whenever a member does something that counts as activity.
+*/
+func restoreInactiveMember(s *discordgo.Session, guildID string, memberID string) {
+	inactive, err := getInactiveMembers(guildID)
+	if err != nil {
+		logError("Unable to read inactive members! " + err.Error())
+		return
+	}
+	inactiveMembersLock.Lock()
+	marked := inactive[memberID]
+	inactiveMembersLock.Unlock()
+	if !marked {
+		return
+	}
+
+	selectSQL := fmt.Sprintf("SELECT inactive_role, roles FROM %s WHERE (guild_id = '%s' AND member_id = '%s');", inactiveMembersTable, guildID, memberID)
+	var inactiveRole, stripped string
+	err = connection_pool.QueryRow(selectSQL).Scan(&inactiveRole, &stripped)
+	if err != nil {
+		logError("Unable to read the inactive member's roles! " + err.Error())
+		return
+	}
+	member, err := s.GuildMember(guildID, memberID)
+	if err != nil {
+		logError("Unable to load the inactive member! " + err.Error())
+		return
+	}
+	roles := restoredRoles(member.Roles, strings.Split(stripped, ","), inactiveRole)
+	err = s.GuildMemberEdit(guildID, memberID, roles)
+	if err != nil {
+		logError("Unable to restore the inactive member's roles! " + err.Error())
+		return
+	}
+	deleteSQL := fmt.Sprintf("DELETE FROM %s WHERE (guild_id = '%s' AND member_id = '%s');", inactiveMembersTable, guildID, memberID)
+	queryWithoutResults(deleteSQL, "Unable to delete inactive member!")
+	setMemberInactive(guildID, memberID, false)
+	logSuccess("Restored an inactive member's roles")
+}
+
+/**
+Joins names into an embed field value, noting how many didn't fit.
+*/
+func listForField(names []string) string {
+	if len(names) == 0 {
+		return "None"
+	}
+	value := ""
+	for i, name := range names {
+		line := name + "\n"
+		if len(value)+len(line) > 950 {
+			value += fmt.Sprintf("...and %d more", len(names)-i)
+			break
+		}
+		value += line
+	}
+	return value
+}
+
+/**
+Returns the names of the members.
+*/
+func memberNames(members []MemberActivity) []string {
+	var names []string
+	for _, member := range members {
+		names = append(names, member.MemberName)
+	}
+	return names
+}
+
+/**
+Returns the names of the warned members along with their kick deadlines.
+*/
+func warningNames(warnings []AutokickWarning) []string {
+	var names []string
+	for _, warning := range warnings {
+		names = append(names, warning.Member.MemberName+" (kick after "+warning.Deadline.UTC().Format("2006-01-02")+")")
+	}
+	return names
+}
+
+/**
+Builds an embed listing who a run kicks, warns and skips. pending is for runs that haven't
+happened (previews, dry runs and aborted runs).
+*/
+func autokickPlanEmbed(guildID string, title string, description string, pending bool, plan AutokickPlan) discordgo.MessageEmbed {
+	kickedTitle, warnedTitle := "Kicked", "Warned"
+	if getGuildSetting(guildID, "autokick_inactive_role") != "" {
+		kickedTitle = "Marked inactive"
+	}
+	if pending {
+		kickedTitle, warnedTitle = "Would kick", "Would warn"
+		if getGuildSetting(guildID, "autokick_inactive_role") != "" {
+			kickedTitle = "Would mark inactive"
+		}
+	}
+	var embed discordgo.MessageEmbed
+	embed.Type = "rich"
+	embed.Title = title
+	embed.Description = description
+	embed.Fields = []*discordgo.MessageEmbedField{
+		createField(fmt.Sprintf("%s (%d)", kickedTitle, len(plan.Kick)), listForField(memberNames(plan.Kick)), false),
+		createField(fmt.Sprintf("%s (%d)", warnedTitle, len(plan.Warn)), listForField(warningNames(plan.Warn)), false),
+		createField(fmt.Sprintf("Skipped (%d)", len(plan.Skipped)), listForField(plan.Skipped), false),
+	}
+	if len(plan.Errors) > 0 {
+		embed.Fields = append(embed.Fields, createField(fmt.Sprintf("Errors (%d)", len(plan.Errors)), listForField(plan.Errors), false))
+	}
+	return embed
+}
+
+/**
+Posts the results of an autokick run to the guild's report channel, if one is set.
+*/
+func sendAutokickReport(s *discordgo.Session, guildID string, days int, dryRun bool, plan AutokickPlan) {
+	channelID := getGuildSetting(guildID, "autokick_report_channel")
+	if channelID == "" {
+		return
+	}
+	title := fmt.Sprintf("Autokick run (%d+ days inactive)", days)
+	description := ""
+	switch {
+	case plan.Aborted != "":
+		title = fmt.Sprintf("Autokick aborted (%d+ days inactive)", days)
+		description = plan.Aborted
+	case dryRun:
+		title = fmt.Sprintf("Autokick dry run (%d+ days inactive)", days)
+		description = "Dry run mode is on, so nobody was kicked."
+	}
+	embed := autokickPlanEmbed(guildID, title, description, dryRun || plan.Aborted != "", plan)
+	embed.Timestamp = time.Now().Format(time.RFC3339)
+	_, err := s.ChannelMessageSendEmbed(channelID, &embed)
+	if err != nil {
+		logError("Failed to send autokick report! " + err.Error())
+	}
+}
+
+/**
+Parses a time-of-day window such as "03-05" into its start and end hours (UTC).
+*/
+func parseAutokickWindow(raw string) (int, int, error) {
+	hours := strings.Split(raw, "-")
+	if len(hours) != 2 {
+		return 0, 0, fmt.Errorf("expected a window like 03-05")
+	}
+	start, err := strconv.Atoi(hours[0])
+	if err != nil || start < 0 || start > 23 {
+		return 0, 0, fmt.Errorf("invalid start hour '%s'", hours[0])
+	}
+	end, err := strconv.Atoi(hours[1])
+	if err != nil || end < 0 || end > 23 {
+		return 0, 0, fmt.Errorf("invalid end hour '%s'", hours[1])
+	}
+	return start, end, nil
+}
+
+/**
+Returns the guild's autokick schedule.
+*/
+func getAutokickSchedule(guildID string) AutokickSchedule {
+	schedule := AutokickSchedule{Interval: defaultAutokickInterval}
+	if hours, err := strconv.Atoi(getGuildSetting(guildID, "autokick_interval")); err == nil && hours > 0 {
+		schedule.Interval = time.Duration(hours) * time.Hour
+	}
+	if start, end, err := parseAutokickWindow(getGuildSetting(guildID, "autokick_window")); err == nil {
+		schedule.Start, schedule.End = start, end
+	}
+	return schedule
+}
+
+/**
+Describes the schedule, e.g. "every 6 hours between 03:00 and 05:00 UTC".
+*/
+func (schedule AutokickSchedule) String() string {
+	description := fmt.Sprintf("every %d hours", int(schedule.Interval/time.Hour))
+	if schedule.Start != schedule.End {
+		description += fmt.Sprintf(" between %02d:00 and %02d:00 UTC", schedule.Start, schedule.End)
+	}
+	return description
+}
+
+/**
+Returns whether a run should start now, given when the last one did.
+*/
+func (schedule AutokickSchedule) due(lastRun time.Time, now time.Time) bool {
+	if now.Sub(lastRun) < schedule.Interval {
+		return false
+	}
+	if schedule.Start == schedule.End {
+		return true
+	}
+	hour := now.UTC().Hour()
+	if schedule.Start < schedule.End {
+		return hour >= schedule.Start && hour < schedule.End
+	}
+	return hour >= schedule.Start || hour < schedule.End
+}
+
+/**
+Returns the most of the server, as a percentage, a single run may kick. 0 means no limit.
+*/
+func getAutokickSafeguard(guildID string) int {
+	setting := getGuildSetting(guildID, "autokick_safeguard")
+	if setting == "off" {
+		return 0
+	}
+	percent, err := strconv.Atoi(setting)
+	if err != nil || percent < 1 {
+		return defaultAutokickSafeguard
+	}
+	return percent
+}
+
+/**
+Returns whether kicking this many members out of the total would go over the limit.
+*/
+func exceedsSafeguard(kicking int, total int, percent int) bool {
+	return percent > 0 && total > 0 && kicking*100 > total*percent
+}
+
+/**
+Explains why the plan is too big to go ahead with, or returns "" if it's within the guild's safeguard.
+*/
+func checkAutokickSafeguard(s *discordgo.Session, guildID string, plan AutokickPlan) string {
+	percent := getAutokickSafeguard(guildID)
+	total := len(plan.Kick) + len(plan.Warn) + len(plan.Skipped)
+	if guild, err := s.State.Guild(guildID); err == nil && guild.MemberCount > total {
+		total = guild.MemberCount
+	}
+	if !exceedsSafeguard(len(plan.Kick), total, percent) {
+		return ""
+	}
+	return fmt.Sprintf("This run would remove %d of %d members, more than the %d%% safeguard allows. Nobody was kicked or warned. Check `~activity autokick preview`, then raise the limit with `~activity autokick safeguard <percent/off>` if this is expected.", len(plan.Kick), total, percent)
+}
+
+/**
+Runs autokick for every guild that has it turned on whenever the guild's schedule says it's due.
+*/
+func runAutoKicker(dg *discordgo.Session) {
+	for {
+		selectSQL := fmt.Sprintf("SELECT * FROM %s;", autokickTable)
+		query, err := connection_pool.Query(selectSQL)
+		if err != nil {
+			logError("SELECT query error: " + err.Error())
+		} else {
+			var guilds []AutoKickData
+			for query.Next() {
+				var autokickData AutoKickData
+				err = query.Scan(&autokickData.GuildID, &autokickData.DaysUntilKick)
+				if err != nil {
+					logError("Unable to parse database information! Aborting. " + err.Error())
+					break
+				}
+				guilds = append(guilds, autokickData)
+			}
+			query.Close()
+			now := time.Now()
+			for _, autokickData := range guilds {
+				lastRun, _ := parseSQLTimestamp(getGuildSetting(autokickData.GuildID, "autokick_last_run"))
+				if !getAutokickSchedule(autokickData.GuildID).due(lastRun, now) {
+					continue
+				}
+				logWarning("Performing auto-kick for guild " + autokickData.GuildID)
+				setGuildSetting(autokickData.GuildID, "autokick_last_run", sqlTimestamp(now))
+				runAutokick(dg, autokickData.GuildID, autokickData.DaysUntilKick, getGuildSetting(autokickData.GuildID, "autokick_dry_run") == "on")
+			}
+		}
+
+		time.Sleep(autokickCheckInterval)
+	}
+}
+
+/**
+Handles ~activity autokick: shows or sets the number of days, previews the next run, and
+configures warnings, the report channel and dry run mode.
+*/
+func handleAutokick(s *discordgo.Session, m *discordgo.MessageCreate, command []string) {
+	if !userHasValidPermissions(s, m, discordgo.PermissionManageServer) {
+		logWarning("User without appropriate permissions tried to mess with autokick")
+		_, err := s.ChannelMessageSend(m.ChannelID, "Sorry, you don't have the `Manage Server` permission.")
+		if err != nil {
+			logError("Failed to send permissions message! " + err.Error())
+		}
+		return
+	}
+	usage := "Usages: ```~activity autokick <number>\n~activity autokick preview\n~activity autokick warn <days before kick/off>\n~activity autokick warn message <message/default>\n~activity autokick warn channel <#channel/off>\n~activity autokick exempt add/remove <@role>\n~activity autokick action kick\n~activity autokick action role <@role>\n~activity autokick run\n~activity autokick schedule window <HH-HH/any>\n~activity autokick schedule interval <hours>\n~activity autokick safeguard <percent/off>\n~activity autokick source <active/online/either>\n~activity autokick report <#channel/off>\n~activity autokick --dry-run on/off```"
+
+	if len(command) == 2 {
+		days := getAutokickDays(m.GuildID)
+		response := "Autokick is currently disabled for the server."
+		if days > 0 {
+			response = fmt.Sprintf("Current set to autokick users after %d days of inactivity.", days)
+			if getGuildSetting(m.GuildID, "autokick_dry_run") == "on" {
+				response += " Dry run mode is on, so nobody will actually be kicked."
+			}
+			if warnDays := getAutokickWarnDays(m.GuildID); warnDays > 0 {
+				response += fmt.Sprintf(" Members are warned %d days before they are kicked.", warnDays)
+			}
+			switch getAutokickSource(m.GuildID) {
+			case "online":
+				response += " Inactivity is counted from when members were last seen online."
+			case "either":
+				response += " Inactivity is counted from when members were last active or last seen online, whichever is later."
+			}
+		}
+		_, err := s.ChannelMessageSend(m.ChannelID, response)
+		if err != nil {
+			logError("Failed to send autokick info message! " + err.Error())
+			return
+		}
+		logSuccess("Returned autokick info")
+		return
+	}
+
+	switch command[2] {
+	case "preview":
+		previewAutokick(s, m)
+		return
+	case "run":
+		requestAutokickRun(s, m)
+		return
+	case "report", "--dry-run", "warn", "exempt", "action", "schedule", "safeguard", "source":
+		if command[2] == "schedule" && len(command) == 3 {
+			response := "Autokick runs " + getAutokickSchedule(m.GuildID).String() + "."
+			if lastRun := getGuildSetting(m.GuildID, "autokick_last_run"); lastRun != "" {
+				response += " It last ran at " + lastRun + " UTC."
+			}
+			if percent := getAutokickSafeguard(m.GuildID); percent > 0 {
+				response += fmt.Sprintf(" Runs that would kick more than %d%% of the server are aborted.", percent)
+			}
+			_, err := s.ChannelMessageSend(m.ChannelID, response)
+			if err != nil {
+				logError("Failed to send autokick schedule message! " + err.Error())
+			}
+			return
+		}
+		if command[2] == "exempt" && len(command) == 3 {
+			response := "No roles are exempt from autokick."
+			if exemptRoles := getAutokickExemptRoles(m.GuildID); len(exemptRoles) > 0 {
+				response = "Members with these roles are never autokicked: <@&" + strings.Join(exemptRoles, "> <@&") + ">"
+			}
+			_, err := s.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{Content: response, AllowedMentions: &discordgo.MessageAllowedMentions{}})
+			if err != nil {
+				logError("Failed to send exempt roles message! " + err.Error())
+			}
+			return
+		}
+		if len(command) < 4 {
+			break
+		}
+		var saved bool
+		var response string
+		switch {
+		case command[2] == "warn" && len(command) == 4 && command[3] == "off":
+			saved = deleteGuildSetting(m.GuildID, "autokick_warn_days")
+			response = "Members will no longer be warned before they are kicked."
+		case command[2] == "warn" && len(command) == 4:
+			warnDays, err := strconv.Atoi(command[3])
+			if err != nil || warnDays < 1 {
+				_, err = s.ChannelMessageSend(m.ChannelID, "Please input a valid number.")
+				if err != nil {
+					logError("Failed to send 'invalid number' message! " + err.Error())
+				}
+				return
+			}
+			saved = setGuildSetting(m.GuildID, "autokick_warn_days", command[3])
+			response = fmt.Sprintf("Members will now be warned %d days before they are kicked. Any activity before then cancels the kick.", warnDays)
+		case command[2] == "warn" && len(command) > 4 && command[3] == "message":
+			message := strings.Join(command[4:], " ")
+			if message == "default" {
+				saved = deleteGuildSetting(m.GuildID, "autokick_warn_message")
+				response = "Autokick warnings will now use the default message."
+			} else {
+				saved = setGuildSetting(m.GuildID, "autokick_warn_message", message)
+				response = "Autokick warnings will now say:\n" + formatAutokickWarning(message, getGuildName(s, m.GuildID), m.Author.ID, getAutokickDays(m.GuildID), time.Now().AddDate(0, 0, getAutokickWarnDays(m.GuildID)))
+			}
+		case command[2] == "warn" && len(command) == 5 && command[3] == "channel" && command[4] == "off":
+			saved = deleteGuildSetting(m.GuildID, "autokick_warn_channel")
+			response = "Autokick warnings will now only be sent by DM."
+		case command[2] == "warn" && len(command) == 5 && command[3] == "channel" && strings.HasPrefix(command[4], "<#") && strings.HasSuffix(command[4], ">"):
+			channelID := strings.TrimSuffix(strings.TrimPrefix(command[4], "<#"), ">")
+			saved = setGuildSetting(m.GuildID, "autokick_warn_channel", channelID)
+			response = "Members will also be pinged in <#" + channelID + "> when they are warned."
+		case command[2] == "exempt" && len(command) == 5 && (command[3] == "add" || command[3] == "remove") && strings.HasPrefix(command[4], "<@&") && strings.HasSuffix(command[4], ">"):
+			roleID := strings.TrimSuffix(strings.TrimPrefix(command[4], "<@&"), ">")
+			var exemptRoles []string
+			for _, exemptID := range getAutokickExemptRoles(m.GuildID) {
+				if exemptID != roleID {
+					exemptRoles = append(exemptRoles, exemptID)
+				}
+			}
+			response = "Members with " + command[4] + " can be autokicked again."
+			if command[3] == "add" {
+				exemptRoles = append(exemptRoles, roleID)
+				response = "Members with " + command[4] + " will never be autokicked."
+			}
+			if len(exemptRoles) == 0 {
+				saved = deleteGuildSetting(m.GuildID, "autokick_exempt_roles")
+			} else {
+				saved = setGuildSetting(m.GuildID, "autokick_exempt_roles", strings.Join(exemptRoles, ","))
+			}
+		case command[2] == "action" && len(command) == 4 && command[3] == "kick":
+			saved = deleteGuildSetting(m.GuildID, "autokick_inactive_role")
+			response = "Inactive members will now be kicked."
+		case command[2] == "action" && len(command) == 5 && command[3] == "role" && strings.HasPrefix(command[4], "<@&") && strings.HasSuffix(command[4], ">"):
+			roleID := strings.TrimSuffix(strings.TrimPrefix(command[4], "<@&"), ">")
+			saved = setGuildSetting(m.GuildID, "autokick_inactive_role", roleID)
+			response = "Instead of being kicked, inactive members will now be given " + command[4] + " and lose their other roles. They get them back as soon as they're active again."
+		case command[2] == "schedule" && len(command) == 5 && command[3] == "window":
+			if command[4] == "any" {
+				saved = deleteGuildSetting(m.GuildID, "autokick_window")
+				response = "Autokick can now run at any time of day."
+				break
+			}
+			start, end, err := parseAutokickWindow(command[4])
+			if err != nil {
+				_, err = s.ChannelMessageSend(m.ChannelID, "Please give the window as two UTC hours, e.g. `03-05`.")
+				if err != nil {
+					logError("Failed to send invalid window message! " + err.Error())
+				}
+				return
+			}
+			saved = setGuildSetting(m.GuildID, "autokick_window", command[4])
+			response = fmt.Sprintf("Autokick will now only run between %02d:00 and %02d:00 UTC.", start, end)
+		case command[2] == "schedule" && len(command) == 5 && command[3] == "interval":
+			hours, err := strconv.Atoi(command[4])
+			if err != nil || hours < 1 {
+				_, err = s.ChannelMessageSend(m.ChannelID, "Please input a valid number of hours.")
+				if err != nil {
+					logError("Failed to send invalid hours message! " + err.Error())
+				}
+				return
+			}
+			saved = setGuildSetting(m.GuildID, "autokick_interval", command[4])
+			response = fmt.Sprintf("Autokick will now run every %d hours.", hours)
+		case command[2] == "safeguard" && len(command) == 4:
+			if command[3] == "off" {
+				saved = setGuildSetting(m.GuildID, "autokick_safeguard", "off")
+				response = "Autokick runs will no longer be aborted for kicking too many members."
+				break
+			}
+			percent, err := strconv.Atoi(strings.TrimSuffix(command[3], "%"))
+			if err != nil || percent < 1 || percent > 100 {
+				_, err = s.ChannelMessageSend(m.ChannelID, "Please input a percentage between 1 and 100.")
+				if err != nil {
+					logError("Failed to send invalid percentage message! " + err.Error())
+				}
+				return
+			}
+			saved = setGuildSetting(m.GuildID, "autokick_safeguard", strconv.Itoa(percent))
+			response = fmt.Sprintf("Autokick runs that would kick more than %d%% of the server will now be aborted.", percent)
+		case command[2] == "--dry-run" && len(command) == 4 && (command[3] == "on" || command[3] == "off"):
+			saved = setGuildSetting(m.GuildID, "autokick_dry_run", command[3])
+			response = "Autokick will now kick inactive users."
+			if command[3] == "on" {
+				response = "Dry run mode is on. Autokick runs will report who they would kick without kicking anyone."
+			}
+		case command[2] == "source" && len(command) == 4 && (command[3] == "active" || command[3] == "online" || command[3] == "either"):
+			if command[3] == "active" {
+				saved = deleteGuildSetting(m.GuildID, "autokick_source")
+			} else {
+				saved = setGuildSetting(m.GuildID, "autokick_source", command[3])
+			}
+			response = map[string]string{
+				"active": "Inactivity will now be counted from members' last message, reaction, join or voice activity.",
+				"online": "Inactivity will now be counted from when members were last seen online.",
+				"either": "Inactivity will now be counted from members' last activity or when they were last seen online, whichever is later.",
+			}[command[3]]
+			if command[3] != "active" && !trackPresence {
+				response += " Presence tracking is off for this bot, so members will be judged by their last activity until it's turned on."
+			}
+		case command[2] == "report" && len(command) == 4 && command[3] == "off":
+			saved = deleteGuildSetting(m.GuildID, "autokick_report_channel")
+			response = "Autokick runs will no longer be reported."
+		case command[2] == "report" && len(command) == 4 && strings.HasPrefix(command[3], "<#") && strings.HasSuffix(command[3], ">"):
+			channelID := strings.TrimSuffix(strings.TrimPrefix(command[3], "<#"), ">")
+			saved = setGuildSetting(m.GuildID, "autokick_report_channel", channelID)
+			response = "Autokick runs will now be reported in <#" + channelID + ">."
+		default:
+			_, err := s.ChannelMessageSend(m.ChannelID, usage)
+			if err != nil {
+				logError("Failed to send usage message! " + err.Error())
+			}
+			return
+		}
+		if !saved {
+			response = "An error occurred. Please try again in a moment."
+		}
+		_, err := s.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{Content: response, AllowedMentions: &discordgo.MessageAllowedMentions{}})
+		if err != nil {
+			logError("Failed to send autokick settings message! " + err.Error())
+			return
+		}
+		logSuccess("Updated autokick settings")
+		return
+	}
+	if len(command) != 3 {
+		_, err := s.ChannelMessageSend(m.ChannelID, usage)
+		if err != nil {
+			logError("Failed to send usage message! " + err.Error())
+		}
+		return
+	}
+
+	daysOfInactivity, err := strconv.Atoi(command[2])
+	if err != nil {
+		logError("Failed to convert string passed in to a number! " + err.Error())
+		_, err = s.ChannelMessageSend(m.ChannelID, "Please input a valid number.")
+		if err != nil {
+			logError("Failed to send 'invalid number' message! " + err.Error())
+		}
+		return
+	}
+	logInfo(strconv.Itoa(daysOfInactivity))
+
+	if daysOfInactivity < 1 {
+		// remove autokick time from table
+		deleteSQL := fmt.Sprintf("DELETE FROM %s WHERE (guild_id = '%s');", autokickTable, m.GuildID)
+		if queryWithoutResults(deleteSQL, "Unable to delete autokick entry!") {
+			_, err := s.ChannelMessageSend(m.ChannelID, "The server's auto-kick is now inactive.")
+			if err != nil {
+				logError("Failed to send autokick deactivation message! " + err.Error())
+				return
+			}
+			logSuccess("Removed server from autokick table and notified user")
+		} else {
+			_, err := s.ChannelMessageSend(m.ChannelID, "An error occurred. Please try again in a moment.")
+			if err != nil {
+				logError("Failed to send autokick error message! " + err.Error())
+				return
+			}
+			logWarning("Failed to deleted autokick entry! Is the connection still available?")
+		}
+		return
+	}
+
+	// set autokick day count
+	replaceSQL := fmt.Sprintf("REPLACE INTO %s (guild_id, days_until_kick) VALUES ('%s', %d);", autokickTable, m.GuildID, daysOfInactivity)
+	if queryWithoutResults(replaceSQL, "Unable to insert new entry!") {
+		_, err := s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("The server's auto-kick will now kick users that have been inactive for %d+ days. Use `~activity autokick preview` to see who that would be.", daysOfInactivity))
+		if err != nil {
+			logError("Failed to send autokick update message! " + err.Error())
+			return
+		}
+		logSuccess("Updated server in autokick table and notified user")
+	} else {
+		_, err := s.ChannelMessageSend(m.ChannelID, "An error occurred. Please try again in a moment.")
+		if err != nil {
+			logError("Failed to send autokick error message! " + err.Error())
+			return
+		}
+		logWarning("Failed to insert new autokick entry! Is the connection still available?")
+	}
+}
+
+/**
+Lists exactly who the next autokick run would kick, without kicking anyone.
+*/
+func previewAutokick(s *discordgo.Session, m *discordgo.MessageCreate) {
+	days := getAutokickDays(m.GuildID)
+	if days < 1 {
+		_, err := s.ChannelMessageSend(m.ChannelID, "Autokick is currently disabled for the server.")
+		if err != nil {
+			logError("Failed to send 'autokick disabled' message! " + err.Error())
+		}
+		return
+	}
+	plan, err := buildAutokickPlan(s, m.GuildID, days)
+	if err != nil {
+		logError("Unable to plan autokick! " + err.Error())
+		_, err = s.ChannelMessageSend(m.ChannelID, "An error occurred. Please try again in a moment.")
+		if err != nil {
+			logError("Failed to send error message! " + err.Error())
+		}
+		return
+	}
+
+	description := "Nobody has been kicked. This is who the next run would affect."
+	if aborted := checkAutokickSafeguard(s, m.GuildID, plan); aborted != "" {
+		description += "\n:warning: " + strings.Replace(aborted, "Nobody was kicked or warned. ", "The run will be aborted. ", 1)
+	}
+	embed := autokickPlanEmbed(m.GuildID, fmt.Sprintf("Autokick preview (%d+ days inactive)", days), description, true, plan)
+	_, err = s.ChannelMessageSendEmbed(m.ChannelID, &embed)
+	if err != nil {
+		logError("Failed to send autokick preview! " + err.Error())
+		return
+	}
+	logSuccess("Sent autokick preview")
+}
+
+/**
+Shows who an immediate autokick run would affect and waits for the admin to confirm it with a reaction.
+*/
+func requestAutokickRun(s *discordgo.Session, m *discordgo.MessageCreate) {
+	days := getAutokickDays(m.GuildID)
+	if days < 1 {
+		_, err := s.ChannelMessageSend(m.ChannelID, "Autokick is currently disabled for the server.")
+		if err != nil {
+			logError("Failed to send 'autokick disabled' message! " + err.Error())
+		}
+		return
+	}
+	plan, err := buildAutokickPlan(s, m.GuildID, days)
+	if err != nil {
+		logError("Unable to plan autokick! " + err.Error())
+		_, err = s.ChannelMessageSend(m.ChannelID, "An error occurred. Please try again in a moment.")
+		if err != nil {
+			logError("Failed to send error message! " + err.Error())
+		}
+		return
+	}
+
+	description := "React with ✅ within a minute to run autokick now, or ❌ to cancel."
+	if getGuildSetting(m.GuildID, "autokick_dry_run") == "on" {
+		description += "\nDry run mode is on, so nobody will actually be kicked."
+	}
+	if aborted := checkAutokickSafeguard(s, m.GuildID, plan); aborted != "" {
+		description += "\n:warning: " + strings.Replace(aborted, "Nobody was kicked or warned. ", "The run will be aborted. ", 1)
+	}
+	embed := autokickPlanEmbed(m.GuildID, fmt.Sprintf("Run autokick now? (%d+ days inactive)", days), description, true, plan)
+	message, err := s.ChannelMessageSendEmbed(m.ChannelID, &embed)
+	if err != nil {
+		logError("Failed to send autokick confirmation! " + err.Error())
+		return
+	}
+	pendingAutokickRunsLock.Lock()
+	for messageID, pending := range pendingAutokickRuns {
+		if time.Now().After(pending.Expires) {
+			delete(pendingAutokickRuns, messageID)
+		}
+	}
+	pendingAutokickRuns[message.ID] = pendingAutokickRun{GuildID: m.GuildID, ChannelID: m.ChannelID, UserID: m.Author.ID, Days: days, Expires: time.Now().Add(autokickConfirmWindow)}
+	pendingAutokickRunsLock.Unlock()
+	for _, emoji := range []string{"✅", "❌"} {
+		err = s.MessageReactionAdd(m.ChannelID, message.ID, emoji)
+		if err != nil {
+			logError("Failed to add reaction to autokick confirmation! " + err.Error())
+		}
+	}
+	logSuccess("Sent autokick confirmation")
+}
+
+/**
+Runs or cancels a pending ~activity autokick run when the admin who asked for it reacts.
+*/
+func confirmAutokickRun(s *discordgo.Session, m *discordgo.MessageReactionAdd) {
+	if m.Emoji.Name != "✅" && m.Emoji.Name != "❌" {
+		return
+	}
+	pendingAutokickRunsLock.Lock()
+	pending, ok := pendingAutokickRuns[m.MessageID]
+	if !ok || m.UserID != pending.UserID {
+		pendingAutokickRunsLock.Unlock()
+		return
+	}
+	delete(pendingAutokickRuns, m.MessageID)
+	pendingAutokickRunsLock.Unlock()
+
+	response := "Autokick run cancelled."
+	switch {
+	case time.Now().After(pending.Expires):
+		response = "That confirmation expired. Use `~activity autokick run` again."
+	case m.Emoji.Name == "✅":
+		setGuildSetting(pending.GuildID, "autokick_last_run", sqlTimestamp(time.Now()))
+		dryRun := getGuildSetting(pending.GuildID, "autokick_dry_run") == "on"
+		plan := runAutokick(s, pending.GuildID, pending.Days, dryRun)
+		switch {
+		case plan.Aborted != "":
+			response = plan.Aborted
+		case dryRun:
+			response = fmt.Sprintf("Dry run finished: would have removed %d, warned %d and skipped %d members.", len(plan.Kick), len(plan.Warn), len(plan.Skipped))
+		default:
+			response = fmt.Sprintf("Autokick finished: removed %d, warned %d and skipped %d members.", len(plan.Kick), len(plan.Warn), len(plan.Skipped))
+		}
+		if len(plan.Errors) > 0 {
+			response += fmt.Sprintf(" %d errors were reported.", len(plan.Errors))
+		}
+	}
+	_, err := s.ChannelMessageSend(pending.ChannelID, response)
+	if err != nil {
+		logError("Failed to send autokick run result! " + err.Error())
+		return
+	}
+	logSuccess("Handled autokick confirmation")
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+/**
+Test anything that uses calculation outside of the discordgo commands.
+Those commands don't need to be tested since they are verified to work
+at github.com/bwmarrin/discordgo
+**/
+func TestAutokick(t *testing.T) {
+	now := time.Date(2021, 6, 30, 12, 0, 0, 0, time.UTC)
+	active := now.AddDate(0, 0, -2).String()
+	inactive := now.AddDate(0, 0, -40).String() + " m=+1234.5678"
+	members := []MemberActivity{
+		{MemberID: "1", MemberName: "active", LastActive: active},
+		{MemberID: "2", MemberName: "inactive", LastActive: inactive},
+		{MemberID: "3", MemberName: "whitelisted", LastActive: inactive, Whitelisted: 1},
+		{MemberID: "4", MemberName: "broken", LastActive: "not a date"},
+	}
+
+	t.Run("Only inactive, non-whitelisted members are kicked", func(t *testing.T) {
+		plan := planAutokick(members, AutokickOptions{Days: 30}, now)
+		if len(plan.Kick) != 1 || plan.Kick[0].MemberID != "2" {
+			t.Logf("Expected only member 2 to be kicked, got %v", plan.Kick)
+			t.Fail()
+		}
+		if len(plan.Skipped) != 1 || plan.Skipped[0] != "whitelisted (whitelisted)" {
+			t.Logf("Expected the whitelisted member to be skipped, got %v", plan.Skipped)
+			t.Fail()
+		}
+	})
+
+	t.Run("Unreadable dates are reported and never kicked", func(t *testing.T) {
+		plan := planAutokick(members, AutokickOptions{Days: 30}, now)
+		if len(plan.Errors) != 1 {
+			t.Logf("Expected one error, got %v", plan.Errors)
+			t.Fail()
+		}
+		for _, member := range plan.Kick {
+			if member.MemberID == "4" {
+				t.Log("Member with an unreadable date was kicked")
+				t.Fail()
+			}
+		}
+	})
+
+	t.Run("Recent voice activity keeps a member", func(t *testing.T) {
+		lastVoice := func(memberID string) (time.Time, bool) {
+			return now.AddDate(0, 0, -1), memberID == "2"
+		}
+		plan := planAutokick(members, AutokickOptions{Days: 30, LastVoice: lastVoice}, now)
+		if len(plan.Kick) != 0 {
+			t.Logf("Expected nobody to be kicked, got %v", plan.Kick)
+			t.Fail()
+		}
+	})
+
+	t.Run("Members are warned before they are kicked", func(t *testing.T) {
+		plan := planAutokick(members, AutokickOptions{Days: 30, WarnDays: 3}, now)
+		if len(plan.Kick) != 0 || len(plan.Warn) != 1 || plan.Warn[0].Member.MemberID != "2" {
+			t.Logf("Expected member 2 to be warned instead of kicked, got %v / %v", plan.Warn, plan.Kick)
+			t.Fail()
+		}
+		if len(plan.Warn) == 1 && !plan.Warn[0].Deadline.Equal(now.AddDate(0, 0, 3)) {
+			t.Logf("Expected the full 3 days of notice, got a deadline of %v", plan.Warn[0].Deadline)
+			t.Fail()
+		}
+	})
+
+	t.Run("Members are warned once and kicked after the notice", func(t *testing.T) {
+		lastActive, _ := parseLastActive(inactive)
+		warnings := map[string]AutokickWarning{"2": {Cycle: sqlTimestamp(lastActive), WarnedAt: now.AddDate(0, 0, -1)}}
+		plan := planAutokick(members, AutokickOptions{Days: 30, WarnDays: 3, Warnings: warnings}, now)
+		if len(plan.Warn) != 0 || len(plan.Kick) != 0 {
+			t.Logf("Expected nothing to happen during the notice, got %v / %v", plan.Warn, plan.Kick)
+			t.Fail()
+		}
+		plan = planAutokick(members, AutokickOptions{Days: 30, WarnDays: 3, Warnings: warnings}, now.AddDate(0, 0, 3))
+		if len(plan.Kick) != 1 || plan.Kick[0].MemberID != "2" {
+			t.Logf("Expected member 2 to be kicked after the notice, got %v", plan.Kick)
+			t.Fail()
+		}
+	})
+
+	t.Run("Activity since a warning starts a new cycle", func(t *testing.T) {
+		warnings := map[string]AutokickWarning{"1": {Cycle: sqlTimestamp(now.AddDate(0, 0, -60)), WarnedAt: now.AddDate(0, 0, -35)}}
+		plan := planAutokick(members, AutokickOptions{Days: 30, WarnDays: 3, Warnings: warnings}, now.AddDate(0, 0, 26))
+		if len(plan.Warn) != 2 {
+			t.Logf("Expected member 1 to be warned again, got %v", plan.Warn)
+			t.Fail()
+		}
+		if formatted := formatAutokickWarning("<<ping>> <<server>> <<days>> <<deadline>>", "Server", "1", 30, now); formatted != "<@1> Server 30 2021-06-30 12:00 UTC" {
+			t.Logf("Unexpected warning message '%s'", formatted)
+			t.Fail()
+		}
+	})
+
+	t.Run("Members with an exempt role are skipped", func(t *testing.T) {
+		exempt := func(memberID string) (string, error) {
+			if memberID == "2" {
+				return "Staff", nil
+			}
+			return "", nil
+		}
+		plan := planAutokick(members, AutokickOptions{Days: 30, Exempt: exempt}, now)
+		if len(plan.Kick) != 0 || len(plan.Skipped) != 2 || plan.Skipped[0] != "inactive (has Staff)" {
+			t.Logf("Expected member 2 to be skipped for their role, got %v / %v", plan.Kick, plan.Skipped)
+			t.Fail()
+		}
+	})
+
+	t.Run("Members whose roles can't be checked are never kicked", func(t *testing.T) {
+		exempt := func(memberID string) (string, error) {
+			return "", errors.New("unknown member")
+		}
+		plan := planAutokick(members, AutokickOptions{Days: 30, Exempt: exempt}, now)
+		if len(plan.Kick) != 0 || len(plan.Errors) != 2 || !strings.HasPrefix(plan.Errors[0], "inactive: couldn't check") {
+			t.Logf("Expected member 2 to be reported as an error, got %v / %v", plan.Kick, plan.Errors)
+			t.Fail()
+		}
+	})
+
+	t.Run("Members already marked inactive are left alone", func(t *testing.T) {
+		plan := planAutokick(members, AutokickOptions{Days: 30, Inactive: map[string]bool{"2": true}}, now)
+		if len(plan.Kick) != 0 {
+			t.Logf("Expected nobody to be kicked, got %v", plan.Kick)
+			t.Fail()
+		}
+	})
+
+	t.Run("The inactive role replaces everything but managed roles", func(t *testing.T) {
+		managed := func(roleID string) bool { return roleID == "booster" }
+		roles, stripped := inactiveRoles([]string{"staff", "booster", "fan"}, "inactive", managed)
+		if strings.Join(roles, ",") != "inactive,booster" || strings.Join(stripped, ",") != "staff,fan" {
+			t.Logf("Unexpected roles %v, stripped %v", roles, stripped)
+			t.Fail()
+		}
+		restored := restoredRoles([]string{"inactive", "booster", "new"}, []string{"staff", "fan", "new"}, "inactive")
+		if strings.Join(restored, ",") != "booster,new,staff,fan" {
+			t.Logf("Unexpected restored roles %v", restored)
+			t.Fail()
+		}
+	})
+
+	t.Run("Runs only start inside the window once the interval has passed", func(t *testing.T) {
+		start, end, err := parseAutokickWindow("22-04")
+		if err != nil || start != 22 || end != 4 {
+			t.Logf("Expected 22-04 to parse, got %d %d %v", start, end, err)
+			t.Fail()
+		}
+		if _, _, err = parseAutokickWindow("25-04"); err == nil {
+			t.Log("Expected hour 25 to be rejected")
+			t.Fail()
+		}
+		schedule := AutokickSchedule{Start: start, End: end, Interval: 6 * time.Hour}
+		night := time.Date(2021, 6, 30, 23, 0, 0, 0, time.UTC)
+		if !schedule.due(night.Add(-7*time.Hour), night) || !schedule.due(night.Add(-7*time.Hour), night.Add(4*time.Hour)) {
+			t.Log("Expected runs to be due inside a window that wraps past midnight")
+			t.Fail()
+		}
+		if schedule.due(night.Add(-7*time.Hour), now) || schedule.due(night.Add(-time.Hour), night) {
+			t.Log("Expected runs outside the window or inside the interval not to be due")
+			t.Fail()
+		}
+		if !(AutokickSchedule{Interval: time.Hour}).due(time.Time{}, now) {
+			t.Log("Expected a schedule without a window to be due")
+			t.Fail()
+		}
+	})
+
+	t.Run("Inactivity can be counted from when members were last seen online", func(t *testing.T) {
+		lurker := []MemberActivity{{MemberID: "5", MemberName: "lurker", LastActive: inactive, LastOnline: sqlTimestamp(now.AddDate(0, 0, -1))}}
+		if plan := planAutokick(lurker, AutokickOptions{Days: 30}, now); len(plan.Kick) != 1 {
+			t.Logf("Expected the lurker to be kicked by last activity, got %v", plan.Kick)
+			t.Fail()
+		}
+		for _, source := range []string{"online", "either"} {
+			if plan := planAutokick(lurker, AutokickOptions{Days: 30, Source: source}, now); len(plan.Kick) != 0 {
+				t.Logf("Expected the lurker to be kept with source %s, got %v", source, plan.Kick)
+				t.Fail()
+			}
+		}
+		stale := MemberActivity{LastActive: active, LastOnline: sqlTimestamp(now.AddDate(0, 0, -40))}
+		reference, err := autokickReference(stale, "either")
+		if expected, _ := parseLastActive(active); err != nil || !reference.Equal(expected) {
+			t.Logf("Expected the later last activity to be used, got %v %v", reference, err)
+			t.Fail()
+		}
+		if reference, err = autokickReference(members[1], "online"); err != nil || reference.Year() != 2021 {
+			t.Logf("Expected members never seen online to fall back to their last activity, got %v %v", reference, err)
+			t.Fail()
+		}
+	})
+
+	t.Run("The safeguard stops runs that kick too much of the server", func(t *testing.T) {
+		if !exceedsSafeguard(11, 100, 10) || exceedsSafeguard(10, 100, 10) || exceedsSafeguard(90, 100, 0) {
+			t.Log("Safeguard limits were applied incorrectly")
+			t.Fail()
+		}
+	})
+}
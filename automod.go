@@ -0,0 +1,438 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// AutomodRule : a banned word or pattern, what to do when it is matched, and who it doesn't apply to
+type AutomodRule struct {
+	ID             int    `json:"entry"`
+	GuildID        string `json:"guild_id"`
+	Pattern        string `json:"pattern"`
+	MatchType      string `json:"match_type"`
+	Action         string `json:"action"`
+	ExemptChannels string `json:"exempt_channels"`
+	ExemptRoles    string `json:"exempt_roles"`
+	regex          *regexp.Regexp
+}
+
+// rules are checked on every message, so each guild's rules are kept in memory until they change
+var automodCache = map[string][]AutomodRule{}
+var automodCacheLock sync.Mutex
+
+// how long automod mutes last unless the guild sets automod_mute_duration
+const defaultAutomodMuteDuration = "10m"
+
+/**
+Builds the rule's regular expression. Words only match on their own, substrings match anywhere,
+and regex rules are used as written. Word and substring rules ignore case.
+*/
+func (rule *AutomodRule) compile() error {
+	var err error
+	switch rule.MatchType {
+	case "word":
+		rule.regex, err = regexp.Compile(`(?i)(^|\W)` + regexp.QuoteMeta(rule.Pattern) + `($|\W)`)
+	case "substring":
+		rule.regex, err = regexp.Compile(`(?i)` + regexp.QuoteMeta(rule.Pattern))
+	case "regex":
+		rule.regex, err = regexp.Compile(rule.Pattern)
+	default:
+		err = fmt.Errorf("unknown match type '%s'", rule.MatchType)
+	}
+	return err
+}
+
+/**
+Returns true if the rule does not apply in the channel or to someone with one of the roles.
+*/
+func (rule AutomodRule) exempt(channelID string, roles []string) bool {
+	for _, exemptChannel := range strings.Split(rule.ExemptChannels, ",") {
+		if exemptChannel != "" && exemptChannel == channelID {
+			return true
+		}
+	}
+	for _, exemptRole := range strings.Split(rule.ExemptRoles, ",") {
+		for _, role := range roles {
+			if exemptRole != "" && exemptRole == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+/**
+Returns the first rule the message breaks, or nil if it breaks none.
+*/
+func findAutomodViolation(rules []AutomodRule, channelID string, roles []string, content string) *AutomodRule {
+	for i, rule := range rules {
+		if rule.regex != nil && !rule.exempt(channelID, roles) && rule.regex.MatchString(content) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+/**
+Returns the guild's automod rules, loading them from the database if they aren't cached.
+*/
+func getAutomodRules(guildID string) []AutomodRule {
+	automodCacheLock.Lock()
+	defer automodCacheLock.Unlock()
+	if rules, ok := automodCache[guildID]; ok {
+		return rules
+	}
+
+	var rules []AutomodRule
+	selectSQL := fmt.Sprintf("SELECT * FROM %s WHERE (guild_id = '%s') ORDER BY entry;", automodTable, guildID)
+	query, err := connection_pool.Query(selectSQL)
+	if err != nil {
+		logError("SELECT query error: " + err.Error())
+		return rules
+	}
+	defer query.Close()
+
+	for query.Next() {
+		var rule AutomodRule
+		err = query.Scan(&rule.ID, &rule.GuildID, &rule.Pattern, &rule.MatchType, &rule.Action, &rule.ExemptChannels, &rule.ExemptRoles)
+		if err != nil {
+			logError("Unable to parse database information! Aborting. " + err.Error())
+			return rules
+		}
+		err = rule.compile()
+		if err != nil {
+			logWarning("Skipping automod rule that doesn't compile. " + err.Error())
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	automodCache[guildID] = rules
+	return rules
+}
+
+/**
+Forgets the guild's cached rules so they are reloaded on the next message.
+*/
+func clearAutomodCache(guildID string) {
+	automodCacheLock.Lock()
+	delete(automodCache, guildID)
+	automodCacheLock.Unlock()
+}
+
+/**
+Reports whether automod leaves a message alone: staff with Manage Messages are never punished,
+and neither are bot commands from members allowed to run ~automod.
+*/
+func automodExempt(perms int64, content string) bool {
+	if permissionsInclude(perms, discordgo.PermissionManageMessages) {
+		return true
+	}
+	return strings.HasPrefix(content, prefix) && permissionsInclude(perms, discordgo.PermissionManageServer)
+}
+
+/**
+Checks a new message against the guild's automod rules and acts on the first one it breaks.
+*/
+func checkAutomod(s *discordgo.Session, m *discordgo.MessageCreate) {
+	if m.GuildID == "" || m.Author == nil || m.Author.Bot {
+		return
+	}
+	rules := getAutomodRules(m.GuildID)
+	if len(rules) == 0 {
+		return
+	}
+	var roles []string
+	if m.Member != nil {
+		roles = m.Member.Roles
+	}
+	rule := findAutomodViolation(rules, m.ChannelID, roles, m.Content)
+	if rule == nil {
+		return
+	}
+	// staff are trusted, and people who can change automod need to be able to type its patterns
+	perms, err := s.UserChannelPermissions(m.Author.ID, m.ChannelID)
+	if err == nil && automodExempt(perms, m.Content) {
+		return
+	}
+	logInfo(fmt.Sprintf("Message broke automod rule #%d", rule.ID))
+
+	reason := fmt.Sprintf("Automod rule #%d", rule.ID)
+	result := "Logged only"
+	if rule.Action != "log" {
+		err = s.ChannelMessageDelete(m.ChannelID, m.ID)
+		result = "Deleted the message"
+	}
+	if err == nil {
+		switch rule.Action {
+		case "warn":
+			var escalation string
+			escalation, err = warnMember(s, m.GuildID, m.Author.ID, s.State.User, reason)
+			result += " and warned the user"
+			if escalation != "" {
+				result += ", who was automatically " + escalation
+			}
+		case "mute":
+			duration := getGuildSetting(m.GuildID, "automod_mute_duration")
+			if duration == "" {
+				duration = defaultAutomodMuteDuration
+			}
+			var parsed time.Duration
+			parsed, err = parseDuration(duration)
+			if err == nil {
+				err = muteMember(s, m.GuildID, m.Author.ID, parsed, s.State.User, reason)
+			}
+			result += " and muted the user for " + duration
+		case "kick":
+			err = kickMember(s, m.GuildID, m.Author.ID, s.State.User.Username+"#"+s.State.User.Discriminator, reason)
+			result += " and kicked the user"
+		}
+	}
+	if err != nil {
+		logError("Failed to carry out automod action! " + err.Error())
+		result += fmt.Sprintf(" (failed: %s)", err.Error())
+	}
+
+	var embed discordgo.MessageEmbed
+	embed.Title = "Automod: " + describeAutomodRule(*rule)
+	embed.Description = fmt.Sprintf("<@%s> in <#%s>", m.Author.ID, m.ChannelID)
+	embed.Fields = []*discordgo.MessageEmbedField{
+		createField("Message", "```"+strings.ReplaceAll(truncateField(m.Content, 1000), "`", "'")+"```", false),
+		createField("Action", result, false),
+	}
+	embed.Timestamp = time.Now().Format(time.RFC3339)
+	sendModLog(s, m.GuildID, &embed)
+}
+
+/**
+Formats a rule for display, e.g. `#3 word "heck" → warn`.
+*/
+func describeAutomodRule(rule AutomodRule) string {
+	return fmt.Sprintf("#%d %s \"%s\" → %s", rule.ID, rule.MatchType, rule.Pattern, rule.Action)
+}
+
+/****
+COMMANDS
+****/
+
+/**
+Lets admins add, remove and list the guild's automod rules.
+**/
+func handleAutomod(s *discordgo.Session, m *discordgo.MessageCreate, command []string) {
+	logInfo(strings.Join(command, " "))
+	if !userHasValidPermissions(s, m, discordgo.PermissionManageServer) {
+		logWarning("User attempted to change automod without proper permissions")
+		_, err := s.ChannelMessageSend(m.ChannelID, "Sorry, you don't have the `Manage Server` permission.")
+		if err != nil {
+			logError("Failed to send permissions message! " + err.Error())
+		}
+		return
+	}
+	usage := "Usages: ```~automod list\n~automod add <word/substring/regex> <pattern> (optional: --action delete/warn/mute/kick/log) (optional: --exempt <#channel/@role> ...)\n~automod remove <rule number>\n~automod muteduration <duration>```"
+	if len(command) < 2 {
+		_, err := s.ChannelMessageSend(m.ChannelID, usage)
+		if err != nil {
+			logError("Failed to send usage message! " + err.Error())
+		}
+		return
+	}
+
+	switch command[1] {
+	case "list":
+		rules := getAutomodRules(m.GuildID)
+		if len(rules) == 0 {
+			_, err := s.ChannelMessageSend(m.ChannelID, "This server has no automod rules.")
+			if err != nil {
+				logError("Failed to send 'no rules' message! " + err.Error())
+			}
+			return
+		}
+		var contents []*discordgo.MessageEmbedField
+		for _, rule := range rules {
+			var exemptions []string
+			for _, channelID := range strings.Split(rule.ExemptChannels, ",") {
+				if channelID != "" {
+					exemptions = append(exemptions, "<#"+channelID+">")
+				}
+			}
+			for _, roleID := range strings.Split(rule.ExemptRoles, ",") {
+				if roleID != "" {
+					exemptions = append(exemptions, "<@&"+roleID+">")
+				}
+			}
+			value := "No exemptions"
+			if len(exemptions) > 0 {
+				value = "Exempt: " + strings.Join(exemptions, ", ")
+			}
+			contents = append(contents, createField(describeAutomodRule(rule), value, false))
+			if len(contents) == 25 {
+				break
+			}
+		}
+		var embed discordgo.MessageEmbed
+		embed.Type = "rich"
+		embed.Title = "Automod Rules"
+		embed.Fields = contents
+		_, err := s.ChannelMessageSendEmbed(m.ChannelID, &embed)
+		if err != nil {
+			logError("Failed to send automod rules message! " + err.Error())
+			return
+		}
+		logSuccess("Sent automod rules")
+	case "add":
+		if len(command) < 4 {
+			_, err := s.ChannelMessageSend(m.ChannelID, usage)
+			if err != nil {
+				logError("Failed to send usage message! " + err.Error())
+			}
+			return
+		}
+		rule, err := parseAutomodRule(command)
+		if err != nil {
+			_, err = s.ChannelMessageSend(m.ChannelID, "Couldn't add the rule: "+err.Error())
+			if err != nil {
+				logError("Failed to send invalid rule message! " + err.Error())
+			}
+			return
+		}
+		insertSQL := fmt.Sprintf("INSERT INTO %s (guild_id, pattern, match_type, action, exempt_channels, exempt_roles) VALUES ('%s', '%s', '%s', '%s', '%s', '%s');",
+			automodTable, m.GuildID, escapeSQL(rule.Pattern), rule.MatchType, rule.Action, rule.ExemptChannels, rule.ExemptRoles)
+		if !queryWithoutResults(insertSQL, "Unable to insert automod rule!") {
+			_, err = s.ChannelMessageSend(m.ChannelID, "An error occurred. Please try again in a moment.")
+			if err != nil {
+				logError("Failed to send error message! " + err.Error())
+			}
+			return
+		}
+		clearAutomodCache(m.GuildID)
+		_, err = s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Added automod rule: %s \"%s\" → %s", rule.MatchType, rule.Pattern, rule.Action))
+		if err != nil {
+			logError("Failed to send automod result message! " + err.Error())
+			return
+		}
+		logSuccess("Added automod rule")
+	case "remove":
+		if len(command) != 3 {
+			_, err := s.ChannelMessageSend(m.ChannelID, usage)
+			if err != nil {
+				logError("Failed to send usage message! " + err.Error())
+			}
+			return
+		}
+		ruleID, err := strconv.Atoi(strings.TrimPrefix(command[2], "#"))
+		if err != nil {
+			_, err = s.ChannelMessageSend(m.ChannelID, "Please input a valid rule number.")
+			if err != nil {
+				logError("Failed to send 'invalid number' message! " + err.Error())
+			}
+			return
+		}
+		deleteSQL := fmt.Sprintf("DELETE FROM %s WHERE (guild_id = '%s' AND entry = %d);", automodTable, m.GuildID, ruleID)
+		if !queryWithoutResults(deleteSQL, "Unable to delete automod rule!") {
+			_, err = s.ChannelMessageSend(m.ChannelID, "An error occurred. Please try again in a moment.")
+			if err != nil {
+				logError("Failed to send error message! " + err.Error())
+			}
+			return
+		}
+		clearAutomodCache(m.GuildID)
+		_, err = s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Removed automod rule #%d, if it existed.", ruleID))
+		if err != nil {
+			logError("Failed to send automod result message! " + err.Error())
+			return
+		}
+		logSuccess("Removed automod rule")
+	case "muteduration":
+		if len(command) != 3 {
+			_, err := s.ChannelMessageSend(m.ChannelID, usage)
+			if err != nil {
+				logError("Failed to send usage message! " + err.Error())
+			}
+			return
+		}
+		if parsed, err := parseDuration(command[2]); err != nil || parsed <= 0 {
+			_, err = s.ChannelMessageSend(m.ChannelID, "Please input a valid duration, e.g. `30m`, `12h` or `2d`.")
+			if err != nil {
+				logError("Failed to send invalid duration message! " + err.Error())
+			}
+			return
+		}
+		response := "Automod mutes will now last " + command[2] + "."
+		if !setGuildSetting(m.GuildID, "automod_mute_duration", command[2]) {
+			response = "An error occurred. Please try again in a moment."
+		}
+		_, err := s.ChannelMessageSend(m.ChannelID, response)
+		if err != nil {
+			logError("Failed to send automod result message! " + err.Error())
+			return
+		}
+		logSuccess("Set automod mute duration")
+	default:
+		_, err := s.ChannelMessageSend(m.ChannelID, usage)
+		if err != nil {
+			logError("Failed to send usage message! " + err.Error())
+		}
+	}
+}
+
+/**
+Parses `~automod add <type> <pattern> (--action <action>) (--exempt <#channel/@role> ...)`.
+The pattern is every word up to the first flag.
+*/
+func parseAutomodRule(command []string) (AutomodRule, error) {
+	rule := AutomodRule{MatchType: strings.ToLower(command[2]), Action: "delete"}
+	i := 3
+	var pattern []string
+	for ; i < len(command) && !strings.HasPrefix(command[i], "--"); i++ {
+		pattern = append(pattern, command[i])
+	}
+	rule.Pattern = strings.Join(pattern, " ")
+	if rule.Pattern == "" {
+		return rule, fmt.Errorf("the pattern is missing")
+	}
+
+	var exemptChannels []string
+	var exemptRoles []string
+	for ; i < len(command); i++ {
+		switch command[i] {
+		case "--action":
+			if i+1 >= len(command) {
+				return rule, fmt.Errorf("--action needs delete, warn, mute, kick or log")
+			}
+			i++
+			rule.Action = strings.ToLower(command[i])
+		case "--exempt":
+			for i+1 < len(command) && !strings.HasPrefix(command[i+1], "--") {
+				i++
+				target := command[i]
+				if regexp.MustCompile(`^<#[0-9]+>$`).MatchString(target) {
+					exemptChannels = append(exemptChannels, strings.Trim(target, "<#>"))
+				} else if regexp.MustCompile(`^<@&[0-9]+>$`).MatchString(target) {
+					exemptRoles = append(exemptRoles, strings.Trim(target, "<@&>"))
+				} else {
+					return rule, fmt.Errorf("exemptions must be #channels or @roles")
+				}
+			}
+		default:
+			return rule, fmt.Errorf("unknown option '%s'", command[i])
+		}
+	}
+	rule.ExemptChannels = strings.Join(exemptChannels, ",")
+	rule.ExemptRoles = strings.Join(exemptRoles, ",")
+
+	switch rule.Action {
+	case "delete", "warn", "mute", "kick", "log":
+	default:
+		return rule, fmt.Errorf("the action must be delete, warn, mute, kick or log")
+	}
+	if err := rule.compile(); err != nil {
+		return rule, fmt.Errorf("the type must be word, substring or regex, and regex patterns must be valid")
+	}
+	return rule, nil
+}
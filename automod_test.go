@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+/**
+Test anything that uses calculation outside of the discordgo commands.
+Those commands don't need to be tested since they are verified to work
+at github.com/bwmarrin/discordgo
+**/
+func TestAutomod(t *testing.T) {
+	t.Run("Rules are parsed with actions and exemptions", func(t *testing.T) {
+		rule, err := parseAutomodRule(strings.Split("~automod add word free nitro --action warn --exempt <#111> <@&222>", " "))
+		if err != nil || rule.Pattern != "free nitro" || rule.Action != "warn" || rule.ExemptChannels != "111" || rule.ExemptRoles != "222" {
+			t.Logf("Parsed rule incorrectly: %+v %v", rule, err)
+			t.Fail()
+		}
+	})
+
+	t.Run("Invalid rules are rejected", func(t *testing.T) {
+		for _, raw := range []string{"~automod add word --action warn", "~automod add phrase heck", "~automod add regex (", "~automod add word heck --action explode", "~automod add word heck --exempt general"} {
+			if _, err := parseAutomodRule(strings.Split(raw, " ")); err == nil {
+				t.Logf("Expected '%s' to be rejected", raw)
+				t.Fail()
+			}
+		}
+	})
+
+	t.Run("Whole words and substrings match differently", func(t *testing.T) {
+		word, _ := parseAutomodRule(strings.Split("~automod add word ass", " "))
+		substring, _ := parseAutomodRule(strings.Split("~automod add substring ass", " "))
+		rules := []AutomodRule{word}
+		if findAutomodViolation(rules, "1", nil, "what a classic") != nil {
+			t.Logf("Whole word rule matched inside another word")
+			t.Fail()
+		}
+		if findAutomodViolation(rules, "1", nil, "you ASS!") == nil {
+			t.Logf("Whole word rule failed to match")
+			t.Fail()
+		}
+		if findAutomodViolation([]AutomodRule{substring}, "1", nil, "what a classic") == nil {
+			t.Logf("Substring rule failed to match")
+			t.Fail()
+		}
+	})
+
+	t.Run("Exempt channels and roles are skipped", func(t *testing.T) {
+		rule, _ := parseAutomodRule(strings.Split("~automod add substring spoiler --exempt <#111> <@&222>", " "))
+		rules := []AutomodRule{rule}
+		if findAutomodViolation(rules, "111", nil, "spoiler") != nil || findAutomodViolation(rules, "333", []string{"222"}, "spoiler") != nil {
+			t.Logf("Exemptions were not honored")
+			t.Fail()
+		}
+		if findAutomodViolation(rules, "333", []string{"444"}, "spoiler") == nil {
+			t.Logf("Rule failed to match outside its exemptions")
+			t.Fail()
+		}
+	})
+
+	t.Run("Staff and automod commands are exempt", func(t *testing.T) {
+		prefix = "~"
+		if !automodExempt(discordgo.PermissionManageMessages, "foo") || !automodExempt(discordgo.PermissionAdministrator, "foo") {
+			t.Logf("Staff should never be punished by automod")
+			t.Fail()
+		}
+		if !automodExempt(discordgo.PermissionManageServer, "~automod add word foo2") || automodExempt(discordgo.PermissionManageServer, "foo") {
+			t.Logf("Only commands from members who can run ~automod should be exempt")
+			t.Fail()
+		}
+		if automodExempt(discordgo.PermissionSendMessages, "~automod add word foo2") {
+			t.Logf("Commands from regular members should still be checked")
+			t.Fail()
+		}
+	})
+}
@@ -6,7 +6,6 @@ import (
 	"net/url"
 	"os"
 	"os/signal"
-	"regexp"
 	"strconv"
 	"strings"
 	"syscall"
@@ -101,7 +100,28 @@ func initCommandInfo() {
 		"about":       {attemptAbout},
 		"activity":    {activity},
 		"leaderboard": {leaderboard},
+		"privacy":     {handlePrivacy},
 		"greeter":     {greeter},
+		"warn":        {handleWarn},
+		"warnings":    {handleWarnings},
+		"clearwarn":   {handleClearWarn},
+		"escalation":  {handleEscalation},
+		"mute":        {handleMute},
+		"unmute":      {handleUnmute},
+		"archive":     {handleArchive},
+		"modlog":      {handleModLog},
+		"automod":     {handleAutomod},
+		"antispam":    {handleAntispam},
+		"antiraid":    {handleAntiraid},
+		"lockdown":    {handleLockdown},
+		"linkfilter":  {handleLinkFilter},
+		"messagelog":  {handleMessageLog},
+		"snipe":       {handleSnipe},
+		"editsnipe":   {handleEditSnipe},
+		"voice":       {handleVoice},
+		"memberlog":   {handleMemberLog},
+		"invites":     {handleInvites},
+		"stats":       {handleStats},
 	}
 }
 
@@ -121,6 +141,18 @@ func runBot(token string) {
 	leaderboardTable = os.Getenv("LEADERBOARD_TABLE")
 	joinLeaveTable = os.Getenv("JOIN_LEAVE_TABLE")
 	autokickTable = os.Getenv("AUTOKICK_TABLE")
+	warningsTable = os.Getenv("WARNINGS_TABLE")
+	escalationTable = os.Getenv("ESCALATION_TABLE")
+	guildSettingsTable = os.Getenv("GUILD_SETTINGS_TABLE")
+	mutesTable = os.Getenv("MUTES_TABLE")
+	automodTable = os.Getenv("AUTOMOD_TABLE")
+	messageCacheTable = os.Getenv("MESSAGE_CACHE_TABLE")
+	voiceSessionsTable = os.Getenv("VOICE_SESSIONS_TABLE")
+	inviteJoinsTable = os.Getenv("INVITE_JOINS_TABLE")
+	autokickWarningsTable = os.Getenv("AUTOKICK_WARNINGS_TABLE")
+	inactiveMembersTable = os.Getenv("INACTIVE_MEMBERS_TABLE")
+	activityEventsTable = os.Getenv("ACTIVITY_EVENTS_TABLE")
+	privacyOptoutsTable = os.Getenv("PRIVACY_OPTOUTS_TABLE")
 
 	// open connection to database
 	retry := 90
@@ -151,16 +183,46 @@ func runBot(token string) {
 	createLeaderboardTableSQL := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (entry int(11) NOT NULL AUTO_INCREMENT PRIMARY KEY,	guild_id char(20), member_id char(20), member_name char(40), points int(11), last_awarded char(70));", leaderboardTable)
 	createJoinLeaveTableSQL := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (entry int(11) NOT NULL AUTO_INCREMENT PRIMARY KEY, guild_id char(20), channel_id char(20), message_type char(5), image_link varchar(1000), message varchar(2000));", joinLeaveTable)
 	createAutokickTableSQL := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (guild_id char(20) PRIMARY KEY, days_until_kick int(11));", autokickTable)
+	createWarningsTableSQL := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (entry int(11) NOT NULL AUTO_INCREMENT PRIMARY KEY, guild_id char(20), member_id char(20), moderator_id char(20), reason varchar(1000), warned_at datetime);", warningsTable)
+	createEscalationTableSQL := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (entry int(11) NOT NULL AUTO_INCREMENT PRIMARY KEY, guild_id char(20), warning_count int(11), days int(11), action char(10), duration char(20));", escalationTable)
+	createGuildSettingsTableSQL := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (guild_id char(20), setting char(40), value varchar(1000), PRIMARY KEY (guild_id, setting));", guildSettingsTable)
+	createMutesTableSQL := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (guild_id char(20), member_id char(20), expires_at datetime, reason varchar(1000), PRIMARY KEY (guild_id, member_id));", mutesTable)
+	createAutomodTableSQL := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (entry int(11) NOT NULL AUTO_INCREMENT PRIMARY KEY, guild_id char(20), pattern varchar(500), match_type char(10), action char(10), exempt_channels varchar(1000), exempt_roles varchar(1000));", automodTable)
+	createMessageCacheTableSQL := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (message_id char(20) PRIMARY KEY, guild_id char(20), channel_id char(20), author_id char(20), author varchar(40), content varchar(4000), attachments varchar(2000), sent_at datetime);", messageCacheTable)
+	createVoiceSessionsTableSQL := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (entry int(11) NOT NULL AUTO_INCREMENT PRIMARY KEY, guild_id char(20), member_id char(20), channel_id char(20), joined_at datetime, left_at datetime NULL, duration int(11));", voiceSessionsTable)
+	createInviteJoinsTableSQL := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (guild_id char(20), member_id char(20), inviter_id char(20), code char(40), joined_at datetime, left_at datetime NULL, PRIMARY KEY (guild_id, member_id));", inviteJoinsTable)
+	createAutokickWarningsTableSQL := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (guild_id char(20), member_id char(20), last_active datetime, warned_at datetime, PRIMARY KEY (guild_id, member_id));", autokickWarningsTable)
+	createInactiveMembersTableSQL := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (guild_id char(20), member_id char(20), inactive_role char(20), roles varchar(2000), marked_at datetime, PRIMARY KEY (guild_id, member_id));", inactiveMembersTable)
+	createActivityEventsTableSQL := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (entry int(11) NOT NULL AUTO_INCREMENT PRIMARY KEY, guild_id char(20), member_id char(20), event_type char(20), channel_id char(20), created_at datetime, INDEX (guild_id, member_id, created_at));", activityEventsTable)
+	createPrivacyOptoutsTableSQL := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (member_id char(20) PRIMARY KEY, opted_out_at datetime);", privacyOptoutsTable)
 	queryWithoutResults(createActivityTableSQL, "Unable to create activity table!")
 	queryWithoutResults(createLeaderboardTableSQL, "Unable to create leaderboard table!")
 	queryWithoutResults(createJoinLeaveTableSQL, "Unable to create join / leave table!")
 	queryWithoutResults(createAutokickTableSQL, "Unable to create autokick table!")
+	queryWithoutResults(createWarningsTableSQL, "Unable to create warnings table!")
+	queryWithoutResults(createEscalationTableSQL, "Unable to create escalation table!")
+	queryWithoutResults(createGuildSettingsTableSQL, "Unable to create guild settings table!")
+	queryWithoutResults(createMutesTableSQL, "Unable to create mutes table!")
+	queryWithoutResults(createAutomodTableSQL, "Unable to create automod table!")
+	queryWithoutResults(createMessageCacheTableSQL, "Unable to create message cache table!")
+	queryWithoutResults(createVoiceSessionsTableSQL, "Unable to create voice sessions table!")
+	queryWithoutResults(createInviteJoinsTableSQL, "Unable to create invite joins table!")
+	queryWithoutResults(createAutokickWarningsTableSQL, "Unable to create autokick warnings table!")
+	queryWithoutResults(createInactiveMembersTableSQL, "Unable to create inactive members table!")
+	queryWithoutResults(createActivityEventsTableSQL, "Unable to create activity events table!")
+	queryWithoutResults(createPrivacyOptoutsTableSQL, "Unable to create privacy opt-outs table!")
+	alterActivityTableSQL := fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS left_at datetime NULL, ADD COLUMN IF NOT EXISTS rejoin_count int(11) NOT NULL DEFAULT 0, ADD COLUMN IF NOT EXISTS last_online datetime NULL;", activityTable)
+	queryWithoutResults(alterActivityTableSQL, "Unable to add new columns to activity table!")
 
 	/** Open Connection to Discord **/
 	if os.Getenv("PROD_MODE") == "true" {
 		logWarning("Production mode is active")
 		prodMode = true
 	}
+	if os.Getenv("TRACK_PRESENCE") == "true" {
+		logInfo("Presence tracking is active")
+		trackPresence = true
+	}
 	start = time.Now()
 
 	// initialize bot
@@ -172,14 +234,22 @@ func runBot(token string) {
 
 	// add listeners
 	dg.AddHandler(messageCreate)
+	dg.AddHandler(messageUpdate)
+	dg.AddHandler(messageDelete)
 	dg.AddHandler(messageReactionAdd)
 	dg.AddHandler(guildMemberAdd)
+	dg.AddHandler(guildMemberUpdate)
 	dg.AddHandler(guildMemberRemove)
 	dg.AddHandler(guildCreate)
 	dg.AddHandler(guildDelete)
 	dg.AddHandler(voiceStateUpdate)
+	dg.AddHandler(channelCreate)
 
 	dg.Identify.Intents = discordgo.IntentsAllWithoutPrivileged | discordgo.IntentsGuildMembers | discordgo.IntentsGuilds
+	if trackPresence {
+		dg.AddHandler(presenceUpdate)
+		dg.Identify.Intents |= discordgo.IntentsGuildPresences
+	}
 
 	// open connection to discord
 	err = dg.Open()
@@ -193,6 +263,12 @@ func runBot(token string) {
 	// start auto-kick listener
 	go runAutoKicker(dg)
 
+	// start lifting expired mutes
+	go runMuteScheduler(dg)
+	go runMessageRetention()
+	go runActivityRetention()
+	go runDepartedPurge()
+
 	/** Open Connection to Twitter **/
 	anaconda.SetConsumerKey(os.Getenv("TWITTER_API_KEY"))
 	anaconda.SetConsumerSecret(os.Getenv("TWITTER_API_SECRET"))
@@ -210,70 +286,6 @@ func runBot(token string) {
 	api.Close()
 }
 
-func runAutoKicker(dg *discordgo.Session) {
-	for {
-		logWarning("Performing auto-kick")
-		// 1. get days_until_kick for each guild
-		selectSQL := fmt.Sprintf("SELECT * FROM %s;", autokickTable)
-		query, err := connection_pool.Query(selectSQL)
-		if err != nil {
-			logError("SELECT query error: " + err.Error())
-		} else {
-			for query.Next() {
-				var autokickData AutoKickData
-				err = query.Scan(&autokickData.GuildID, &autokickData.DaysUntilKick)
-				if err != nil {
-					logError("Unable to parse database information! Aborting. " + err.Error())
-					return
-				} else {
-					// 2. get all users from member activity table that are not whitelisted and are in the given guild
-					selectSQL = fmt.Sprintf("SELECT * FROM %s WHERE (guild_id = '%s' AND whitelist = false);", activityTable, autokickData.GuildID)
-					memberQuery, err := connection_pool.Query(selectSQL)
-					if err != nil {
-						logError("SELECT query error: " + err.Error())
-					} else {
-						for memberQuery.Next() {
-							var memberActivity MemberActivity
-							err = memberQuery.Scan(&memberActivity.ID, &memberActivity.GuildID, &memberActivity.MemberID, &memberActivity.MemberName, &memberActivity.LastActive, &memberActivity.Description, &memberActivity.Whitelisted)
-							if err != nil {
-								logError("Unable to parse database information! Aborting. " + err.Error())
-							} else {
-								dateFormat := "2006-01-02 15:04:05.999999999 -0700 MST"
-								// calculate difference between time.Now() and the provided timestamp
-								lastActive, err := time.Parse(dateFormat, strings.Split(memberActivity.LastActive, " m=")[0])
-								if err != nil {
-									logError("Unable to parse database timestamps! Aborting. " + err.Error())
-								} else {
-									lastActive = lastActive.AddDate(0, 0, autokickData.DaysUntilKick)
-									if lastActive.Before(time.Now()) {
-										// kick user
-										err = dg.GuildMemberDeleteWithReason(autokickData.GuildID, memberActivity.MemberID, fmt.Sprintf("Bot detected %d or more days of inactivity.", autokickData.DaysUntilKick))
-										if err != nil {
-											logError("Unable to kick user! " + err.Error())
-										}
-										guild, err := dg.Guild(autokickData.GuildID)
-										if err != nil {
-											logError("Unable to load guild! " + err.Error())
-										}
-										guildName := "error: could not retrieve"
-										if guild != nil {
-											guildName = guild.Name
-										}
-										dmUser(dg, memberActivity.MemberID, fmt.Sprintf("You have been automatically kicked from **%s** due to %d or more days of inactivity.", guildName, autokickData.DaysUntilKick))
-									}
-								}
-							}
-						}
-					}
-				}
-			}
-			query.Close()
-		}
-
-		time.Sleep(6 * time.Hour)
-	}
-}
-
 /**
 Opens a stream looking for new tweets from @DeadbyBHVR, who posts the weekly
 shrine on Twitter.
@@ -330,58 +342,124 @@ a channel that the bot has access to.
 func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
 	logInfo("Message Create Event")
 	go checkForMessageLink(s, m)
-	go logActivity(m.GuildID, m.Author, time.Now().String(), "Wrote a message in <#"+m.ChannelID+">", false)
+	go checkAutomod(s, m)
+	go checkSpam(s, m)
+	go checkLinks(s, m)
+	go cacheMessage(m)
+	go logActivity(m.GuildID, m.Author, eventMessage, m.ChannelID, false)
+	go restoreInactiveMember(s, m.GuildID, m.Author.ID)
 	awardPoints(m.GuildID, m.Author, time.Now().String(), m.Content)
 	respondToCommands(s, m)
 }
 
+/**
+This function will be called every time a message is edited in a channel the bot can see.
+*/
+func messageUpdate(s *discordgo.Session, m *discordgo.MessageUpdate) {
+	go logMessageEdit(s, m)
+}
+
+/**
+This function will be called every time a message is deleted in a channel the bot can see.
+*/
+func messageDelete(s *discordgo.Session, m *discordgo.MessageDelete) {
+	go logMessageDelete(s, m)
+}
+
 /**
 Used to handle scrolling through images given from ~image,
 but can and may be used to handle other reactions in the future
 */
 func messageReactionAdd(s *discordgo.Session, m *discordgo.MessageReactionAdd) {
 	go navigateImages(s, m)
+	go navigateHistory(s, m)
+	go confirmAutokickRun(s, m)
+	go confirmPrivacyDelete(s, m)
 	user, err := s.User(m.UserID)
 	if err != nil {
 		logError("Could not get the user from the session state! " + err.Error())
 		return
 	}
-	go logActivity(m.GuildID, user, time.Now().String(), "Reacted with :"+m.Emoji.Name+": to a message in <#"+m.ChannelID+">", false)
+	go logActivity(m.GuildID, user, eventReaction, m.ChannelID, false)
+	go restoreInactiveMember(s, m.GuildID, m.UserID)
 }
 
 func guildMemberAdd(s *discordgo.Session, m *discordgo.GuildMemberAdd) {
-	go logActivity(m.GuildID, m.User, time.Now().String(), "Joined the server", true)
-	go joinLeaveMessage(s, m.GuildID, m.User, "join")
+	go logActivity(m.GuildID, m.User, eventJoin, "", true)
+	// the invite is attributed first so the greeter can mention the inviter
+	go func() {
+		trackInviteJoin(s, m)
+		joinLeaveMessage(s, m.GuildID, m.User, "join")
+	}()
+	go reapplyMute(s, m.GuildID, m.User.ID)
+	go checkRaid(s, m)
+	go logMemberJoin(s, m)
+}
+
+func guildMemberUpdate(s *discordgo.Session, m *discordgo.GuildMemberUpdate) {
+	snapshotMember(m.GuildID, m.Member)
 }
 
 func guildMemberRemove(s *discordgo.Session, m *discordgo.GuildMemberRemove) {
 	logInfo("Guild Member Remove Event")
 	go removeUser(m.GuildID, m.User.ID)
 	go joinLeaveMessage(s, m.GuildID, m.User, "leave")
+	go logMemberLeave(s, m)
+	go markInviteLeave(m.GuildID, m.User.ID)
 }
 
 func guildCreate(s *discordgo.Session, m *discordgo.GuildCreate) {
 	logNewGuild(s, m.ID)
+	go reconcileVoiceSessions(m.Guild)
+	snapshotGuild(m.Guild)
+	go snapshotInvites(s, m.ID)
+	if trackPresence {
+		go recordGuildPresences(m.Guild)
+	}
+}
+
+func presenceUpdate(s *discordgo.Session, p *discordgo.PresenceUpdate) {
+	go recordLastOnline(p.GuildID, p.User)
 }
 
 func guildDelete(s *discordgo.Session, m *discordgo.GuildDelete) {
+	// an outage isn't the bot being removed
+	if m.Unavailable {
+		return
+	}
 	removeGuild(m.ID)
 }
 
+func channelCreate(s *discordgo.Session, c *discordgo.ChannelCreate) {
+	if c.GuildID == "" {
+		return
+	}
+	// keep muted members muted in new channels
+	roleID := getGuildSetting(c.GuildID, "mute_role")
+	if roleID != "" {
+		applyMuteOverwrite(s, c.ID, roleID)
+	}
+}
+
 func voiceStateUpdate(s *discordgo.Session, v *discordgo.VoiceStateUpdate) {
 	user, err := s.User(v.UserID)
 	if err != nil {
 		logError("Could not get the user from the session state! " + err.Error())
 		return
 	}
+	go trackVoiceSession(s, v)
+	if !voiceCountsAsActivity(v.GuildID) {
+		return
+	}
+	go restoreInactiveMember(s, v.GuildID, v.UserID)
 	if v.ChannelID == "" {
 		if v.BeforeUpdate != nil {
-			logActivity(v.GuildID, user, time.Now().String(), "Left <#"+v.BeforeUpdate.ChannelID+">", false)
+			logActivity(v.GuildID, user, eventVoiceLeave, v.BeforeUpdate.ChannelID, false)
 		} else {
-			logActivity(v.GuildID, user, time.Now().String(), "Left a voice channel", false)
+			logActivity(v.GuildID, user, eventVoiceLeave, "", false)
 		}
 	} else {
-		logActivity(v.GuildID, user, time.Now().String(), "Joined <#"+v.ChannelID+">", false)
+		logActivity(v.GuildID, user, eventVoiceJoin, v.ChannelID, false)
 	}
 }
 
@@ -394,16 +472,20 @@ func checkForMessageLink(s *discordgo.Session, m *discordgo.MessageCreate) {
 	if m.Author.ID == s.State.User.ID || m.GuildID == "" {
 		return
 	}
-	regex := regexp.MustCompile(`https:\/\/discord.com\/channels\/[0-9]{18}\/[0-9]{18}\/[0-9]{18}`)
-	match := regex.FindStringSubmatch(m.Content)
-	if match != nil {
+	var guildID, channelID, messageID string
+	found := false
+	for _, link := range extractURLs(m.Content) {
+		if guildID, channelID, messageID, found = parseMessageLink(link.String()); found {
+			break
+		}
+	}
+	if found {
 		// verify the message came from within the guild
-		linkData := strings.Split(match[0], "/")
-		if linkData[4] == m.GuildID {
+		if guildID == m.GuildID {
 			var embed discordgo.MessageEmbed
 			embed.Type = "rich"
 
-			linkedMessage, err := s.ChannelMessage(linkData[5], linkData[6])
+			linkedMessage, err := s.ChannelMessage(channelID, messageID)
 			if err != nil {
 				logError("Unable to pull message from session: " + err.Error())
 				return
@@ -435,7 +517,7 @@ func checkForMessageLink(s *discordgo.Session, m *discordgo.MessageCreate) {
 			embed.Description = linkedMessage.Content
 			embed.Timestamp = string(linkedMessage.Timestamp)
 
-			linkedMessageChannel, err := s.Channel(linkData[5])
+			linkedMessageChannel, err := s.Channel(channelID)
 			if err != nil {
 				logError("Unable to pull channel from session: " + err.Error())
 				return
@@ -3,8 +3,12 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"net/url"
+	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/bwmarrin/discordgo"
@@ -106,3 +110,107 @@ func stripUserID(raw string) string {
 	raw = strings.TrimPrefix(raw, "!")
 	return raw
 }
+
+/**
+Escapes backslashes and single quotes so user-provided text can be
+placed inside a quoted SQL string.
+*/
+func escapeSQL(raw string) string {
+	raw = strings.ReplaceAll(raw, "\\", "\\\\")
+	return strings.ReplaceAll(raw, "'", "\\'")
+}
+
+// format used for DATETIME columns; always stored in UTC
+const sqlDateFormat = "2006-01-02 15:04:05"
+
+/**
+Converts a time into the string stored in DATETIME columns.
+*/
+func sqlTimestamp(t time.Time) string {
+	return t.UTC().Format(sqlDateFormat)
+}
+
+/**
+Parses a DATETIME column value written by sqlTimestamp.
+*/
+func parseSQLTimestamp(raw string) (time.Time, error) {
+	return time.ParseInLocation(sqlDateFormat, raw, time.UTC)
+}
+
+/**
+Parses a duration such as "30m", "12h", "2d" or "1w2d". Unlike time.ParseDuration,
+it understands days and weeks, which is how people usually write them.
+*/
+func parseDuration(raw string) (time.Duration, error) {
+	regex := regexp.MustCompile(`^([0-9]+[smhdw])+$`)
+	if !regex.MatchString(raw) {
+		return 0, fmt.Errorf("invalid duration '%s'", raw)
+	}
+	units := map[string]time.Duration{
+		"s": time.Second,
+		"m": time.Minute,
+		"h": time.Hour,
+		"d": 24 * time.Hour,
+		"w": 7 * 24 * time.Hour,
+	}
+	var duration time.Duration
+	for _, part := range regexp.MustCompile(`([0-9]+)([smhdw])`).FindAllStringSubmatch(raw, -1) {
+		amount, err := strconv.Atoi(part[1])
+		if err != nil {
+			return 0, err
+		}
+		duration += time.Duration(amount) * units[part[2]]
+	}
+	return duration, nil
+}
+
+// matches a link to a message, capturing the guild, channel and message IDs
+var messageLinkRegex = regexp.MustCompile(`https:\/\/(?:(?:ptb|canary)\.)?discord(?:app)?\.com\/channels\/([0-9]+)\/([0-9]+)\/([0-9]+)`)
+
+/**
+Splits a message link into its guild, channel and message IDs.
+*/
+func parseMessageLink(link string) (string, string, string, bool) {
+	match := messageLinkRegex.FindStringSubmatch(link)
+	if match == nil {
+		return "", "", "", false
+	}
+	return match[1], match[2], match[3], true
+}
+
+// common ways of hiding a link from filters, e.g. discord[.]gg or hxxps://
+var linkObfuscations = strings.NewReplacer("[.]", ".", "(.)", ".", "{.}", ".", "[dot]", ".", "(dot)", ".", " . ", ".", "hxxp", "http")
+
+// matches anything that looks like a link, with or without a scheme
+var urlRegex = regexp.MustCompile(`(?i)(?:https?:\/\/)?(?:[\p{L}\p{N}\-]+\.)+\p{L}{2,}(?::[0-9]+)?(?:\/[^\s<>]*)?`)
+
+/**
+Finds every link in the message, undoing common obfuscations first. Links written
+without a scheme are treated as https.
+*/
+func extractURLs(content string) []*url.URL {
+	var urls []*url.URL
+	for _, match := range urlRegex.FindAllString(linkObfuscations.Replace(content), -1) {
+		if !strings.Contains(match, "://") {
+			match = "https://" + match
+		}
+		parsed, err := url.Parse(match)
+		if err != nil || parsed.Hostname() == "" {
+			continue
+		}
+		urls = append(urls, parsed)
+	}
+	return urls
+}
+
+/**
+Returns true if the first snowflake ID was created before the second.
+*/
+func snowflakeBefore(a string, b string) bool {
+	first, errA := strconv.ParseUint(a, 10, 64)
+	second, errB := strconv.ParseUint(b, 10, 64)
+	if errA != nil || errB != nil {
+		return a < b
+	}
+	return first < second
+}
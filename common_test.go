@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+/**
+Test anything that uses calculation outside of the discordgo commands.
+Those commands don't need to be tested since they are verified to work
+at github.com/bwmarrin/discordgo
+**/
+func TestCommon(t *testing.T) {
+	t.Run("Durations understand days and weeks", func(t *testing.T) {
+		expected := map[string]time.Duration{
+			"30m":  30 * time.Minute,
+			"12h":  12 * time.Hour,
+			"2d":   48 * time.Hour,
+			"1w2d": 9 * 24 * time.Hour,
+		}
+		for raw, want := range expected {
+			got, err := parseDuration(raw)
+			if err != nil || got != want {
+				t.Logf("Parsed '%s' as %s (%v), expected %s", raw, got, err, want)
+				t.Fail()
+			}
+		}
+	})
+
+	t.Run("Invalid durations are rejected", func(t *testing.T) {
+		for _, raw := range []string{"", "30", "m", "1y", "-5m"} {
+			if _, err := parseDuration(raw); err == nil {
+				t.Logf("Expected '%s' to be rejected", raw)
+				t.Fail()
+			}
+		}
+	})
+
+	t.Run("SQL strings are escaped", func(t *testing.T) {
+		if escaped := escapeSQL(`it's a \ test`); escaped != `it\'s a \\ test` {
+			t.Logf("Failed to escape string: %s", escaped)
+			t.Fail()
+		}
+	})
+}
+
+/**
+Builds a snowflake ID created at the given time, for tests that need message or audit log IDs.
+*/
+func snowflakeAt(t time.Time) string {
+	return strconv.FormatInt((t.UnixNano()/int64(time.Millisecond)-1420070400000)<<22, 10)
+}
@@ -23,6 +23,18 @@ var activityTable string
 var leaderboardTable string
 var joinLeaveTable string
 var autokickTable string
+var warningsTable string
+var escalationTable string
+var guildSettingsTable string
+var mutesTable string
+var automodTable string
+var messageCacheTable string
+var voiceSessionsTable string
+var inviteJoinsTable string
+var autokickWarningsTable string
+var inactiveMembersTable string
+var activityEventsTable string
+var privacyOptoutsTable string
 
 type AutoKickData struct {
 	GuildID       string `json:"guild_id"`
@@ -37,6 +49,9 @@ type MemberActivity struct {
 	LastActive  string `json:"last_active"`
 	Description string `json:"description"`
 	Whitelisted int    `json:"whitelist"`
+	LeftAt      string `json:"left_at"`
+	RejoinCount int    `json:"rejoin_count"`
+	LastOnline  string `json:"last_online"`
 }
 
 type LeaderboardEntry struct {
@@ -68,22 +83,43 @@ type InactiveSet struct {
 EVENT HANDLERS
 ****/
 
-// logs when a user sends a message, reacts to a message, or joins the server.
-func logActivity(guildID string, user *discordgo.User, time string, description string, newUser bool) {
+// logs when a user sends a message, reacts to a message, or joins the server. The event is added to
+// the member's history, and their last activity and name are set from it. Members who opted out only
+// have the time of their last activity updated, so auto-kick still treats them like everyone else.
+func logActivity(guildID string, user *discordgo.User, eventType string, channelID string, newUser bool) {
 	if user.Bot {
 		return
 	}
 
-	description = strings.ReplaceAll(description, "'", "''")
+	event := ActivityEvent{Type: eventType, ChannelID: channelID, CreatedAt: time.Now()}
+	lastActive := event.CreatedAt.String()
+	description := optedOutDescription
+	nameSQL := ""
+	if !isOptedOut(user.ID) {
+		recordActivityEvent(guildID, user.ID, event)
+		description = strings.ReplaceAll(describeActivityEvent(event), "'", "''")
+		nameSQL = fmt.Sprintf(", member_name = '%s'", strings.ReplaceAll(user.Username, "'", "\\'")+"#"+user.Discriminator)
+	}
 
 	if len(description) > 80 {
 		description = description[0:80]
 	}
 
+	if newUser {
+		// members who left keep their row, so a rejoin picks up where they left off
+		exists, err := activityRowExists(guildID, user.ID)
+		if err != nil {
+			logError("Unable to check for the user's activity! " + err.Error())
+		} else if exists {
+			restoreDepartedMember(guildID, user.ID, eventType == eventJoin)
+			newUser = false
+		}
+	}
+
 	if newUser {
 		// INSERT INTO table (guild_id, member_id, last_active, description) VALUES (guildID, userID, time, description)
 		insertSQL := fmt.Sprintf("INSERT INTO %s (guild_id, member_id, member_name, last_active, description) VALUES ('%s', '%s', '%s', '%s', '%s');",
-			activityTable, guildID, user.ID, strings.ReplaceAll(user.Username, "'", "\\'")+"#"+user.Discriminator, time, description)
+			activityTable, guildID, user.ID, strings.ReplaceAll(user.Username, "'", "\\'")+"#"+user.Discriminator, lastActive, description)
 		if queryWithoutResults(insertSQL, "Unable to insert new user!") {
 			logSuccess("User added to activity log")
 		} else {
@@ -91,8 +127,8 @@ func logActivity(guildID string, user *discordgo.User, time string, description
 		}
 	} else {
 		// UPDATE table SET (last_active = time, description = description) WHERE (guild_id = guildID AND member_id = userID)
-		updateSQL := fmt.Sprintf("UPDATE %s SET last_active = '%s', description = '%s', member_name = '%s' WHERE (guild_id = '%s' AND member_id = '%s');",
-			activityTable, time, description, strings.ReplaceAll(user.Username, "'", "\\'")+"#"+user.Discriminator, guildID, user.ID)
+		updateSQL := fmt.Sprintf("UPDATE %s SET last_active = '%s', description = '%s'%s WHERE (guild_id = '%s' AND member_id = '%s');",
+			activityTable, lastActive, description, nameSQL, guildID, user.ID)
 		if queryWithoutResults(updateSQL, "Unable to update user's activity!") {
 			logSuccess("User's activity updated")
 		} else {
@@ -102,11 +138,11 @@ func logActivity(guildID string, user *discordgo.User, time string, description
 
 }
 
-// removes the user's row when they leave the server.
+// marks the user as departed when they leave the server. Their row is kept until it's purged.
 func removeUser(guildID string, userID string) {
-	deleteSQL := fmt.Sprintf("DELETE FROM %s WHERE (guild_id = '%s' AND member_id = '%s');", activityTable, guildID, userID)
-	if queryWithoutResults(deleteSQL, "Unable to delete user's activity!") {
-		logSuccess("User removed from activity log")
+	updateSQL := fmt.Sprintf("UPDATE %s SET left_at = '%s' WHERE (guild_id = '%s' AND member_id = '%s' AND left_at IS NULL);", activityTable, sqlTimestamp(time.Now()), guildID, userID)
+	if queryWithoutResults(updateSQL, "Unable to mark user as departed!") {
+		logSuccess("User marked as departed in activity log")
 	} else {
 		logWarning("Couldn't remove user from activity log! Is the connection still available?")
 	}
@@ -131,6 +167,10 @@ func joinLeaveMessage(s *discordgo.Session, guildID string, user *discordgo.User
 	sDisc := user.Discriminator
 	sPing := fmt.Sprintf("<@%s>", user.ID)
 	sMemc := guild.MemberCount
+	sInviter := "someone"
+	if inviterID := getInviter(guildID, user.ID); inviterID != "" {
+		sInviter = fmt.Sprintf("<@%s>", inviterID)
+	}
 
 	for query.Next() {
 		// write and send embed
@@ -146,6 +186,7 @@ func joinLeaveMessage(s *discordgo.Session, guildID string, user *discordgo.User
 		greeterMessage.Message = strings.ReplaceAll(greeterMessage.Message, "<<disc>>", sDisc)
 		greeterMessage.Message = strings.ReplaceAll(greeterMessage.Message, "<<ping>>", sPing)
 		greeterMessage.Message = strings.ReplaceAll(greeterMessage.Message, "<<memc>>", strconv.Itoa(sMemc))
+		greeterMessage.Message = strings.ReplaceAll(greeterMessage.Message, "<<inviter>>", sInviter)
 
 		var embed discordgo.MessageEmbed
 		embed.Type = "rich"
@@ -182,7 +223,7 @@ func logNewGuild(s *discordgo.Session, guildID string) int {
 		after = memberList[len(memberList)-1].User.ID
 	}
 
-	results, err := connection_pool.Query("SELECT * FROM " + activityTable + " WHERE (guild_id = '" + guildID + "')")
+	results, err := connection_pool.Query("SELECT " + activityColumns + " FROM " + activityTable + " WHERE (guild_id = '" + guildID + "')")
 	if err != nil {
 		logError("Unable to read database for existing users in the guild! " + err.Error())
 		return 0
@@ -192,8 +233,7 @@ func logNewGuild(s *discordgo.Session, guildID string) int {
 	// loop through members in the database and store them in an array
 	var memberActivities []MemberActivity
 	for results.Next() {
-		var memberActivity MemberActivity
-		err = results.Scan(&memberActivity.ID, &memberActivity.GuildID, &memberActivity.MemberID, &memberActivity.MemberName, &memberActivity.LastActive, &memberActivity.Description, &memberActivity.Whitelisted)
+		memberActivity, err := scanMemberActivity(results)
 		if err != nil {
 			logError("Unable to parse database information! Aborting. " + err.Error())
 			return 0
@@ -208,11 +248,15 @@ func logNewGuild(s *discordgo.Session, guildID string) int {
 			for _, memberActivity := range memberActivities {
 				if memberActivity.MemberID == member.User.ID {
 					memberExistsInDatabase = true
+					// they came back while the bot was away
+					if memberActivity.LeftAt != "" {
+						go restoreDepartedMember(guildID, member.User.ID, false)
+					}
 				}
 			}
 			if !memberExistsInDatabase {
 				logInfo("Added " + member.User.ID + "to the activity database for guild " + guildID)
-				go logActivity(guildID, member.User, time.Now().String(), "Detected in a scan", true)
+				go logActivity(guildID, member.User, eventScan, "", true)
 				membersAddedToDatabase++
 			}
 		}
@@ -221,11 +265,11 @@ func logNewGuild(s *discordgo.Session, guildID string) int {
 
 }
 
-// removes the provided guild's members from the database.
+// marks the provided guild's members as departed. They're restored if the bot is added back before they're purged.
 func removeGuild(guildID string) {
-	deleteSQL := fmt.Sprintf("DELETE FROM %s WHERE (guild_id = '%s');", activityTable, guildID)
-	if queryWithoutResults(deleteSQL, "Unable to delete guild from database!") {
-		logSuccess("Guild removed from activity log")
+	updateSQL := fmt.Sprintf("UPDATE %s SET left_at = '%s' WHERE (guild_id = '%s' AND left_at IS NULL);", activityTable, sqlTimestamp(time.Now()), guildID)
+	if queryWithoutResults(updateSQL, "Unable to mark guild as departed!") {
+		logSuccess("Guild marked as departed in activity log")
 	} else {
 		logWarning("Couldn't remove guild from activity log! Is the connection still available?")
 	}
@@ -233,7 +277,7 @@ func removeGuild(guildID string) {
 
 // awards a user points for the guild's leaderboard based on the word count formula.
 func awardPoints(guildID string, user *discordgo.User, currentTime string, message string) {
-	if user.Bot {
+	if user.Bot || isOptedOut(user.ID) {
 		return
 	}
 
@@ -291,6 +335,32 @@ func awardPoints(guildID string, user *discordgo.User, currentTime string, messa
 	}
 }
 
+// returns the guild's value for a setting, or "" if it has not been set.
+func getGuildSetting(guildID string, setting string) string {
+	selectSQL := fmt.Sprintf("SELECT value FROM %s WHERE (guild_id = '%s' AND setting = '%s');", guildSettingsTable, guildID, setting)
+	var value string
+	err := connection_pool.QueryRow(selectSQL).Scan(&value)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logError("Unable to read guild setting " + setting + "! " + err.Error())
+		}
+		return ""
+	}
+	return value
+}
+
+// adds or replaces the guild's value for a setting.
+func setGuildSetting(guildID string, setting string, value string) bool {
+	replaceSQL := fmt.Sprintf("REPLACE INTO %s (guild_id, setting, value) VALUES ('%s', '%s', '%s');", guildSettingsTable, guildID, setting, escapeSQL(value))
+	return queryWithoutResults(replaceSQL, "Unable to save guild setting "+setting+"!")
+}
+
+// removes the guild's value for a setting, returning it to its default.
+func deleteGuildSetting(guildID string, setting string) bool {
+	deleteSQL := fmt.Sprintf("DELETE FROM %s WHERE (guild_id = '%s' AND setting = '%s');", guildSettingsTable, guildID, setting)
+	return queryWithoutResults(deleteSQL, "Unable to delete guild setting "+setting+"!")
+}
+
 // helper function for queries we don't need the results for.
 func queryWithoutResults(sql string, errMessage string) bool {
 	query, err := connection_pool.Query(sql)
@@ -326,7 +396,7 @@ func greeter(s *discordgo.Session, m *discordgo.MessageCreate, command []string)
 		var embed discordgo.MessageEmbed
 		embed.Type = "rich"
 		embed.Title = "Greeter Commands"
-		embed.Description = "The greeter has a few codes you can use to substitute server / user data in your message!\n```Codes:\n\t<<user>> -> username\n\t<<disc>> -> discriminator\n\t<<ping>> -> @user\n\t<<memc>> -> member count```\nExample:\n`Welcome, <<ping>>! <<user>>#<<disc>> is member <<memc>> on the server!` becomes `Welcome, @sage! sage#5429 is member 53 on the server!`"
+		embed.Description = "The greeter has a few codes you can use to substitute server / user data in your message!\n```Codes:\n\t<<user>> -> username\n\t<<disc>> -> discriminator\n\t<<ping>> -> @user\n\t<<memc>> -> member count\n\t<<inviter>> -> @user who made the invite they used```\nExample:\n`Welcome, <<ping>>! <<user>>#<<disc>> is member <<memc>> on the server!` becomes `Welcome, @sage! sage#5429 is member 53 on the server!`"
 
 		var contents []*discordgo.MessageEmbedField
 		contents = append(contents, createField("~greeter help", "Explains how to use the codes and different commands.", false))
@@ -565,7 +635,7 @@ func leaderboard(s *discordgo.Session, m *discordgo.MessageCreate, command []str
 
 func activity(s *discordgo.Session, m *discordgo.MessageCreate, command []string) {
 	if len(command) == 1 {
-		_, err := s.ChannelMessageSend(m.ChannelID, "Usage: ```~activity rescan/user/whitelist/autokick/list```")
+		_, err := s.ChannelMessageSend(m.ChannelID, "Usage: ```~activity rescan/user/history/whitelist/autokick/list/departed/purge/retention```")
 		if err != nil {
 			logError("Failed to send usage message! " + err.Error())
 		}
@@ -601,7 +671,7 @@ func activity(s *discordgo.Session, m *discordgo.MessageCreate, command []string
 			userID := stripUserID(command[2])
 
 			// parse userID, get it from the db, present info
-			selectSQL := fmt.Sprintf("SELECT * FROM %s WHERE (guild_id = '%s' AND member_id = '%s');", activityTable, m.GuildID, userID)
+			selectSQL := fmt.Sprintf("SELECT %s FROM %s WHERE (guild_id = '%s' AND member_id = '%s');", activityColumns, activityTable, m.GuildID, userID)
 			query, err := connection_pool.Query(selectSQL)
 			defer query.Close()
 			if err == sql.ErrNoRows {
@@ -614,8 +684,7 @@ func activity(s *discordgo.Session, m *discordgo.MessageCreate, command []string
 				return
 			} else {
 				for query.Next() {
-					var memberActivity MemberActivity
-					err = query.Scan(&memberActivity.ID, &memberActivity.GuildID, &memberActivity.MemberID, &memberActivity.MemberName, &memberActivity.LastActive, &memberActivity.Description, &memberActivity.Whitelisted)
+					memberActivity, err := scanMemberActivity(query)
 					if err != nil {
 						logError("Unable to parse database information! Aborting. " + err.Error())
 						return
@@ -630,10 +699,33 @@ func activity(s *discordgo.Session, m *discordgo.MessageCreate, command []string
 					embed.Type = "rich"
 					embed.Title = memberActivity.MemberName
 					embed.Description = "- " + lastActive.Format("01/02/2006 15:04:05") + "\n- " + memberActivity.Description
+					if lastOnline, err := parseSQLTimestamp(memberActivity.LastOnline); err == nil {
+						embed.Description += "\n- Last seen online " + lastOnline.Format("01/02/2006 15:04:05")
+					} else if trackPresence {
+						embed.Description += "\n- Not seen online yet"
+					}
 
 					if memberActivity.Whitelisted == 1 {
 						embed.Description += "\n- Protected from auto-kick"
 					}
+					if events, err := getActivityEvents(m.GuildID, userID, time.Now().AddDate(0, 0, -defaultHistoryDays)); err == nil {
+						embed.Description += fmt.Sprintf("\n- %d events in the last %d days (see `~activity history`)", len(events), defaultHistoryDays)
+					}
+					if memberActivity.RejoinCount > 0 {
+						embed.Description += fmt.Sprintf("\n- Rejoined %d times", memberActivity.RejoinCount)
+					}
+					if memberActivity.LeftAt != "" {
+						if leftAt, err := parseSQLTimestamp(memberActivity.LeftAt); err == nil {
+							embed.Description += "\n- Left the server on " + leftAt.Format("01/02/2006 15:04:05")
+						}
+						_, err = s.ChannelMessageSendEmbed(m.ChannelID, &embed)
+						if err != nil {
+							logError("Failed to send user activity message! " + err.Error())
+							return
+						}
+						logSuccess("Sent departed user activity message")
+						return
+					}
 
 					member, err := s.GuildMember(m.GuildID, userID)
 					if err != nil {
@@ -659,23 +751,29 @@ func activity(s *discordgo.Session, m *discordgo.MessageCreate, command []string
 			}
 		}
 	case "list":
-		if len(command) != 3 {
-			_, err := s.ChannelMessageSend(m.ChannelID, "Usage: ```~activity list <number of days of inactivity>```")
+		options, err := parseActivityListOptions(command[2:])
+		if err != nil {
+			_, err = s.ChannelMessageSend(m.ChannelID, "Couldn't read that: "+err.Error()+"\n"+activityListUsage)
 			if err != nil {
 				logError("Failed to send usage message! " + err.Error())
 			}
 			return
 		}
-		inactiveUsers := getInactiveUsers(s, m, command)
-		daysOfInactivity, err := strconv.Atoi(command[2])
+		members, err := getGuildActivity(m.GuildID)
 		if err != nil {
-			logError("Failed to convert string passed in to a number! " + err.Error())
-			_, err = s.ChannelMessageSend(m.ChannelID, "Please input a valid number.")
+			logError("Unable to read database for existing users in the guild! " + err.Error())
+			_, err = s.ChannelMessageSend(m.ChannelID, "An error occurred. Please try again in a moment.")
 			if err != nil {
-				logError("Failed to send 'invalid number' message! " + err.Error())
+				logError("Failed to send error message! " + err.Error())
 			}
 			return
 		}
+		inactiveUsers := filterActivityList(members, options, time.Now(), memberHasRole(s, m.GuildID, options.RoleID))
+		daysOfInactivity := options.Days
+		if options.Export != "" {
+			sendActivityExport(s, m, inactiveUsers, options)
+			return
+		}
 
 		if len(inactiveUsers) == 0 {
 			_, err := s.ChannelMessageSend(m.ChannelID, "No user has been inactive for "+strconv.Itoa(daysOfInactivity)+"+ days.")
@@ -702,7 +800,7 @@ func activity(s *discordgo.Session, m *discordgo.MessageCreate, command []string
 		}
 
 		var contents []*discordgo.MessageEmbedField
-		for i := 0; i < 6 && i < len(inactiveUsers); i++ {
+		for i := 0; i < 8 && i < len(inactiveUsers); i++ {
 			// calculate difference between time.Now() and the provided timestamp
 			dateFormat := "2006-01-02 15:04:05.999999999 -0700 MST"
 			lastActive, err := time.Parse(dateFormat, strings.Split(inactiveUsers[i].LastActive, " m=")[0])
@@ -747,119 +845,16 @@ func activity(s *discordgo.Session, m *discordgo.MessageCreate, command []string
 			return
 		}
 		logSuccess("Returned interactable activity list")
+	case "history":
+		handleActivityHistory(s, m, command)
+	case "departed":
+		handleActivityDeparted(s, m, command)
+	case "purge":
+		handleActivityPurge(s, m, command)
+	case "retention":
+		handleActivityRetention(s, m, command)
 	case "autokick":
-		if !userHasValidPermissions(s, m, discordgo.PermissionManageServer) {
-			logWarning("User without appropriate permissions tried to mess with autokick")
-			_, err := s.ChannelMessageSend(m.ChannelID, "Sorry, you don't have the `Manage Server` permission.")
-			if err != nil {
-				logError("Failed to send permissions message! " + err.Error())
-			}
-			return
-		}
-		// set autokick day check
-		if len(command) != 3 && len(command) != 2 {
-			_, err := s.ChannelMessageSend(m.ChannelID, "Usage: ```~activity autokick <number>```")
-			if err != nil {
-				logError("Failed to send usage message! " + err.Error())
-			}
-			return
-		}
-
-		if len(command) == 2 {
-			selectSQL := fmt.Sprintf("SELECT * FROM %s WHERE (guild_id = '%s');", autokickTable, m.GuildID)
-			results, err := connection_pool.Query(selectSQL)
-			if err != nil {
-				logError("Unable to read database for existing users in the guild! " + err.Error())
-				return
-			}
-			defer results.Close()
-
-			autokickEnabled := false
-			for results.Next() {
-				autokickEnabled = true
-				// send message
-				var autokickData AutoKickData
-				err := results.Scan(&autokickData.GuildID, &autokickData.DaysUntilKick)
-				if err != nil {
-					logError("Unable to parse database information! Aborting. " + err.Error())
-					return
-				}
-				_, err = s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Current set to autokick users after %d days of inactivity.", autokickData.DaysUntilKick))
-				if err != nil {
-					logError("Failed to send 'invalid number' message! " + err.Error())
-					return
-				}
-			}
-
-			if !autokickEnabled {
-				_, err = s.ChannelMessageSend(m.ChannelID, "Autokick is currently disabled for the server.")
-				if err != nil {
-					logError("Failed to send 'autokick disabled' message! " + err.Error())
-					return
-				}
-			}
-			logSuccess("Returned autokick info")
-			return
-		}
-
-		daysOfInactivity, err := strconv.Atoi(command[2])
-		if err != nil {
-			logError("Failed to convert string passed in to a number! " + err.Error())
-			_, err = s.ChannelMessageSend(m.ChannelID, "Please input a valid number.")
-			if err != nil {
-				logError("Failed to send 'invalid number' message! " + err.Error())
-			}
-			return
-		}
-		logInfo(strconv.Itoa(daysOfInactivity))
-
-		if daysOfInactivity < 1 {
-			// remove autokick time from table
-			deleteSQL := fmt.Sprintf("DELETE FROM %s WHERE (guild_id = '%s');", autokickTable, m.GuildID)
-			if queryWithoutResults(deleteSQL, "Unable to delete autokick entry!") {
-				_, err := s.ChannelMessageSend(m.ChannelID, "The server's auto-kick is now inactive.")
-				if err != nil {
-					logError("Failed to send autokick deactivation message! " + err.Error())
-					return
-				}
-				logSuccess("Removed server from autokick table and notified user")
-			} else {
-				_, err := s.ChannelMessageSend(m.ChannelID, "An error occurred. Please try again in a moment.")
-				if err != nil {
-					logError("Failed to send autokick error message! " + err.Error())
-					return
-				}
-				logWarning("Failed to deleted autokick entry! Is the connection still available?")
-			}
-		} else {
-			// set autokick day count
-			deleteSQL := fmt.Sprintf("DELETE FROM %s WHERE (guild_id = '%s');", autokickTable, m.GuildID)
-			if queryWithoutResults(deleteSQL, "Unable to delete old entry!") {
-				insertSQL := fmt.Sprintf("INSERT INTO %s (guild_id, days_until_kick) VALUES ('%s', %d);", autokickTable, m.GuildID, daysOfInactivity)
-				if queryWithoutResults(insertSQL, "Unable to insert new entry!") {
-					_, err := s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("The server's auto-kick will now kick users that have been inactive for %d+ days.", daysOfInactivity))
-					if err != nil {
-						logError("Failed to send autokick update message! " + err.Error())
-						return
-					}
-					logSuccess("Updated server in autokick table and notified user")
-				} else {
-					_, err := s.ChannelMessageSend(m.ChannelID, "An error occurred. Please try again in a moment.")
-					if err != nil {
-						logError("Failed to send autokick error message! " + err.Error())
-						return
-					}
-					logWarning("Failed to insert new autokick entry! Is the connection still available?")
-				}
-			} else {
-				_, err := s.ChannelMessageSend(m.ChannelID, "An error occurred. Please try again in a moment.")
-				if err != nil {
-					logError("Failed to send autokick error message! " + err.Error())
-					return
-				}
-				logWarning("Failed to delete old autokick entry! Is the connection still available?")
-			}
-		}
+		handleAutokick(s, m, command)
 	case "whitelist":
 		if !userHasValidPermissions(s, m, discordgo.PermissionKickMembers) {
 			logWarning("User attempted to use whitelist without proper permissions")
@@ -924,69 +919,9 @@ func activity(s *discordgo.Session, m *discordgo.MessageCreate, command []string
 			}
 		}
 	default:
-		_, err := s.ChannelMessageSend(m.ChannelID, "Usages: ```~activity rescan\n~activity list <number>\n~activity user <@user>\n~activity autokick <number of days of inactivity>\n~activity whitelist <@user> true/false```")
+		_, err := s.ChannelMessageSend(m.ChannelID, "Usages: ```~activity rescan\n~activity list <number> [--sort last-active/name] [--role @role] [--exclude-whitelisted] [--joined-only] [--export csv/json]\n~activity user <@user>\n~activity history <@user> <days: optional>\n~activity retention <days>\n~activity autokick <number of days of inactivity>\n~activity autokick preview\n~activity autokick run\n~activity autokick schedule\n~activity autokick warn <days before kick/off>\n~activity autokick exempt add/remove <@role>\n~activity autokick action kick/role <@role>\n~activity autokick report <#channel/off>\n~activity autokick --dry-run on/off\n~activity whitelist <@user> true/false```")
 		if err != nil {
 			logError("Failed to send activity usage message! " + err.Error())
 		}
 	}
 }
-
-/**
-Returns the users from the database who have been inactive for the requested number of days or more.
-*/
-func getInactiveUsers(s *discordgo.Session, m *discordgo.MessageCreate, command []string) []MemberActivity {
-	var inactiveUsers []MemberActivity
-	// fetch all users in this guild, then filter to users who have been inactive more than <number> days
-	if len(command) != 3 {
-		_, err := s.ChannelMessageSend(m.ChannelID, "Usages: ```~activity rescan\n~activity list <number>\n~activity user <@user>```")
-		if err != nil {
-			logError("Failed to send usage message! " + err.Error())
-		}
-		return inactiveUsers
-	}
-
-	selectSQL := fmt.Sprintf("SELECT * FROM %s WHERE (guild_id = '%s');", activityTable, m.GuildID)
-	results, err := connection_pool.Query(selectSQL)
-	if err != nil {
-		logError("Unable to read database for existing users in the guild! " + err.Error())
-		return inactiveUsers
-	}
-	defer results.Close()
-
-	// loop through members in the database and store them in an array
-	dateFormat := "2006-01-02 15:04:05.999999999 -0700 MST"
-
-	for results.Next() {
-		var memberActivity MemberActivity
-		err = results.Scan(&memberActivity.ID, &memberActivity.GuildID, &memberActivity.MemberID, &memberActivity.MemberName, &memberActivity.LastActive, &memberActivity.Description, &memberActivity.Whitelisted)
-		if err != nil {
-			logError("Unable to parse database information! Aborting. " + err.Error())
-			return inactiveUsers
-		}
-		daysInactive, err := strconv.Atoi(command[2])
-		if err != nil {
-			_, msgErr := s.ChannelMessageSend(m.ChannelID, "Usages: ```~activity rescan\n~activity list <number>\n~activity user <@user>```")
-			if msgErr != nil {
-				logError("Failed to send usage message! " + err.Error())
-			}
-			return inactiveUsers
-		}
-
-		if daysInactive < 1 {
-			inactiveUsers = append(inactiveUsers, memberActivity)
-		} else {
-			// calculate difference between time.Now() and the provided timestamp
-			lastActive, err := time.Parse(dateFormat, strings.Split(memberActivity.LastActive, " m=")[0])
-			if err != nil {
-				logError("Unable to parse database timestamps! Aborting. " + err.Error())
-				return inactiveUsers
-			}
-			lastActive = lastActive.AddDate(0, 0, daysInactive)
-			if lastActive.Before(time.Now()) {
-				inactiveUsers = append(inactiveUsers, memberActivity)
-			}
-		}
-	}
-	logSuccess("Searched for inactive users without any errors")
-	return inactiveUsers
-}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// the activity table's columns, in the order scanMemberActivity reads them
+const activityColumns = "entry, guild_id, member_id, member_name, last_active, description, whitelist, left_at, rejoin_count, last_online"
+
+const defaultDepartedDays = 30
+const defaultDepartedPurgeDays = 30
+
+/**
+Reads one row selected with activityColumns.
+*/
+func scanMemberActivity(rows *sql.Rows) (MemberActivity, error) {
+	var memberActivity MemberActivity
+	var leftAt, lastOnline sql.NullString
+	var whitelisted sql.NullInt64
+	err := rows.Scan(&memberActivity.ID, &memberActivity.GuildID, &memberActivity.MemberID, &memberActivity.MemberName, &memberActivity.LastActive, &memberActivity.Description, &whitelisted, &leftAt, &memberActivity.RejoinCount, &lastOnline)
+	memberActivity.Whitelisted = int(whitelisted.Int64)
+	memberActivity.LeftAt = leftAt.String
+	memberActivity.LastOnline = lastOnline.String
+	return memberActivity, err
+}
+
+/**
+Reports whether the member has a row in the activity table, whether or not they've left.
+*/
+func activityRowExists(guildID string, memberID string) (bool, error) {
+	var count int
+	selectSQL := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE (guild_id = '%s' AND member_id = '%s');", activityTable, guildID, memberID)
+	err := connection_pool.QueryRow(selectSQL).Scan(&count)
+	return count > 0, err
+}
+
+/**
+Clears the member's departure so they show up in lists and autokick again. Their whitelist
+flag is kept. rejoined counts it as a rejoin, rather than the bot noticing them after a rescan.
+*/
+func restoreDepartedMember(guildID string, memberID string, rejoined bool) {
+	rejoinSQL := ""
+	if rejoined {
+		rejoinSQL = ", rejoin_count = rejoin_count + 1"
+	}
+	updateSQL := fmt.Sprintf("UPDATE %s SET left_at = NULL%s WHERE (guild_id = '%s' AND member_id = '%s' AND left_at IS NOT NULL);",
+		activityTable, rejoinSQL, guildID, memberID)
+	queryWithoutResults(updateSQL, "Unable to restore departed member!")
+}
+
+/**
+Reads the members who left the guild since the given time, most recent first.
+*/
+func getDepartedMembers(guildID string, since time.Time) ([]MemberActivity, error) {
+	var members []MemberActivity
+	selectSQL := fmt.Sprintf("SELECT %s FROM %s WHERE (guild_id = '%s' AND left_at >= '%s') ORDER BY left_at DESC;", activityColumns, activityTable, guildID, sqlTimestamp(since))
+	query, err := connection_pool.Query(selectSQL)
+	if err != nil {
+		return members, err
+	}
+	defer query.Close()
+	for query.Next() {
+		memberActivity, err := scanMemberActivity(query)
+		if err != nil {
+			return members, err
+		}
+		members = append(members, memberActivity)
+	}
+	return members, nil
+}
+
+/**
+Describes when each member left and how often they've come back.
+*/
+func departedLines(members []MemberActivity) []string {
+	var lines []string
+	for _, member := range members {
+		line := member.MemberName
+		if leftAt, err := parseSQLTimestamp(member.LeftAt); err == nil {
+			line += " - left " + leftAt.Format("01/02/2006 15:04")
+		}
+		switch member.RejoinCount {
+		case 0:
+		case 1:
+			line += " (rejoined once)"
+		default:
+			line += fmt.Sprintf(" (rejoined %d times)", member.RejoinCount)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+/**
+Parses the purge setting. Only an explicit "0" or "off" keeps departed members forever; anything
+unset or unreadable falls back to the default so members aren't kept by mistake.
+*/
+func parseDepartedPurge(value string) int {
+	if value == "0" || value == "off" {
+		return 0
+	}
+	days, err := strconv.Atoi(value)
+	if err != nil || days < 1 {
+		return defaultDepartedPurgeDays
+	}
+	return days
+}
+
+/**
+Returns how many days departed members are kept before being purged, or 0 if they never are.
+*/
+func getDepartedPurgeDays(guildID string) int {
+	return parseDepartedPurge(getGuildSetting(guildID, "activity_departed_purge"))
+}
+
+/**
+Deletes the activity and history of members who left longer ago than each guild's purge period, every hour.
+*/
+func runDepartedPurge() {
+	for {
+		query, err := connection_pool.Query(fmt.Sprintf("SELECT DISTINCT guild_id FROM %s WHERE left_at IS NOT NULL;", activityTable))
+		if err != nil {
+			logError("SELECT query error: " + err.Error())
+		} else {
+			var guildIDs []string
+			for query.Next() {
+				var guildID string
+				if err = query.Scan(&guildID); err == nil {
+					guildIDs = append(guildIDs, guildID)
+				}
+			}
+			query.Close()
+			for _, guildID := range guildIDs {
+				days := getDepartedPurgeDays(guildID)
+				if days == 0 {
+					continue
+				}
+				cutoff := sqlTimestamp(time.Now().AddDate(0, 0, -days))
+				deleteEventsSQL := fmt.Sprintf("DELETE FROM %s WHERE (guild_id = '%s' AND member_id IN (SELECT member_id FROM %s WHERE guild_id = '%s' AND left_at < '%s'));",
+					activityEventsTable, guildID, activityTable, guildID, cutoff)
+				if !queryWithoutResults(deleteEventsSQL, "Unable to purge departed members' history!") {
+					continue
+				}
+				deleteSQL := fmt.Sprintf("DELETE FROM %s WHERE (guild_id = '%s' AND left_at < '%s');", activityTable, guildID, cutoff)
+				queryWithoutResults(deleteSQL, "Unable to purge departed members!")
+			}
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+/****
+COMMANDS
+****/
+
+/**
+Lists the members who left in the last few days.
+**/
+func handleActivityDeparted(s *discordgo.Session, m *discordgo.MessageCreate, command []string) {
+	days := defaultDepartedDays
+	if len(command) == 3 {
+		parsed, err := strconv.Atoi(command[2])
+		if err == nil && parsed > 0 {
+			days = parsed
+		} else {
+			days = 0
+		}
+	}
+	if len(command) > 3 || days == 0 {
+		_, err := s.ChannelMessageSend(m.ChannelID, "Usage: ```~activity departed <days: optional>```")
+		if err != nil {
+			logError("Failed to send usage message! " + err.Error())
+		}
+		return
+	}
+	members, err := getDepartedMembers(m.GuildID, time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		logError("Unable to read departed members! " + err.Error())
+		_, err = s.ChannelMessageSend(m.ChannelID, "An error occurred. Please try again in a moment.")
+		if err != nil {
+			logError("Failed to send error message! " + err.Error())
+		}
+		return
+	}
+	var embed discordgo.MessageEmbed
+	embed.Type = "rich"
+	embed.Title = fmt.Sprintf("Members Who Left in the Last %d Days", days)
+	embed.Description = listForField(departedLines(members))
+	var footer discordgo.MessageEmbedFooter
+	if purgeDays := getDepartedPurgeDays(m.GuildID); purgeDays > 0 {
+		footer.Text = fmt.Sprintf("Departed members are purged after %d days", purgeDays)
+	} else {
+		footer.Text = "Departed members are never purged"
+	}
+	embed.Footer = &footer
+	_, err = s.ChannelMessageSendEmbed(m.ChannelID, &embed)
+	if err != nil {
+		logError("Failed to send departed members message! " + err.Error())
+		return
+	}
+	logSuccess("Sent departed members message")
+}
+
+/**
+Shows or changes how many days departed members are kept before their data is deleted.
+**/
+func handleActivityPurge(s *discordgo.Session, m *discordgo.MessageCreate, command []string) {
+	if !userHasValidPermissions(s, m, discordgo.PermissionManageServer) {
+		logWarning("User attempted to change the departed member purge without proper permissions")
+		_, err := s.ChannelMessageSend(m.ChannelID, "Sorry, you don't have the `Manage Server` permission.")
+		if err != nil {
+			logError("Failed to send permissions message! " + err.Error())
+		}
+		return
+	}
+	if len(command) == 2 {
+		response := "Departed members are never purged."
+		if days := getDepartedPurgeDays(m.GuildID); days > 0 {
+			response = fmt.Sprintf("Departed members are purged %d days after they leave.", days)
+		}
+		_, err := s.ChannelMessageSend(m.ChannelID, response)
+		if err != nil {
+			logError("Failed to send departed purge message! " + err.Error())
+		}
+		return
+	}
+	value := command[2]
+	if value == "off" {
+		value = "0"
+	}
+	days, err := strconv.Atoi(value)
+	if len(command) != 3 || err != nil || days < 0 {
+		_, err = s.ChannelMessageSend(m.ChannelID, "Usage: ```~activity purge <days/off>```")
+		if err != nil {
+			logError("Failed to send usage message! " + err.Error())
+		}
+		return
+	}
+	response := fmt.Sprintf("Departed members will now be purged %d days after they leave.", days)
+	if days == 0 {
+		response = "Departed members will no longer be purged."
+	}
+	if !setGuildSetting(m.GuildID, "activity_departed_purge", value) {
+		response = "An error occurred. Please try again in a moment."
+	}
+	_, err = s.ChannelMessageSend(m.ChannelID, response)
+	if err != nil {
+		logError("Failed to send departed purge message! " + err.Error())
+		return
+	}
+	logSuccess("Updated departed member purge")
+}
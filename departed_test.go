@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+/**
+Test anything that uses calculation outside of the discordgo commands.
+Those commands don't need to be tested since they are verified to work
+at github.com/bwmarrin/discordgo
+**/
+func TestDeparted(t *testing.T) {
+	leftAt := sqlTimestamp(time.Date(2021, 6, 30, 12, 0, 0, 0, time.UTC))
+
+	t.Run("Departed members show when they left and how often they came back", func(t *testing.T) {
+		lines := departedLines([]MemberActivity{
+			{MemberName: "once", LeftAt: leftAt, RejoinCount: 1},
+			{MemberName: "never", LeftAt: leftAt},
+			{MemberName: "often", LeftAt: leftAt, RejoinCount: 3},
+		})
+		expected := []string{
+			"once - left 06/30/2021 12:00 (rejoined once)",
+			"never - left 06/30/2021 12:00",
+			"often - left 06/30/2021 12:00 (rejoined 3 times)",
+		}
+		for i, line := range expected {
+			if len(lines) != len(expected) || lines[i] != line {
+				t.Logf("Expected %v, got %v", expected, lines)
+				t.Fail()
+				break
+			}
+		}
+	})
+
+	t.Run("The purge defaults to 30 days and can be turned off", func(t *testing.T) {
+		for _, value := range []string{"", "nonsense", "-3"} {
+			if days := parseDepartedPurge(value); days != defaultDepartedPurgeDays {
+				t.Logf("Expected '%s' to use the default purge, got %d", value, days)
+				t.Fail()
+			}
+		}
+		if days := parseDepartedPurge("7"); days != 7 {
+			t.Logf("Expected 7 days, got %d", days)
+			t.Fail()
+		}
+		if parseDepartedPurge("0") != 0 || parseDepartedPurge("off") != 0 {
+			t.Log("Expected only 0 and off to keep departed members forever")
+			t.Fail()
+		}
+	})
+}
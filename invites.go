@@ -0,0 +1,241 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// InviteUse : how many times an invite had been used when it was last checked, and who made it
+type InviteUse struct {
+	Uses      int
+	MaxUses   int
+	InviterID string
+}
+
+// InviterStats : how many members an inviter brought in, and how many of them are still here
+type InviterStats struct {
+	InviterID string
+	Brought   int
+	Stayed    int
+}
+
+var inviteSnapshots = map[string]map[string]InviteUse{}
+var inviteSnapshotsLock sync.Mutex
+
+/**
+Fetches the guild's invites and how often each has been used. The bot needs the
+Manage Server permission to see them.
+*/
+func fetchInviteUses(s *discordgo.Session, guildID string) (map[string]InviteUse, error) {
+	invites, err := s.GuildInvites(guildID)
+	if err != nil {
+		return nil, err
+	}
+	uses := map[string]InviteUse{}
+	for _, invite := range invites {
+		use := InviteUse{Uses: invite.Uses, MaxUses: invite.MaxUses}
+		if invite.Inviter != nil {
+			use.InviterID = invite.Inviter.ID
+		}
+		uses[invite.Code] = use
+	}
+	return uses, nil
+}
+
+/**
+Remembers how often each of the guild's invites has been used, so the next join can be attributed.
+*/
+func snapshotInvites(s *discordgo.Session, guildID string) {
+	uses, err := fetchInviteUses(s, guildID)
+	if err != nil {
+		logWarning("Unable to fetch invites for guild " + guildID + ". " + err.Error())
+		return
+	}
+	inviteSnapshotsLock.Lock()
+	inviteSnapshots[guildID] = uses
+	inviteSnapshotsLock.Unlock()
+}
+
+/**
+Compares invite uses before and after a join and returns the code that was used. An invite
+that disappeared after reaching its last use also counts. Returns "" if the join can't be
+pinned on exactly one invite, along with whether that's because several invites went up.
+*/
+func findUsedInvite(before map[string]InviteUse, after map[string]InviteUse) (string, bool) {
+	var candidates []string
+	for code, use := range after {
+		if use.Uses > before[code].Uses {
+			candidates = append(candidates, code)
+		}
+	}
+	if len(candidates) == 0 {
+		for code, use := range before {
+			if _, ok := after[code]; !ok && use.MaxUses > 0 && use.Uses == use.MaxUses-1 {
+				candidates = append(candidates, code)
+			}
+		}
+	}
+	if len(candidates) != 1 {
+		return "", len(candidates) > 1
+	}
+	return candidates[0], false
+}
+
+/**
+Works out which invite the new member used and records it along with who made it.
+*/
+func trackInviteJoin(s *discordgo.Session, m *discordgo.GuildMemberAdd) {
+	after, err := fetchInviteUses(s, m.GuildID)
+	if err != nil {
+		logWarning("Unable to fetch invites for guild " + m.GuildID + ". " + err.Error())
+		return
+	}
+	inviteSnapshotsLock.Lock()
+	before, known := inviteSnapshots[m.GuildID]
+	inviteSnapshots[m.GuildID] = after
+	inviteSnapshotsLock.Unlock()
+	if !known {
+		return
+	}
+
+	code, ambiguous := findUsedInvite(before, after)
+	inviterID := ""
+	if use, ok := after[code]; ok {
+		inviterID = use.InviterID
+	} else if use, ok := before[code]; ok {
+		inviterID = use.InviterID
+	}
+	if code == "" {
+		// only a join that didn't use up any invite can have come through the vanity URL
+		if guild, err := s.State.Guild(m.GuildID); err == nil && guild.VanityURLCode != "" && !ambiguous {
+			code = "vanity"
+		} else {
+			logWarning("Unable to tell which invite " + m.User.ID + " used")
+			return
+		}
+	}
+
+	replaceSQL := fmt.Sprintf("REPLACE INTO %s (guild_id, member_id, inviter_id, code, joined_at, left_at) VALUES ('%s', '%s', '%s', '%s', '%s', NULL);",
+		inviteJoinsTable, m.GuildID, m.User.ID, inviterID, escapeSQL(code), sqlTimestamp(time.Now()))
+	queryWithoutResults(replaceSQL, "Unable to record invite use!")
+}
+
+/**
+Marks the member's invite as no longer having kept them around.
+*/
+func markInviteLeave(guildID string, userID string) {
+	updateSQL := fmt.Sprintf("UPDATE %s SET left_at = '%s' WHERE (guild_id = '%s' AND member_id = '%s');", inviteJoinsTable, sqlTimestamp(time.Now()), guildID, userID)
+	queryWithoutResults(updateSQL, "Unable to record invite leave!")
+}
+
+/**
+Returns who invited the member, or "" if it isn't known.
+*/
+func getInviter(guildID string, userID string) string {
+	selectSQL := fmt.Sprintf("SELECT inviter_id FROM %s WHERE (guild_id = '%s' AND member_id = '%s');", inviteJoinsTable, guildID, userID)
+	var inviterID string
+	err := connection_pool.QueryRow(selectSQL).Scan(&inviterID)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logError("Unable to read invite use! " + err.Error())
+		}
+		return ""
+	}
+	return inviterID
+}
+
+/**
+Returns how many members each inviter brought in and how many stayed, most first.
+Pass a member ID to only count that inviter.
+*/
+func getInviterStats(guildID string, inviterID string) ([]InviterStats, error) {
+	var stats []InviterStats
+	where := fmt.Sprintf("guild_id = '%s' AND inviter_id != ''", guildID)
+	if inviterID != "" {
+		where += fmt.Sprintf(" AND inviter_id = '%s'", inviterID)
+	}
+	selectSQL := fmt.Sprintf("SELECT inviter_id, COUNT(*), SUM(left_at IS NULL) FROM %s WHERE (%s) GROUP BY inviter_id ORDER BY COUNT(*) DESC LIMIT 10;", inviteJoinsTable, where)
+	query, err := connection_pool.Query(selectSQL)
+	if err != nil {
+		return stats, err
+	}
+	defer query.Close()
+	for query.Next() {
+		var stat InviterStats
+		err = query.Scan(&stat.InviterID, &stat.Brought, &stat.Stayed)
+		if err != nil {
+			return stats, err
+		}
+		stats = append(stats, stat)
+	}
+	return stats, nil
+}
+
+/****
+COMMANDS
+****/
+
+/**
+Shows how many members someone has invited, or the server's top inviters.
+**/
+func handleInvites(s *discordgo.Session, m *discordgo.MessageCreate, command []string) {
+	logInfo(strings.Join(command, " "))
+	if len(command) != 2 || (command[1] != "top" && len(m.Mentions) != 1) {
+		_, err := s.ChannelMessageSend(m.ChannelID, "Usages: ```~invites @user\n~invites top```")
+		if err != nil {
+			logError("Failed to send usage message! " + err.Error())
+		}
+		return
+	}
+
+	inviterID := ""
+	if command[1] != "top" {
+		inviterID = m.Mentions[0].ID
+	}
+	stats, err := getInviterStats(m.GuildID, inviterID)
+	if err != nil {
+		logError("Unable to read invite stats! " + err.Error())
+		_, err = s.ChannelMessageSend(m.ChannelID, "An error occurred. Please try again in a moment.")
+		if err != nil {
+			logError("Failed to send error message! " + err.Error())
+		}
+		return
+	}
+
+	var embed discordgo.MessageEmbed
+	embed.Type = "rich"
+	if inviterID != "" {
+		user := m.Mentions[0]
+		embed.Title = "Invites by " + user.Username + "#" + user.Discriminator
+		brought, stayed := 0, 0
+		if len(stats) == 1 {
+			brought, stayed = stats[0].Brought, stats[0].Stayed
+		}
+		embed.Fields = []*discordgo.MessageEmbedField{
+			createField("Brought in", fmt.Sprintf("%d", brought), true),
+			createField("Still here", fmt.Sprintf("%d", stayed), true),
+			createField("Left", fmt.Sprintf("%d", brought-stayed), true),
+		}
+	} else {
+		embed.Title = "Top Inviters"
+		var lines []string
+		for i, stat := range stats {
+			lines = append(lines, fmt.Sprintf("**%d.** <@%s>: %d invited, %d still here", i+1, stat.InviterID, stat.Brought, stat.Stayed))
+		}
+		if len(lines) == 0 {
+			lines = append(lines, "No invites have been tracked yet.")
+		}
+		embed.Description = strings.Join(lines, "\n")
+	}
+	_, err = s.ChannelMessageSendEmbed(m.ChannelID, &embed)
+	if err != nil {
+		logError("Failed to send invite stats! " + err.Error())
+		return
+	}
+	logSuccess("Sent invite stats")
+}
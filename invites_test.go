@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+)
+
+/**
+Test anything that uses calculation outside of the discordgo commands.
+Those commands don't need to be tested since they are verified to work
+at github.com/bwmarrin/discordgo
+**/
+func TestInvites(t *testing.T) {
+	before := map[string]InviteUse{
+		"abc": {Uses: 3, InviterID: "1"},
+		"def": {Uses: 0, InviterID: "2"},
+		"one": {Uses: 4, MaxUses: 5, InviterID: "3"},
+	}
+
+	t.Run("The invite whose uses went up is found", func(t *testing.T) {
+		after := map[string]InviteUse{"abc": {Uses: 3}, "def": {Uses: 1}, "one": {Uses: 4, MaxUses: 5}}
+		if code, _ := findUsedInvite(before, after); code != "def" {
+			t.Logf("Expected def, got '%s'", code)
+			t.Fail()
+		}
+	})
+
+	t.Run("New invites count from zero", func(t *testing.T) {
+		after := map[string]InviteUse{"abc": {Uses: 3}, "def": {Uses: 0}, "one": {Uses: 4, MaxUses: 5}, "new": {Uses: 1}}
+		if code, _ := findUsedInvite(before, after); code != "new" {
+			t.Logf("Expected new, got '%s'", code)
+			t.Fail()
+		}
+	})
+
+	t.Run("Invites deleted after their last use are found", func(t *testing.T) {
+		after := map[string]InviteUse{"abc": {Uses: 3}, "def": {Uses: 0}}
+		if code, _ := findUsedInvite(before, after); code != "one" {
+			t.Logf("Expected one, got '%s'", code)
+			t.Fail()
+		}
+	})
+
+	t.Run("Ambiguous joins are not attributed", func(t *testing.T) {
+		after := map[string]InviteUse{"abc": {Uses: 4}, "def": {Uses: 1}, "one": {Uses: 4, MaxUses: 5}}
+		if code, ambiguous := findUsedInvite(before, after); code != "" || !ambiguous {
+			t.Logf("Expected no invite and an ambiguous join, got '%s' (ambiguous: %t)", code, ambiguous)
+			t.Fail()
+		}
+	})
+
+	t.Run("Joins that use no invite are not ambiguous", func(t *testing.T) {
+		if code, ambiguous := findUsedInvite(before, before); code != "" || ambiguous {
+			t.Logf("Expected no invite and no ambiguity, got '%s' (ambiguous: %t)", code, ambiguous)
+			t.Fail()
+		}
+	})
+}
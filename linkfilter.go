@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"golang.org/x/net/idna"
+)
+
+// LinkFilterConfig : which links a guild removes, plus domains it always allows or always blocks
+type LinkFilterConfig struct {
+	Enabled  bool     `json:"enabled"`
+	Invites  bool     `json:"invites"`
+	Phishing bool     `json:"phishing"`
+	Warn     bool     `json:"warn"`
+	Allow    []string `json:"allow"`
+	Deny     []string `json:"deny"`
+}
+
+var defaultLinkFilterConfig = LinkFilterConfig{Invites: true, Phishing: true}
+
+// domains that really belong to Discord, and so are never lookalikes
+var officialDomains = []string{"discord.com", "discord.gg", "discordapp.com", "discordapp.net", "discord.media", "discord.gift", "discord.new", "discord.co", "discord.dev", "discordstatus.com", "dis.gd"}
+
+// words phishing domains pair with "discord", e.g. discord-nitro.gift or discordgift.site
+var phishingKeywords = []string{"nitro", "gift", "free", "giveaway", "airdrop", "steam", "claim", "promo"}
+
+// characters that are swapped in to make a domain look like another one
+var lookalikeCharacters = strings.NewReplacer(
+	"0", "o", "1", "i", "l", "i", "3", "e", "5", "s", "ı", "i",
+	"а", "a", "е", "e", "о", "o", "р", "p", "с", "c", "і", "i", "ӏ", "i", "ԁ", "d", "ѕ", "s", "ɡ", "g",
+)
+
+var linkFilterConfigs = map[string]LinkFilterConfig{}
+var linkFilterLock sync.Mutex
+
+var linkBlocklist map[string]bool
+var linkBlocklistOnce sync.Once
+
+/**
+Returns the phishing domains listed in the LINK_BLOCKLIST file, one per line. Lines starting with # are ignored.
+The file is only read once.
+*/
+func getLinkBlocklist() map[string]bool {
+	linkBlocklistOnce.Do(func() {
+		linkBlocklist = map[string]bool{}
+		path := os.Getenv("LINK_BLOCKLIST")
+		if path == "" {
+			return
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			logError("Unable to open link blocklist! " + err.Error())
+			return
+		}
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" && !strings.HasPrefix(line, "#") {
+				linkBlocklist[normalizeHost(line)] = true
+			}
+		}
+		logInfo(fmt.Sprintf("Loaded %d domains into the link blocklist", len(linkBlocklist)))
+	})
+	return linkBlocklist
+}
+
+/**
+Returns the guild's link filter settings, loading them from the guild settings table if they aren't cached.
+Must be called with linkFilterLock held.
+*/
+func getLinkFilterConfig(guildID string) LinkFilterConfig {
+	if config, ok := linkFilterConfigs[guildID]; ok {
+		return config
+	}
+	config := defaultLinkFilterConfig
+	if raw := getGuildSetting(guildID, "linkfilter"); raw != "" {
+		err := json.Unmarshal([]byte(raw), &config)
+		if err != nil {
+			logWarning("Unable to read link filter settings, using the defaults. " + err.Error())
+		}
+	}
+	linkFilterConfigs[guildID] = config
+	return config
+}
+
+/**
+Saves the guild's link filter settings and updates the cache.
+*/
+func setLinkFilterConfig(guildID string, config LinkFilterConfig) bool {
+	raw, err := json.Marshal(config)
+	if err != nil {
+		logError("Unable to encode link filter settings! " + err.Error())
+		return false
+	}
+	if !setGuildSetting(guildID, "linkfilter", string(raw)) {
+		return false
+	}
+	linkFilterLock.Lock()
+	linkFilterConfigs[guildID] = config
+	linkFilterLock.Unlock()
+	return true
+}
+
+/**
+Lower-cases the host, decodes punycode (xn--) labels and drops a leading "www.".
+*/
+func normalizeHost(host string) string {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if decoded, err := idna.ToUnicode(host); err == nil {
+		host = decoded
+	}
+	return strings.TrimPrefix(host, "www.")
+}
+
+/**
+Returns true if the host is the domain or one of its subdomains.
+*/
+func hostMatches(host string, domain string) bool {
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+/**
+Returns true if the host is, or is under, any of the domains.
+*/
+func hostInList(host string, domains []string) bool {
+	for _, domain := range domains {
+		if hostMatches(host, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+Returns the number of single character edits needed to turn one word into the other.
+*/
+func editDistance(a string, b string) int {
+	first := []rune(a)
+	second := []rune(b)
+	previous := make([]int, len(second)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(first); i++ {
+		current := make([]int, len(second)+1)
+		current[0] = i
+		for j := 1; j <= len(second); j++ {
+			cost := 1
+			if first[i-1] == second[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j] + 1
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+			if previous[j-1]+cost < current[j] {
+				current[j] = previous[j-1] + cost
+			}
+		}
+		previous = current
+	}
+	return previous[len(second)]
+}
+
+/**
+Returns true if the host is pretending to be Discord: "discord" spelled with lookalike characters
+like dlscord.com, or "discord" (or a near misspelling of it) paired with a word like nitro or gift.
+Misspellings on their own aren't enough, since real words like discard are that close too.
+*/
+func isDiscordLookalike(host string) bool {
+	if hostInList(host, officialDomains) {
+		return false
+	}
+	skeleton := lookalikeCharacters.Replace(host)
+	if skeleton != host && hostInList(skeleton, officialDomains) {
+		return true
+	}
+	hasKeyword := false
+	for _, keyword := range phishingKeywords {
+		// keywords go through the same replacements, or ones like "claim" could never match
+		if strings.Contains(skeleton, lookalikeCharacters.Replace(keyword)) {
+			hasKeyword = true
+		}
+	}
+	words := strings.FieldsFunc(skeleton, func(r rune) bool { return r == '.' || r == '-' })
+	for _, word := range words {
+		if !strings.Contains(word, "discord") {
+			if hasKeyword && len(word) >= 6 && editDistance(word, "discord") <= 2 {
+				return true
+			}
+			continue
+		}
+		if !strings.Contains(host, "discord") || hasKeyword {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+Returns why the link should be removed, or "" if it's allowed. Invites are checked separately
+since they need to be looked up.
+*/
+func classifyLink(config LinkFilterConfig, blocklist map[string]bool, link *url.URL) string {
+	host := normalizeHost(link.Hostname())
+	if hostInList(host, config.Allow) {
+		return ""
+	}
+	if hostInList(host, config.Deny) {
+		return "Link to a denied domain (" + host + ")"
+	}
+	if !config.Phishing {
+		return ""
+	}
+	for domain := range blocklist {
+		if hostMatches(host, domain) {
+			return "Link to a known phishing domain (" + host + ")"
+		}
+	}
+	if isDiscordLookalike(host) {
+		return "Link to a Discord lookalike domain (" + host + ")"
+	}
+	return ""
+}
+
+/**
+Returns the invite code if the link is a Discord invite, or "" if it isn't.
+*/
+func inviteCode(link *url.URL) string {
+	host := normalizeHost(link.Hostname())
+	path := strings.Split(strings.Trim(link.Path, "/"), "/")
+	if host == "discord.gg" && path[0] != "" {
+		return path[0]
+	}
+	if (host == "discord.com" || host == "discordapp.com") && len(path) == 2 && path[0] == "invite" {
+		return path[1]
+	}
+	return ""
+}
+
+/**
+Removes messages with invites to other servers or links to phishing domains, optionally
+warns the author, and writes what happened to the mod log.
+*/
+func checkLinks(s *discordgo.Session, m *discordgo.MessageCreate) {
+	if m.GuildID == "" || m.Author == nil || m.Author.Bot {
+		return
+	}
+	linkFilterLock.Lock()
+	config := getLinkFilterConfig(m.GuildID)
+	linkFilterLock.Unlock()
+	if !config.Enabled {
+		return
+	}
+	links := extractURLs(m.Content)
+	if len(links) == 0 {
+		return
+	}
+	// staff are trusted to post whatever links they like
+	perms, err := s.UserChannelPermissions(m.Author.ID, m.ChannelID)
+	if err == nil && perms&discordgo.PermissionManageMessages != 0 {
+		return
+	}
+
+	reason := ""
+	for _, link := range links {
+		reason = classifyLink(config, getLinkBlocklist(), link)
+		if reason == "" && config.Invites {
+			if code := inviteCode(link); code != "" {
+				invite, err := s.Invite(code)
+				if err != nil {
+					logWarning("Unable to look up invite " + code + ". " + err.Error())
+				} else if invite.Guild != nil && invite.Guild.ID != m.GuildID {
+					reason = "Invite to another server (" + invite.Guild.Name + ")"
+				}
+			}
+		}
+		if reason != "" {
+			break
+		}
+	}
+	if reason == "" {
+		return
+	}
+	logInfo("Filtered link: " + reason)
+
+	result := "Deleted the message"
+	err = s.ChannelMessageDelete(m.ChannelID, m.ID)
+	if err == nil && config.Warn {
+		var escalation string
+		escalation, err = warnMember(s, m.GuildID, m.Author.ID, s.State.User, reason)
+		result += " and warned the user"
+		if escalation != "" {
+			result += ", who was automatically " + escalation
+		}
+	}
+	if err != nil {
+		logError("Failed to carry out link filter action! " + err.Error())
+		result += fmt.Sprintf(" (failed: %s)", err.Error())
+	}
+
+	var embed discordgo.MessageEmbed
+	embed.Title = "Link filter: " + reason
+	embed.Description = fmt.Sprintf("<@%s> in <#%s>", m.Author.ID, m.ChannelID)
+	embed.Fields = []*discordgo.MessageEmbedField{
+		createField("Message", "```"+strings.ReplaceAll(truncateField(m.Content, 1000), "`", "'")+"```", false),
+		createField("Action", result, false),
+	}
+	embed.Timestamp = time.Now().Format(time.RFC3339)
+	sendModLog(s, m.GuildID, &embed)
+}
+
+/**
+Removes the domain from the list, returning the new list.
+*/
+func removeDomain(domains []string, domain string) []string {
+	var kept []string
+	for _, existing := range domains {
+		if existing != domain {
+			kept = append(kept, existing)
+		}
+	}
+	return kept
+}
+
+/****
+COMMANDS
+****/
+
+/**
+Shows or changes the guild's link filter settings.
+**/
+func handleLinkFilter(s *discordgo.Session, m *discordgo.MessageCreate, command []string) {
+	logInfo(strings.Join(command, " "))
+	if !userHasValidPermissions(s, m, discordgo.PermissionManageServer) {
+		logWarning("User attempted to change the link filter without proper permissions")
+		_, err := s.ChannelMessageSend(m.ChannelID, "Sorry, you don't have the `Manage Server` permission.")
+		if err != nil {
+			logError("Failed to send permissions message! " + err.Error())
+		}
+		return
+	}
+
+	linkFilterLock.Lock()
+	config := getLinkFilterConfig(m.GuildID)
+	linkFilterLock.Unlock()
+
+	if len(command) == 1 {
+		onOff := map[bool]string{true: "on", false: "off"}
+		allow := "none"
+		if len(config.Allow) > 0 {
+			allow = strings.Join(config.Allow, ", ")
+		}
+		deny := "none"
+		if len(config.Deny) > 0 {
+			deny = strings.Join(config.Deny, ", ")
+		}
+		var embed discordgo.MessageEmbed
+		embed.Type = "rich"
+		embed.Title = "Link filter is " + onOff[config.Enabled]
+		embed.Fields = []*discordgo.MessageEmbedField{
+			createField("Invites to other servers", onOff[config.Invites], true),
+			createField("Phishing domains", onOff[config.Phishing], true),
+			createField("Warn", onOff[config.Warn], true),
+			createField("Allowed domains", allow, false),
+			createField("Denied domains", deny, false),
+		}
+		_, err := s.ChannelMessageSendEmbed(m.ChannelID, &embed)
+		if err != nil {
+			logError("Failed to send link filter settings! " + err.Error())
+			return
+		}
+		logSuccess("Sent link filter settings")
+		return
+	}
+
+	usage := "Usages: ```~linkfilter\n~linkfilter on/off\n~linkfilter invites/phishing/warn on/off\n~linkfilter allow/deny/remove <domain>```"
+	valid := true
+	switch {
+	case len(command) == 2 && (command[1] == "on" || command[1] == "off"):
+		config.Enabled = command[1] == "on"
+	case len(command) == 3 && (command[1] == "invites" || command[1] == "phishing" || command[1] == "warn"):
+		valid = command[2] == "on" || command[2] == "off"
+		switch command[1] {
+		case "invites":
+			config.Invites = command[2] == "on"
+		case "phishing":
+			config.Phishing = command[2] == "on"
+		case "warn":
+			config.Warn = command[2] == "on"
+		}
+	case len(command) == 3 && (command[1] == "allow" || command[1] == "deny" || command[1] == "remove"):
+		links := extractURLs(command[2])
+		valid = len(links) == 1
+		if !valid {
+			break
+		}
+		domain := normalizeHost(links[0].Hostname())
+		config.Allow = removeDomain(config.Allow, domain)
+		config.Deny = removeDomain(config.Deny, domain)
+		if command[1] == "allow" {
+			config.Allow = append(config.Allow, domain)
+		} else if command[1] == "deny" {
+			config.Deny = append(config.Deny, domain)
+		}
+	default:
+		valid = false
+	}
+	if !valid {
+		_, err := s.ChannelMessageSend(m.ChannelID, usage)
+		if err != nil {
+			logError("Failed to send usage message! " + err.Error())
+		}
+		return
+	}
+
+	response := "Updated the link filter settings."
+	if !setLinkFilterConfig(m.GuildID, config) {
+		response = "An error occurred. Please try again in a moment."
+	}
+	_, err := s.ChannelMessageSend(m.ChannelID, response)
+	if err != nil {
+		logError("Failed to send link filter result message! " + err.Error())
+		return
+	}
+	logSuccess("Updated link filter settings")
+}
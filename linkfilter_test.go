@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+)
+
+/**
+Test anything that uses calculation outside of the discordgo commands.
+Those commands don't need to be tested since they are verified to work
+at github.com/bwmarrin/discordgo
+**/
+func TestLinkFilter(t *testing.T) {
+	t.Run("Links are found through common obfuscations", func(t *testing.T) {
+		links := extractURLs("join discord[.]gg/abc123 or hxxps://evil.example/login and <https://discord.com/invite/xyz>")
+		if len(links) != 3 || links[0].Hostname() != "discord.gg" || links[1].Hostname() != "evil.example" || inviteCode(links[0]) != "abc123" || inviteCode(links[2]) != "xyz" {
+			t.Logf("Extracted links incorrectly: %v", links)
+			t.Fail()
+		}
+	})
+
+	t.Run("Message links still parse after extraction", func(t *testing.T) {
+		links := extractURLs("look at https://discord.com/channels/1/2/3 please")
+		if len(links) != 1 {
+			t.Logf("Expected 1 link, got %d", len(links))
+			t.FailNow()
+		}
+		if _, _, messageID, ok := parseMessageLink(links[0].String()); !ok || messageID != "3" {
+			t.Logf("Failed to parse extracted message link")
+			t.Fail()
+		}
+	})
+
+	t.Run("Punycode hosts are decoded", func(t *testing.T) {
+		if host := normalizeHost("WWW.xn--dscord-pvf.com"); host != "dіscord.com" {
+			t.Logf("Expected the decoded host, got %s", host)
+			t.Fail()
+		}
+	})
+
+	t.Run("Discord lookalikes are caught", func(t *testing.T) {
+		for _, host := range []string{"discord-nitro.gift", "dlscord.com", "disc0rd-app.com", "discordgift.site", "dіscord.com", "steamdiscord-free.ru", "discrod-nitro.com", "discord-claim.xyz"} {
+			if !isDiscordLookalike(host) {
+				t.Logf("Failed to flag %s", host)
+				t.Fail()
+			}
+		}
+		for _, host := range []string{"discord.com", "cdn.discordapp.com", "discord.gift", "discordjs.guide", "discord.js.org", "github.com", "discard.com", "discos.shop"} {
+			if isDiscordLookalike(host) {
+				t.Logf("Flagged legitimate domain %s", host)
+				t.Fail()
+			}
+		}
+	})
+
+	t.Run("Guild lists override the blocklist", func(t *testing.T) {
+		blocklist := map[string]bool{"bad.example": true}
+		config := defaultLinkFilterConfig
+		link := extractURLs("https://login.bad.example/")[0]
+		if classifyLink(config, blocklist, link) == "" {
+			t.Logf("Failed to flag a blocklisted subdomain")
+			t.Fail()
+		}
+		config.Allow = []string{"bad.example"}
+		if classifyLink(config, blocklist, link) != "" {
+			t.Logf("Allowed domain was still flagged")
+			t.Fail()
+		}
+		config.Deny = []string{"example.org"}
+		if classifyLink(config, blocklist, extractURLs("example.org/page")[0]) == "" {
+			t.Logf("Denied domain was not flagged")
+			t.Fail()
+		}
+	})
+}
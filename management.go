@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
@@ -25,10 +27,14 @@ func userHasValidPermissions(s *discordgo.Session, m *discordgo.MessageCreate, p
 		}
 		return false
 	}
-	if perms|permission == perms || perms|discordgo.PermissionAdministrator == perms {
-		return true
-	}
-	return false
+	return permissionsInclude(perms, permission)
+}
+
+/**
+Reports whether a set of permissions includes every bit of permission, or administrator.
+**/
+func permissionsInclude(perms int64, permission int64) bool {
+	return perms|permission == perms || perms|discordgo.PermissionAdministrator == perms
 }
 
 /**
@@ -81,6 +87,55 @@ func attemptRename(s *discordgo.Session, m *discordgo.MessageCreate, command []s
 	}
 }
 
+/**
+Returns the name of the guild, or a placeholder if it could not be retrieved.
+**/
+func getGuildName(s *discordgo.Session, guildID string) string {
+	guild, err := s.Guild(guildID)
+	if err != nil {
+		logError("Unable to load guild! " + err.Error())
+		return "error: could not retrieve"
+	}
+	return guild.Name
+}
+
+/**
+DMs the user that they are being kicked (and why, if a reason is given), then kicks them.
+Used by ~kick as well as any automatic moderation that needs to remove a member.
+**/
+func kickMember(s *discordgo.Session, guildID string, userID string, moderator string, reason string) error {
+	guildName := getGuildName(s, guildID)
+	if reason != "" {
+		dmUser(s, userID, fmt.Sprintf("You have been kicked from **%s** by %s because: %s\n", guildName, moderator, reason))
+		return s.GuildMemberDeleteWithReason(guildID, userID, reason)
+	}
+	dmUser(s, userID, fmt.Sprintf("You have been kicked from **%s** by %s.\n", guildName, moderator))
+	return s.GuildMemberDelete(guildID, userID)
+}
+
+/**
+Bans the user, then DMs them that they were banned (and why, if a reason is given).
+Used by ~ban as well as any automatic moderation that needs to remove a member.
+**/
+func banMember(s *discordgo.Session, guildID string, userID string, moderator string, reason string) error {
+	var err error
+	if reason != "" {
+		err = s.GuildBanCreateWithReason(guildID, userID, reason, 0)
+	} else {
+		err = s.GuildBanCreate(guildID, userID, 0)
+	}
+	if err != nil {
+		return err
+	}
+	guildName := getGuildName(s, guildID)
+	if reason != "" {
+		dmUser(s, userID, fmt.Sprintf("You have been banned from **%s** by %s because: %s\n", guildName, moderator, reason))
+	} else {
+		dmUser(s, userID, fmt.Sprintf("You have been banned from **%s** by %s.\n", guildName, moderator))
+	}
+	return nil
+}
+
 /**
 A helper function for Handle_kick. Ensures the user targeted a user using @; if they did,
 attempt to kick the specified user.
@@ -91,62 +146,26 @@ func attemptKick(s *discordgo.Session, m *discordgo.MessageCreate, command []str
 	if len(command) >= 2 {
 		if regex.MatchString(command[1]) {
 			userID := stripUserID(command[1])
-			if len(command) > 2 {
-				reason := strings.Join(command[2:], " ")
-				// dm user why they were kicked
-				guild, err := s.Guild(m.GuildID)
-				if err != nil {
-					logError("Unable to load guild! " + err.Error())
-				}
-				guildName := "error: could not retrieve"
-				if guild != nil {
-					guildName = guild.Name
-				}
-				dmUser(s, userID, fmt.Sprintf("You have been kicked from **%s** by %s#%s because: %s\n", guildName, m.Author.Username, m.Author.Discriminator, reason))
-				// kick with reason
-				err = s.GuildMemberDeleteWithReason(m.GuildID, userID, reason)
+			reason := strings.Join(command[2:], " ")
+			err := kickMember(s, m.GuildID, userID, m.Author.Username+"#"+m.Author.Discriminator, reason)
+			if err != nil {
+				logError("Failed to kick user! " + err.Error())
+				_, err = s.ChannelMessageSend(m.ChannelID, "Failed to kick the user.")
 				if err != nil {
-					logError("Failed to kick user! " + err.Error())
-					_, err = s.ChannelMessageSend(m.ChannelID, "Failed to kick the user.")
-					if err != nil {
-						logWarning("Failed to send failure message! " + err.Error())
-					}
-					return
+					logWarning("Failed to send failure message! " + err.Error())
 				}
+				return
+			}
+			if reason != "" {
 				_, err = s.ChannelMessageSend(m.ChannelID, ":wave: Kicked "+command[1]+" for the following reason: '"+reason+"'.")
-				if err != nil {
-					logWarning("Failed to send success message! " + err.Error())
-					return
-				}
-				logSuccess("Kicked user with reason")
 			} else {
-				// dm user they were kicked
-				guild, err := s.Guild(m.GuildID)
-				if err != nil {
-					logError("Unable to load guild! " + err.Error())
-				}
-				guildName := "error: could not retrieve"
-				if guild != nil {
-					guildName = guild.Name
-				}
-				dmUser(s, userID, fmt.Sprintf("You have been kicked from **%s** by %s#%s.\n", guildName, m.Author.Username, m.Author.Discriminator))
-				// kick without reason
-				err = s.GuildMemberDelete(m.GuildID, userID)
-				if err != nil {
-					logError("Failed to kick user! " + err.Error())
-					_, err = s.ChannelMessageSend(m.ChannelID, "Failed to kick the user.")
-					if err != nil {
-						logWarning("Failed to send failure message! " + err.Error())
-					}
-					return
-				}
 				_, err = s.ChannelMessageSend(m.ChannelID, ":wave: Kicked "+command[1]+".")
-				if err != nil {
-					logWarning("Failed to send success message! " + err.Error())
-					return
-				}
-				logSuccess("Kicked user")
 			}
+			if err != nil {
+				logWarning("Failed to send success message! " + err.Error())
+				return
+			}
+			logSuccess("Kicked user")
 			return
 		}
 	}
@@ -166,63 +185,26 @@ func attemptBan(s *discordgo.Session, m *discordgo.MessageCreate, command []stri
 	if len(command) >= 2 {
 		if regex.MatchString(command[1]) {
 			userID := stripUserID(command[1])
-			if len(command) > 2 {
-				reason := strings.Join(command[2:], " ")
-				// ban with reason
-				err := s.GuildBanCreateWithReason(m.GuildID, userID, reason, 0)
-				if err != nil {
-					logError("Failed to ban user! " + err.Error())
-					_, err = s.ChannelMessageSend(m.ChannelID, "Failed to ban the user.")
-					if err != nil {
-						logWarning("Failed to send failure message! " + err.Error())
-					}
-					return
-				}
-				// dm user why they were banned
-				guild, err := s.Guild(m.GuildID)
-				if err != nil {
-					logError("Unable to load guild! " + err.Error())
-				}
-				guildName := "error: could not retrieve"
-				if guild != nil {
-					guildName = guild.Name
-				}
-				dmUser(s, userID, fmt.Sprintf("You have been banned from **%s** by %s#%s because: %s\n", guildName, m.Author.Username, m.Author.Discriminator, reason))
-
-				_, err = s.ChannelMessageSend(m.ChannelID, ":hammer: Banned "+command[1]+" for the following reason: '"+reason+"'.")
+			reason := strings.Join(command[2:], " ")
+			err := banMember(s, m.GuildID, userID, m.Author.Username+"#"+m.Author.Discriminator, reason)
+			if err != nil {
+				logError("Failed to ban user! " + err.Error())
+				_, err = s.ChannelMessageSend(m.ChannelID, "Failed to ban the user.")
 				if err != nil {
 					logWarning("Failed to send failure message! " + err.Error())
-					return
 				}
-				logSuccess("Banned user with reason without issue")
+				return
+			}
+			if reason != "" {
+				_, err = s.ChannelMessageSend(m.ChannelID, ":hammer: Banned "+command[1]+" for the following reason: '"+reason+"'.")
 			} else {
-				// ban without reason
-				err := s.GuildBanCreate(m.GuildID, userID, 0)
-				if err != nil {
-					logError("Failed to ban user! " + err.Error())
-					_, err = s.ChannelMessageSend(m.ChannelID, "Failed to ban the user.")
-					if err != nil {
-						logWarning("Failed to send failure message! " + err.Error())
-					}
-					return
-				}
-				// dm user they were banned
-				guild, err := s.Guild(m.GuildID)
-				if err != nil {
-					logError("Unable to load guild! " + err.Error())
-				}
-				guildName := "error: could not retrieve"
-				if guild != nil {
-					guildName = guild.Name
-				}
-				dmUser(s, userID, fmt.Sprintf("You have been banned from **%s** by %s#%s.\n", guildName, m.Author.Username, m.Author.Discriminator))
 				_, err = s.ChannelMessageSend(m.ChannelID, ":hammer: Banned "+command[1]+".")
-				if err != nil {
-					logWarning("Failed to send failure message! " + err.Error())
-					return
-				}
-				logSuccess("Banned user with reason without issue")
 			}
+			if err != nil {
+				logWarning("Failed to send success message! " + err.Error())
+				return
+			}
+			logSuccess("Banned user without issue")
 			return
 		}
 	}
@@ -232,231 +214,650 @@ func attemptBan(s *discordgo.Session, m *discordgo.MessageCreate, command []stri
 	}
 }
 
+// stop scanning a channel's history after this many messages
+const purgeScanLimit = 10000
+
+// matches http(s) links in message content
+var linkRegex = regexp.MustCompile(`https?:\/\/\S+`)
+
+// PurgeFilter : the conditions a message must meet to be removed by ~purge
+type PurgeFilter struct {
+	UserID      string
+	Bots        bool
+	Contains    string
+	Regex       *regexp.Regexp
+	Attachments bool
+	Links       bool
+	AfterID     string
+	BeforeID    string
+}
+
 /**
-Attempts to purge the last <number> messages, then removes the purge command.
+Parses `~purge <number> (filters)` into the number of messages to remove and the filters
+they must match. Flags that take a value consume every word up to the next flag.
+*/
+func parsePurgeCommand(command []string) (int, PurgeFilter, error) {
+	var filter PurgeFilter
+	if len(command) < 2 {
+		return 0, filter, fmt.Errorf("missing message count")
+	}
+	messageCount, err := strconv.Atoi(command[1])
+	if err != nil {
+		return 0, filter, fmt.Errorf("invalid message count '%s'", command[1])
+	}
+
+	for i := 2; i < len(command); i++ {
+		flag := command[i]
+		var values []string
+		for i+1 < len(command) && !strings.HasPrefix(command[i+1], "--") {
+			i++
+			values = append(values, command[i])
+		}
+		value := strings.Join(values, " ")
+
+		switch flag {
+		case "--bots":
+			filter.Bots = true
+		case "--attachments":
+			filter.Attachments = true
+		case "--links":
+			filter.Links = true
+		case "--user":
+			if !regexp.MustCompile(`^\<\@\!?[0-9]+\>$`).MatchString(value) {
+				return 0, filter, fmt.Errorf("--user needs an @user")
+			}
+			filter.UserID = stripUserID(value)
+		case "--contains":
+			if value == "" {
+				return 0, filter, fmt.Errorf("--contains needs some text")
+			}
+			filter.Contains = strings.ToLower(value)
+		case "--regex":
+			filter.Regex, err = regexp.Compile(value)
+			if err != nil || value == "" {
+				return 0, filter, fmt.Errorf("--regex needs a valid pattern")
+			}
+		case "--after", "--before":
+			_, _, messageID, ok := parseMessageLink(value)
+			if !ok {
+				return 0, filter, fmt.Errorf("%s needs a message link", flag)
+			}
+			if flag == "--after" {
+				filter.AfterID = messageID
+			} else {
+				filter.BeforeID = messageID
+			}
+		default:
+			return 0, filter, fmt.Errorf("unknown option '%s'", flag)
+		}
+		if (flag == "--bots" || flag == "--attachments" || flag == "--links") && value != "" {
+			return 0, filter, fmt.Errorf("%s does not take a value", flag)
+		}
+	}
+	return messageCount, filter, nil
+}
+
+/**
+Returns true if the message meets every condition in the filter.
+*/
+func (filter PurgeFilter) matches(message *discordgo.Message) bool {
+	if filter.UserID != "" && (message.Author == nil || message.Author.ID != filter.UserID) {
+		return false
+	}
+	if filter.Bots && (message.Author == nil || !message.Author.Bot) {
+		return false
+	}
+	if filter.Contains != "" && !strings.Contains(strings.ToLower(message.Content), filter.Contains) {
+		return false
+	}
+	if filter.Regex != nil && !filter.Regex.MatchString(message.Content) {
+		return false
+	}
+	if filter.Attachments && len(message.Attachments) == 0 {
+		return false
+	}
+	if filter.Links && !linkRegex.MatchString(message.Content) {
+		return false
+	}
+	return true
+}
+
+// purges currently running, keyed by channel ID; closing the channel cancels the purge
+var activePurges = map[string]chan struct{}{}
+var activePurgesLock sync.Mutex
+
+// Discord refuses to bulk delete messages older than two weeks; leave a little room for clock drift
+const bulkDeleteMaxAge = 14*24*time.Hour - time.Hour
+
+/**
+Splits messages into those young enough to be bulk deleted and those that must be deleted
+one at a time.
+*/
+func splitByBulkDeletable(messages []*discordgo.Message, now time.Time) ([]string, []string) {
+	var bulk []string
+	var old []string
+	for _, message := range messages {
+		created, err := discordgo.SnowflakeTimestamp(message.ID)
+		if err == nil && now.Sub(created) < bulkDeleteMaxAge {
+			bulk = append(bulk, message.ID)
+		} else {
+			old = append(old, message.ID)
+		}
+	}
+	return bulk, old
+}
+
+/**
+Returns true if the purge has been cancelled with ~purge cancel.
+*/
+func purgeCancelled(cancel chan struct{}) bool {
+	select {
+	case <-cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+/**
+Stops the purge running in the channel, if there is one.
+*/
+func cancelPurge(s *discordgo.Session, m *discordgo.MessageCreate) {
+	activePurgesLock.Lock()
+	cancel, running := activePurges[m.ChannelID]
+	if running {
+		close(cancel)
+		delete(activePurges, m.ChannelID)
+	}
+	activePurgesLock.Unlock()
+
+	response := "There is no purge running in this channel."
+	if running {
+		response = "Cancelling the purge in this channel..."
+	}
+	_, err := s.ChannelMessageSend(m.ChannelID, response)
+	if err != nil {
+		logError("Failed to send purge cancel message! " + err.Error())
+		return
+	}
+	logSuccess("Handled purge cancel")
+}
+
+/**
+Attempts to purge the last <number> messages matching the optional filters, then removes the
+purge command. A progress message is kept up to date while it runs, and ends with how many
+messages were looked at and removed.
 */
 func attemptPurge(s *discordgo.Session, m *discordgo.MessageCreate, command []string) {
 	logInfo(strings.Join(command, " "))
-	if len(command) == 2 {
-		messageCount, err := strconv.Atoi(command[1])
+	if len(command) == 2 && command[1] == "cancel" {
+		cancelPurge(s, m)
+		return
+	}
+	messageCount, filter, err := parsePurgeCommand(command)
+	if err != nil {
+		logInfo("Invalid purge command: " + err.Error())
+		_, err = s.ChannelMessageSend(m.ChannelID, "Usage: `~purge <number> (optional: --user @user, --bots, --contains <text>, --regex <pattern>, --attachments, --links, --after <message link>, --before <message link>)` or `~purge cancel`")
 		if err != nil {
-			_, err = s.ChannelMessageSend(m.ChannelID, "Usage: `~purge <number> (optional: @user)`")
-			if err != nil {
-				logError("Failed to send usage message! " + err.Error())
-			}
+			logError("Failed to send usage message! " + err.Error())
+		}
+		return
+	}
+	if messageCount < 1 {
+		logWarning("User attempted to purge < 1 message.")
+		_, err := s.ChannelMessageSend(m.ChannelID, ":frowning: Sorry, you must purge at least 1 message. Try again.")
+		if err != nil {
+			logError("Failed to send error message! " + err.Error())
+		}
+		return
+	}
+
+	// only allow one purge per channel at a time
+	activePurgesLock.Lock()
+	if _, running := activePurges[m.ChannelID]; running {
+		activePurgesLock.Unlock()
+		_, err := s.ChannelMessageSend(m.ChannelID, "A purge is already running in this channel. Use `~purge cancel` to stop it.")
+		if err != nil {
+			logError("Failed to send error message! " + err.Error())
+		}
+		return
+	}
+	cancel := make(chan struct{})
+	activePurges[m.ChannelID] = cancel
+	activePurgesLock.Unlock()
+	defer func() {
+		activePurgesLock.Lock()
+		if activePurges[m.ChannelID] == cancel {
+			delete(activePurges, m.ChannelID)
+		}
+		activePurgesLock.Unlock()
+	}()
+
+	scanned := 0
+	deleted := 0
+	failed := 0
+	progress, err := s.ChannelMessageSend(m.ChannelID, ":broom: Starting purge...")
+	if err != nil {
+		logError("Failed to send progress message! " + err.Error())
+		return
+	}
+	lastUpdate := time.Now()
+	updateProgress := func(status string, force bool) {
+		// editing the message on every deletion would burn through the rate limit
+		if !force && time.Since(lastUpdate) < 2*time.Second {
 			return
 		}
-		if messageCount < 1 {
-			logWarning("User attempted to purge < 1 message.")
-			_, err := s.ChannelMessageSend(m.ChannelID, ":frowning: Sorry, you must purge at least 1 message. Try again.")
-			if err != nil {
-				logError("Failed to send error message! " + err.Error())
-			}
+		lastUpdate = time.Now()
+		_, err := s.ChannelMessageEdit(m.ChannelID, progress.ID, fmt.Sprintf(":broom: %s Scanned %d messages and deleted %d of %d.", status, scanned, deleted, messageCount))
+		if err != nil {
+			logWarning("Failed to update progress message. " + err.Error())
+		}
+	}
+
+	// page backwards through the channel until enough matching messages are found
+	var matched []*discordgo.Message
+	before := m.ID
+	if filter.BeforeID != "" {
+		before = filter.BeforeID
+	}
+	scanning := true
+	for scanning && len(matched) < messageCount && scanned < purgeScanLimit && !purgeCancelled(cancel) {
+		messages, err := s.ChannelMessages(m.ChannelID, 100, before, "", "")
+		if err != nil {
+			logError("Failed to pull messages from channel! " + err.Error())
+			updateProgress("I couldn't pull messages from the channel, so I stopped early.", true)
 			return
 		}
-		for messageCount > 0 {
-			messagesToPurge := 0
-			// can only purge 100 messages per invocation
-			if messageCount > 100 {
-				messagesToPurge = 100
-			} else {
-				messagesToPurge = messageCount
+		if len(messages) == 0 {
+			break
+		}
+		for _, message := range messages {
+			if filter.AfterID != "" && !snowflakeBefore(filter.AfterID, message.ID) {
+				scanning = false
+				break
 			}
-
-			// get the last (messagesToPurge) messages from the channel
-			messages, err := s.ChannelMessages(m.ChannelID, messagesToPurge, m.ID, "", "")
-			if err != nil {
-				logError("Failed to pull messages from channel! " + err.Error())
-				_, err = s.ChannelMessageSend(m.ChannelID, ":frowning: I couldn't pull messages from the channel. Try again.")
-				if err != nil {
-					logError("Failed to send error message! " + err.Error())
-					return
+			// don't delete the progress message out from under ourselves
+			if message.ID == progress.ID {
+				continue
+			}
+			scanned++
+			if filter.matches(message) {
+				matched = append(matched, message)
+				if len(matched) == messageCount {
+					break
 				}
-				return
 			}
+		}
+		before = messages[len(messages)-1].ID
+		updateProgress("Scanning...", false)
+	}
 
-			// stop purging if there is nothing left to purge
-			if len(messages) < messagesToPurge {
-				messageCount = 0
-			}
+	bulk, old := splitByBulkDeletable(matched, time.Now())
 
-			// get the message IDs
-			var messageIDs []string
-			for _, message := range messages {
-				messageIDs = append(messageIDs, message.ID)
-			}
+	// delete recent messages 100 at a time
+	for len(bulk) > 0 && !purgeCancelled(cancel) {
+		batchSize := 100
+		if len(bulk) < batchSize {
+			batchSize = len(bulk)
+		}
+		batch := bulk[:batchSize]
+		bulk = bulk[batchSize:]
 
-			// delete all the marked messages
-			err = s.ChannelMessagesBulkDelete(m.ChannelID, messageIDs)
-			if err != nil {
-				logWarning("Failed to bulk delete messages! Attempting to continue... " + err.Error())
+		if len(batch) == 1 {
+			err = s.ChannelMessageDelete(m.ChannelID, batch[0])
+		} else {
+			err = s.ChannelMessagesBulkDelete(m.ChannelID, batch)
+		}
+		if err != nil {
+			logWarning("Failed to bulk delete messages! Attempting to continue... " + err.Error())
+			failed += len(batch)
+			continue
+		}
+		deleted += len(batch)
+		updateProgress("Deleting...", false)
+	}
+
+	// messages older than two weeks have to go one at a time; discordgo waits out any rate limits
+	for _, messageID := range old {
+		if purgeCancelled(cancel) {
+			break
+		}
+		err = s.ChannelMessageDelete(m.ChannelID, messageID)
+		if err != nil {
+			logWarning("Failed to delete old message! Attempting to continue... " + err.Error())
+			failed++
+			continue
+		}
+		deleted++
+		updateProgress("Deleting messages older than two weeks...", false)
+	}
+
+	status := "Done!"
+	if purgeCancelled(cancel) {
+		status = "Cancelled."
+	} else if failed > 0 {
+		status = fmt.Sprintf("Done, but %d messages could not be deleted.", failed)
+	}
+	updateProgress(status, true)
+
+	time.Sleep(time.Second)
+	err = s.ChannelMessageDelete(m.ChannelID, m.ID)
+	if err != nil {
+		logError("Failed to delete invoked command! " + err.Error())
+		return
+	}
+	logSuccess("Purged matching messages, including command invoked")
+}
+
+// the most messages a single ~mv or ~cp will copy
+const copyLimit = 1000
+
+// CopyRequest : what ~mv or ~cp was asked to copy, and where to put it
+type CopyRequest struct {
+	Count          int
+	FromLink       string
+	ToLink         string
+	ChannelID      string
+	NewChannelName string
+}
+
+/**
+Parses `~mv/~cp <number> <#channel>` and `~mv/~cp --from <link> --to <link> <#channel>`, where
+<#channel> may be replaced with `--thread <name>` to copy into a new channel.
+*/
+func parseCopyCommand(command []string) (CopyRequest, error) {
+	var request CopyRequest
+	for i := 1; i < len(command); i++ {
+		switch command[i] {
+		case "--from", "--to", "--thread":
+			if i+1 >= len(command) {
+				return request, fmt.Errorf("%s needs a value", command[i])
+			}
+			if command[i] == "--from" {
+				request.FromLink = command[i+1]
+			} else if command[i] == "--to" {
+				request.ToLink = command[i+1]
+			} else {
+				// channel names can't contain spaces, so the rest of the command is the name
+				request.NewChannelName = strings.ToLower(strings.Join(command[i+1:], "-"))
+				i = len(command)
+			}
+			i++
+		default:
+			if strings.HasPrefix(command[i], "<#") && strings.HasSuffix(command[i], ">") {
+				request.ChannelID = strings.TrimSuffix(strings.TrimPrefix(command[i], "<#"), ">")
+			} else if count, err := strconv.Atoi(command[i]); err == nil && request.Count == 0 {
+				request.Count = count
+			} else {
+				return request, fmt.Errorf("unexpected argument '%s'", command[i])
 			}
-			messageCount -= messagesToPurge
 		}
-		time.Sleep(time.Second)
-		err = s.ChannelMessageDelete(m.ChannelID, m.ID)
+	}
+
+	if (request.ChannelID == "") == (request.NewChannelName == "") {
+		return request, fmt.Errorf("exactly one destination is needed")
+	}
+	if request.FromLink != "" {
+		if request.Count != 0 {
+			return request, fmt.Errorf("a count can't be used with --from")
+		}
+		if _, _, _, ok := parseMessageLink(request.FromLink); !ok {
+			return request, fmt.Errorf("--from needs a message link")
+		}
+		if _, _, _, ok := parseMessageLink(request.ToLink); !ok && request.ToLink != "" {
+			return request, fmt.Errorf("--to needs a message link")
+		}
+	} else if request.ToLink != "" {
+		return request, fmt.Errorf("--to needs --from")
+	} else if request.Count < 1 || request.Count > copyLimit {
+		return request, fmt.Errorf("the count must be between 1 and %d", copyLimit)
+	}
+	return request, nil
+}
+
+/**
+Returns up to <count> messages sent before the given message, oldest first.
+*/
+func fetchMessagesBefore(s *discordgo.Session, channelID string, beforeID string, count int) ([]*discordgo.Message, error) {
+	var messages []*discordgo.Message
+	for len(messages) < count {
+		limit := count - len(messages)
+		if limit > 100 {
+			limit = 100
+		}
+		page, err := s.ChannelMessages(channelID, limit, beforeID, "", "")
 		if err != nil {
-			logError("Failed to delete invoked command! " + err.Error())
-			return
+			return nil, err
 		}
-		logSuccess("Purged all messages, including command invoked")
-	} else {
-		_, err := s.ChannelMessageSend(m.ChannelID, "Usage: `~purge <number>`")
+		messages = append(messages, page...)
+		if len(page) < limit {
+			break
+		}
+		beforeID = page[len(page)-1].ID
+	}
+
+	// pages come newest first
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, nil
+}
+
+/**
+Returns the messages from fromID to toID (both included), oldest first, stopping at limit.
+If toID is empty, everything after fromID is returned.
+*/
+func fetchMessageRange(s *discordgo.Session, channelID string, fromID string, toID string, limit int) ([]*discordgo.Message, error) {
+	first, err := s.ChannelMessage(channelID, fromID)
+	if err != nil {
+		return nil, err
+	}
+	messages := []*discordgo.Message{first}
+	afterID := fromID
+	for len(messages) < limit && (toID == "" || snowflakeBefore(afterID, toID)) {
+		page, err := s.ChannelMessages(channelID, 100, "", afterID, "")
 		if err != nil {
-			logError("Failed to send usage message! " + err.Error())
+			return nil, err
+		}
+		if len(page) == 0 {
+			break
 		}
+		sort.Slice(page, func(i, j int) bool {
+			return snowflakeBefore(page[i].ID, page[j].ID)
+		})
+		for _, message := range page {
+			if (toID != "" && snowflakeBefore(toID, message.ID)) || len(messages) >= limit {
+				return messages, nil
+			}
+			messages = append(messages, message)
+		}
+		afterID = page[len(page)-1].ID
+	}
+	return messages, nil
+}
+
+/**
+Returns why someone with the given permissions in the source channel can't copy (or move) its
+messages, or "" if they can.
+*/
+func copySourcePermissionError(perms int64, move bool) string {
+	if !permissionsInclude(perms, discordgo.PermissionViewChannel|discordgo.PermissionReadMessageHistory) {
+		return "You need to be able to view and read the history of the source channel."
+	}
+	if move && !permissionsInclude(perms, discordgo.PermissionManageMessages) {
+		return "You need the `Manage Messages` permission in the source channel to move its messages."
 	}
+	return ""
 }
 
 /**
-Attempts to copy over the last <number> messages to the given channel, then outputs its success
+Attempts to copy a set of messages to the given channel, then outputs its success. The messages
+are either the last <number> messages or a range given by message links, and are re-posted
+through a webhook so they keep their author's name and avatar.
 */
 func attemptCopy(s *discordgo.Session, m *discordgo.MessageCreate, command []string, preserveMessages bool) {
 	logInfo(strings.Join(command, " "))
 	var commandInvoked string
+	var verb string
 	if preserveMessages {
 		commandInvoked = "cp"
+		verb = "Copied"
 	} else {
 		commandInvoked = "mv"
+		verb = "Moved"
 	}
-	if len(command) == 3 {
-		messageCount, err := strconv.Atoi(command[1])
+	request, err := parseCopyCommand(command)
+	if err != nil {
+		logInfo("Invalid copy command: " + err.Error())
+		_, err = s.ChannelMessageSend(m.ChannelID, "Usage: `~"+commandInvoked+" <number> <#channel>` or `~"+commandInvoked+" --from <message link> --to <message link> <#channel>` (use `--thread <name>` instead of <#channel> to create a new channel)")
 		if err != nil {
-			_, err = s.ChannelMessageSend(m.ChannelID, "Usage: `~"+commandInvoked+" <number <= 100> <#channel>`")
+			logError("Failed to send usage message! " + err.Error())
+		}
+		return
+	}
+
+	if request.NewChannelName != "" && !userHasValidPermissions(s, m, discordgo.PermissionManageChannels) {
+		_, err = s.ChannelMessageSend(m.ChannelID, "Sorry, you need the `Manage Channels` permission to use `--thread`.")
+		if err != nil {
+			logError("Failed to send permissions message! " + err.Error())
+		}
+		return
+	}
+
+	// retrieve the messages to copy
+	sourceChannel := m.ChannelID
+	var messages []*discordgo.Message
+	truncated := false
+	if request.FromLink != "" {
+		guildID, channelID, fromID, _ := parseMessageLink(request.FromLink)
+		toID := ""
+		if request.ToLink != "" {
+			var toChannel string
+			_, toChannel, toID, _ = parseMessageLink(request.ToLink)
+			if toChannel != channelID {
+				guildID = ""
+			}
+		} else if channelID == m.ChannelID {
+			// don't copy the command itself
+			toID = m.ID
+		}
+		if guildID != m.GuildID {
+			_, err = s.ChannelMessageSend(m.ChannelID, "Both message links must be from the same channel in this server.")
 			if err != nil {
-				logError("Failed to send usage message! " + err.Error())
+				logError("Failed to send error message! " + err.Error())
 			}
 			return
 		}
-
-		// verify correctly invoking channel
-		if !strings.HasPrefix(command[2], "<#") || !strings.HasSuffix(command[2], ">") {
-			_, err := s.ChannelMessageSend(m.ChannelID, "Usage: `~"+commandInvoked+" <number <= 100> <#channel>`")
+		sourceChannel = channelID
+		// the links can point anywhere in the server, so check the caller can see that channel
+		perms, permErr := s.UserChannelPermissions(m.Author.ID, sourceChannel)
+		reason := "I couldn't check your permissions in that channel."
+		if permErr == nil {
+			reason = copySourcePermissionError(perms, !preserveMessages)
+		}
+		if reason != "" {
+			logWarning("User attempted to copy from a channel without proper permissions")
+			_, err = s.ChannelMessageSend(m.ChannelID, reason)
 			if err != nil {
-				logError("Failed to send usage message! " + err.Error())
+				logError("Failed to send permissions message! " + err.Error())
 			}
 			return
 		}
-		channel := strings.ReplaceAll(command[2], "<#", "")
-		channel = strings.ReplaceAll(channel, ">", "")
+		// one past the limit so a longer range can be reported as truncated
+		messages, err = fetchMessageRange(s, sourceChannel, fromID, toID, copyLimit+1)
+		if toID == m.ID && len(messages) > 0 && messages[len(messages)-1].ID == m.ID {
+			messages = messages[:len(messages)-1]
+		}
+		if len(messages) > copyLimit {
+			messages = messages[:copyLimit]
+			truncated = true
+		}
+	} else {
+		messages, err = fetchMessagesBefore(s, sourceChannel, m.ID, request.Count)
+	}
+	if err != nil {
+		logError("Failed to retrieve messages! " + err.Error())
+		_, err = s.ChannelMessageSend(m.ChannelID, "Ran into an error retrieving messages. :slight_frown:")
+		if err != nil {
+			logError("Failed to send error message! " + err.Error())
+		}
+		return
+	}
 
-		// retrieve messages from current invoked channel
-		messages, err := s.ChannelMessages(m.ChannelID, messageCount, m.ID, "", "")
+	// create the new channel next to the source channel if one was requested
+	channel := request.ChannelID
+	if request.NewChannelName != "" {
+		parentID := ""
+		source, err := s.Channel(sourceChannel)
+		if err == nil {
+			parentID = source.ParentID
+		}
+		newChannel, err := s.GuildChannelCreateComplex(m.GuildID, discordgo.GuildChannelCreateData{
+			Name:     request.NewChannelName,
+			Type:     discordgo.ChannelTypeGuildText,
+			ParentID: parentID,
+		})
 		if err != nil {
-			_, err = s.ChannelMessageSend(m.ChannelID, "Ran into an error retrieving messages. :slight_frown:")
+			logError("Failed to create new channel! " + err.Error())
+			_, err = s.ChannelMessageSend(m.ChannelID, "I couldn't create a channel with that name. :slight_frown:")
 			if err != nil {
 				logError("Failed to send error message! " + err.Error())
 			}
 			return
 		}
+		channel = newChannel.ID
+	}
 
-		// construct an embed for each message
-		for index := range messages {
-			var embed discordgo.MessageEmbed
-			embed.Type = "rich"
-			message := messages[len(messages)-1-index]
-
-			// remove messages if calling mv command
-			if !preserveMessages {
-				err := s.ChannelMessageDelete(m.ChannelID, message.ID)
-				if err != nil {
-					logWarning("Failed to delete a message. Attempting to continue... " + err.Error())
-				}
-			}
-
-			// populating author information in the embed
-			var embedAuthor discordgo.MessageEmbedAuthor
-			if message.Author != nil {
-				member, err := s.GuildMember(m.GuildID, message.Author.ID)
-				nickname := ""
-				if err == nil {
-					nickname = member.Nick
-				} else {
-					logWarning("Could not find a nickname for the user! " + err.Error())
-				}
-				embedAuthor.Name = ""
-				if nickname != "" {
-					embedAuthor.Name += nickname + " ("
-				}
-				embedAuthor.Name += message.Author.Username + "#" + message.Author.Discriminator
-				if nickname != "" {
-					embedAuthor.Name += ")"
-				}
-				embedAuthor.IconURL = message.Author.AvatarURL("")
-			}
-			embed.Author = &embedAuthor
-
-			// preserve message timestamp
-			embed.Timestamp = string(message.Timestamp)
-			var contents []*discordgo.MessageEmbedField
-
-			// output message text
-			logInfo("Message Content: " + message.Content)
-			if message.Content != "" {
-				embed.Description = message.Content
-			}
-
-			// output attachments
-			logInfo(fmt.Sprintf("Attachments: %d\n", len(message.Attachments)))
-			if len(message.Attachments) > 0 {
-				for _, attachment := range message.Attachments {
-					contents = append(contents, createField("Attachment: "+attachment.Filename, attachment.ProxyURL, false))
-				}
-			}
-
-			// output embed contents (up to 10... jesus christ...)
-			logInfo(fmt.Sprintf("Embeds: %d\n", len(message.Embeds)))
-			if len(message.Embeds) > 0 {
-				for _, embed := range message.Embeds {
-					contents = append(contents, createField("Embed Title", embed.Title, false))
-					contents = append(contents, createField("Embed Text", embed.Description, false))
-					if embed.Image != nil {
-						contents = append(contents, createField("Embed Image", embed.Image.ProxyURL, false))
-					}
-					if embed.Thumbnail != nil {
-						contents = append(contents, createField("Embed Thumbnail", embed.Thumbnail.ProxyURL, false))
-					}
-					if embed.Video != nil {
-						contents = append(contents, createField("Embed Video", embed.Video.URL, false))
-					}
-					if embed.Footer != nil {
-						contents = append(contents, createField("Embed Footer", embed.Footer.Text, false))
-					}
-				}
-			}
+	webhook, err := getChannelWebhook(s, channel)
+	if err != nil {
+		logError("Failed to get a webhook for the channel! " + err.Error())
+		_, err = s.ChannelMessageSend(m.ChannelID, "I need the `Manage Webhooks` permission in <#"+channel+"> to do that. :slight_frown:")
+		if err != nil {
+			logError("Failed to send error message! " + err.Error())
+		}
+		return
+	}
 
-			// ouput reactions on a message
-			if len(message.Reactions) > 0 {
-				reactionText := ""
-				for index, reactionSet := range message.Reactions {
-					reactionText += reactionSet.Emoji.Name + " x" + strconv.Itoa(reactionSet.Count)
-					if index < len(message.Reactions)-1 {
-						reactionText += ", "
-					}
-				}
-				contents = append(contents, createField("Reactions", reactionText, false))
-			}
-			embed.Fields = contents
+	// re-post each message, oldest first
+	copied := 0
+	firstLink := ""
+	nicknames := map[string]string{}
+	for _, message := range messages {
+		reposted, err := repostMessage(s, webhook, m.GuildID, message, nicknames)
+		if err != nil {
+			logWarning("Failed to copy a message. Attempting to continue... " + err.Error())
+			continue
+		}
+		copied++
+		if firstLink == "" && reposted != nil {
+			firstLink = fmt.Sprintf("https://discord.com/channels/%s/%s/%s", m.GuildID, channel, reposted.ID)
+		}
 
-			// send response
-			_, err := s.ChannelMessageSendEmbed(channel, &embed)
+		// remove messages if calling mv command, but only once they have been copied
+		if !preserveMessages {
+			err := s.ChannelMessageDelete(sourceChannel, message.ID)
 			if err != nil {
-				logError("Failed to send result message! " + err.Error())
-				return
+				logWarning("Failed to delete a message. Attempting to continue... " + err.Error())
 			}
 		}
-		_, err = s.ChannelMessageSend(m.ChannelID, "Copied "+strconv.Itoa(messageCount)+" messages from <#"+m.ChannelID+"> to <#"+channel+">! :smile:")
-		if err != nil {
-			logError("Failed to send success message! " + err.Error())
-			return
-		}
-		logSuccess("Copied messages and sent success message")
-	} else {
-		_, err := s.ChannelMessageSend(m.ChannelID, "Usage: `~"+commandInvoked+" <number <= 100> <#channel>`")
-		if err != nil {
-			logError("Failed to send usage message! " + err.Error())
-		}
 	}
+
+	summary := fmt.Sprintf("%s %d messages from <#%s> to <#%s>! :smile:", verb, copied, sourceChannel, channel)
+	if copied < len(messages) {
+		summary += fmt.Sprintf("\n%d messages could not be copied.", len(messages)-copied)
+	}
+	if truncated {
+		summary += fmt.Sprintf("\nThe range was longer than %d messages, so only the first %d were %s.", copyLimit, copyLimit, strings.ToLower(verb))
+	}
+	if firstLink != "" {
+		summary += "\nJump to the first message: " + firstLink
+	}
+	_, err = s.ChannelMessageSend(m.ChannelID, summary)
+	if err != nil {
+		logError("Failed to send success message! " + err.Error())
+		return
+	}
+	logSuccess("Copied messages and sent success message")
 }
 
 /**
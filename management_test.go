@@ -121,28 +121,28 @@ func TestMessageResponse(t *testing.T) {
 
 		dg.ChannelMessageSend(testingChannel, "~cp 3")
 		time.Sleep(timeBetweenCommands) // allow response to populate
-		if response != "Usage: `~cp <number <= 100> <#channel>`" {
+		if response != "Usage: `~cp <number> <#channel>` or `~cp --from <message link> --to <message link> <#channel>` (use `--thread <name>` instead of <#channel> to create a new channel)" {
 			t.Logf("Should have reported incorrect usage, but didn't")
 			t.Fail()
 		}
 
 		dg.ChannelMessageSend(testingChannel, "~cp 3 fakechannel")
 		time.Sleep(timeBetweenCommands) // allow response to populate
-		if response != "Usage: `~cp <number <= 100> <#channel>`" {
+		if response != "Usage: `~cp <number> <#channel>` or `~cp --from <message link> --to <message link> <#channel>` (use `--thread <name>` instead of <#channel> to create a new channel)" {
 			t.Logf("Should have reported incorrect usage, but didn't")
 			t.Fail()
 		}
 
 		dg.ChannelMessageSend(testingChannel, "~mv 3")
 		time.Sleep(timeBetweenCommands) // allow response to populate
-		if response != "Usage: `~mv <number <= 100> <#channel>`" {
+		if response != "Usage: `~mv <number> <#channel>` or `~mv --from <message link> --to <message link> <#channel>` (use `--thread <name>` instead of <#channel> to create a new channel)" {
 			t.Logf("Should have reported incorrect usage, but didn't")
 			t.Fail()
 		}
 
 		dg.ChannelMessageSend(testingChannel, "~mv 3 fakechannel")
 		time.Sleep(timeBetweenCommands) // allow response to populate
-		if response != "Usage: `~mv <number <= 100> <#channel>`" {
+		if response != "Usage: `~mv <number> <#channel>` or `~mv --from <message link> --to <message link> <#channel>` (use `--thread <name>` instead of <#channel> to create a new channel)" {
 			t.Logf("Should have reported incorrect usage, but didn't")
 			t.Fail()
 		}
@@ -160,3 +160,105 @@ func updateResponse(s *discordgo.Session, m *discordgo.MessageCreate) {
 	}
 	response = m.Content
 }
+
+func TestPurgeFilters(t *testing.T) {
+	t.Run("Purge flags are parsed", func(t *testing.T) {
+		command := strings.Split("~purge 200 --user <@!123456789012345678> --contains foo bar --links --after https://discord.com/channels/1/2/300", " ")
+		count, filter, err := parsePurgeCommand(command)
+		if err != nil {
+			t.Logf("Failed to parse purge command: " + err.Error())
+			t.FailNow()
+		}
+		if count != 200 || filter.UserID != "123456789012345678" || filter.Contains != "foo bar" || !filter.Links || filter.AfterID != "300" {
+			t.Logf("Parsed purge command incorrectly: %d %+v", count, filter)
+			t.Fail()
+		}
+	})
+
+	t.Run("Invalid purge flags are rejected", func(t *testing.T) {
+		for _, raw := range []string{"~purge", "~purge ten", "~purge 5 --user bob", "~purge 5 --regex (", "~purge 5 --bots yes", "~purge 5 --before nowhere", "~purge 5 --everything"} {
+			if _, _, err := parsePurgeCommand(strings.Split(raw, " ")); err == nil {
+				t.Logf("Expected '%s' to be rejected", raw)
+				t.Fail()
+			}
+		}
+	})
+
+	t.Run("Messages must match every filter", func(t *testing.T) {
+		_, filter, _ := parsePurgeCommand(strings.Split("~purge 5 --bots --regex ^buy", " "))
+		bot := &discordgo.User{ID: "1", Bot: true}
+		human := &discordgo.User{ID: "2"}
+		if !filter.matches(&discordgo.Message{Author: bot, Content: "buy now"}) {
+			t.Logf("Expected bot spam to match")
+			t.Fail()
+		}
+		if filter.matches(&discordgo.Message{Author: human, Content: "buy now"}) {
+			t.Logf("Expected human message not to match --bots")
+			t.Fail()
+		}
+		if filter.matches(&discordgo.Message{Author: bot, Content: "please buy"}) {
+			t.Logf("Expected message not to match --regex")
+			t.Fail()
+		}
+	})
+}
+
+func TestPurgeBatches(t *testing.T) {
+	now := time.Now()
+	messages := []*discordgo.Message{
+		{ID: snowflakeAt(now.Add(-time.Hour))},
+		{ID: snowflakeAt(now.AddDate(0, 0, -20))},
+		{ID: snowflakeAt(now.AddDate(0, 0, -3))},
+	}
+
+	t.Run("Messages older than two weeks are not bulk deleted", func(t *testing.T) {
+		bulk, old := splitByBulkDeletable(messages, now)
+		if len(bulk) != 2 || len(old) != 1 || old[0] != messages[1].ID {
+			t.Logf("Split messages incorrectly: bulk %v, old %v", bulk, old)
+			t.Fail()
+		}
+	})
+}
+
+func TestCopyCommand(t *testing.T) {
+	t.Run("Counts above 100 are accepted", func(t *testing.T) {
+		request, err := parseCopyCommand(strings.Split("~mv 250 <#739852388264968243>", " "))
+		if err != nil || request.Count != 250 || request.ChannelID != "739852388264968243" {
+			t.Logf("Parsed copy command incorrectly: %+v %v", request, err)
+			t.Fail()
+		}
+	})
+
+	t.Run("Ranges can be moved into a new channel", func(t *testing.T) {
+		request, err := parseCopyCommand(strings.Split("~mv --from https://discord.com/channels/1/2/3 --to https://discord.com/channels/1/2/9 --thread Incident Notes", " "))
+		if err != nil || request.FromLink == "" || request.ToLink == "" || request.NewChannelName != "incident-notes" {
+			t.Logf("Parsed copy command incorrectly: %+v %v", request, err)
+			t.Fail()
+		}
+	})
+
+	t.Run("Invalid copy commands are rejected", func(t *testing.T) {
+		for _, raw := range []string{"~cp 3", "~cp 3 fakechannel", "~cp 0 <#1>", "~cp 3 <#1> --thread new", "~cp --to https://discord.com/channels/1/2/3 <#1>", "~cp --from nowhere <#1>"} {
+			if _, err := parseCopyCommand(strings.Split(raw, " ")); err == nil {
+				t.Logf("Expected '%s' to be rejected", raw)
+				t.Fail()
+			}
+		}
+	})
+
+	t.Run("Copying needs access to the source channel", func(t *testing.T) {
+		readable := int64(discordgo.PermissionViewChannel | discordgo.PermissionReadMessageHistory)
+		if copySourcePermissionError(readable, false) != "" || copySourcePermissionError(discordgo.PermissionAdministrator, true) != "" {
+			t.Log("Expected readers to copy and administrators to move")
+			t.Fail()
+		}
+		if copySourcePermissionError(discordgo.PermissionViewChannel, false) == "" {
+			t.Log("Expected copying without Read Message History to be refused")
+			t.Fail()
+		}
+		if copySourcePermissionError(readable, true) == "" || copySourcePermissionError(readable|discordgo.PermissionManageMessages, true) != "" {
+			t.Log("Expected moving to need Manage Messages in the source channel")
+			t.Fail()
+		}
+	})
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// MemberSnapshot : what is remembered about a member so it can be logged after they leave.
+// discordgo removes members from its state before the leave handler runs.
+type MemberSnapshot struct {
+	JoinedAt time.Time
+	Roles    []string
+}
+
+// accounts younger than this many days are flagged unless the guild sets memberlog_new_account_days
+const defaultNewAccountDays = 7
+
+// how long after a leave an audit log entry can be and still be the reason for it
+const auditLogWindow = 30 * time.Second
+
+var memberSnapshots = map[string]map[string]MemberSnapshot{}
+var memberSnapshotsLock sync.Mutex
+
+/**
+Remembers the member's join date and roles.
+*/
+func snapshotMember(guildID string, member *discordgo.Member) {
+	if member == nil || member.User == nil {
+		return
+	}
+	snapshot := MemberSnapshot{Roles: member.Roles}
+	if joinedAt, err := member.JoinedAt.Parse(); err == nil {
+		snapshot.JoinedAt = joinedAt
+	}
+	memberSnapshotsLock.Lock()
+	if _, ok := memberSnapshots[guildID]; !ok {
+		memberSnapshots[guildID] = map[string]MemberSnapshot{}
+	}
+	memberSnapshots[guildID][member.User.ID] = snapshot
+	memberSnapshotsLock.Unlock()
+}
+
+/**
+Remembers every member the guild was sent with.
+*/
+func snapshotGuild(guild *discordgo.Guild) {
+	for _, member := range guild.Members {
+		snapshotMember(guild.ID, member)
+	}
+}
+
+/**
+Returns and forgets the member's snapshot.
+*/
+func popMemberSnapshot(guildID string, userID string) (MemberSnapshot, bool) {
+	memberSnapshotsLock.Lock()
+	defer memberSnapshotsLock.Unlock()
+	snapshot, ok := memberSnapshots[guildID][userID]
+	delete(memberSnapshots[guildID], userID)
+	return snapshot, ok
+}
+
+/**
+Formats a length of time using its two largest units, e.g. "2 years, 14 days" or "3 hours, 5 minutes".
+*/
+func formatAge(age time.Duration) string {
+	units := []struct {
+		name   string
+		length time.Duration
+	}{
+		{"year", 365 * 24 * time.Hour},
+		{"day", 24 * time.Hour},
+		{"hour", time.Hour},
+		{"minute", time.Minute},
+	}
+	var parts []string
+	for _, unit := range units {
+		if age < unit.length && len(parts) == 0 {
+			continue
+		}
+		amount := int(age / unit.length)
+		age -= time.Duration(amount) * unit.length
+		if amount == 1 {
+			parts = append(parts, "1 "+unit.name)
+		} else {
+			parts = append(parts, strconv.Itoa(amount)+" "+unit.name+"s")
+		}
+		if len(parts) == 2 {
+			break
+		}
+	}
+	if len(parts) == 0 {
+		return "less than a minute"
+	}
+	return strings.Join(parts, ", ")
+}
+
+/**
+Looks through audit log entries for a kick or ban of the user made just before now. Returns
+the entry, or nil if they left on their own.
+*/
+func findRemovalEntry(entries []*discordgo.AuditLogEntry, userID string, now time.Time) *discordgo.AuditLogEntry {
+	for _, entry := range entries {
+		if entry.TargetID != userID || entry.ActionType == nil {
+			continue
+		}
+		if *entry.ActionType != discordgo.AuditLogActionMemberKick && *entry.ActionType != discordgo.AuditLogActionMemberBanAdd {
+			continue
+		}
+		createdAt, err := discordgo.SnowflakeTimestamp(entry.ID)
+		if err == nil && now.Sub(createdAt) < auditLogWindow {
+			return entry
+		}
+	}
+	return nil
+}
+
+/**
+Posts the new member's account age, avatar and join position to the guild's member log channel.
+*/
+func logMemberJoin(s *discordgo.Session, m *discordgo.GuildMemberAdd) {
+	snapshotMember(m.GuildID, m.Member)
+	channelID := getGuildSetting(m.GuildID, "memberlog_channel")
+	if channelID == "" || m.User == nil {
+		return
+	}
+	now := time.Now()
+	created, err := discordgo.SnowflakeTimestamp(m.User.ID)
+	if err != nil {
+		logError("Unable to read account creation date! " + err.Error())
+		return
+	}
+	newAccountDays, err := strconv.Atoi(getGuildSetting(m.GuildID, "memberlog_new_account_days"))
+	if err != nil {
+		newAccountDays = defaultNewAccountDays
+	}
+
+	var embed discordgo.MessageEmbed
+	embed.Type = "rich"
+	embed.Title = ":inbox_tray: Member joined"
+	embed.Description = fmt.Sprintf("<@%s> %s#%s", m.User.ID, m.User.Username, m.User.Discriminator)
+	if now.Sub(created) < time.Duration(newAccountDays)*24*time.Hour {
+		embed.Description += fmt.Sprintf("\n:warning: **New account** (less than %d days old)", newAccountDays)
+	}
+	embed.Thumbnail = &discordgo.MessageEmbedThumbnail{URL: m.User.AvatarURL("")}
+	embed.Fields = []*discordgo.MessageEmbedField{
+		createField("Account created", created.UTC().Format("2006-01-02 15:04")+" UTC ("+formatAge(now.Sub(created))+" ago)", false),
+	}
+	if guild, err := s.State.Guild(m.GuildID); err == nil {
+		embed.Fields = append(embed.Fields, createField("Join position", "#"+strconv.Itoa(guild.MemberCount), true))
+	}
+	embed.Footer = &discordgo.MessageEmbedFooter{Text: "User ID: " + m.User.ID}
+	embed.Timestamp = now.Format(time.RFC3339)
+	_, err = s.ChannelMessageSendEmbed(channelID, &embed)
+	if err != nil {
+		logError("Failed to send member join log! " + err.Error())
+	}
+}
+
+/**
+Posts how long the member was in the server, the roles they held, and whether they were
+kicked or banned to the guild's member log channel.
+*/
+func logMemberLeave(s *discordgo.Session, m *discordgo.GuildMemberRemove) {
+	snapshot, known := popMemberSnapshot(m.GuildID, m.User.ID)
+	channelID := getGuildSetting(m.GuildID, "memberlog_channel")
+	if channelID == "" {
+		return
+	}
+
+	// the audit log entry can take a moment to appear
+	time.Sleep(2 * time.Second)
+	now := time.Now()
+	var removal *discordgo.AuditLogEntry
+	auditLog, err := s.GuildAuditLog(m.GuildID, "", "", 0, 10)
+	if err != nil {
+		logWarning("Unable to read the audit log. " + err.Error())
+	} else {
+		removal = findRemovalEntry(auditLog.AuditLogEntries, m.User.ID, now)
+	}
+
+	var embed discordgo.MessageEmbed
+	embed.Type = "rich"
+	embed.Title = ":outbox_tray: Member left"
+	embed.Description = fmt.Sprintf("<@%s> %s#%s", m.User.ID, m.User.Username, m.User.Discriminator)
+	if removal != nil {
+		action := "Kicked"
+		if *removal.ActionType == discordgo.AuditLogActionMemberBanAdd {
+			action = "Banned"
+		}
+		embed.Title = ":outbox_tray: Member " + strings.ToLower(action)
+		embed.Description += fmt.Sprintf("\n%s by <@%s>", action, removal.UserID)
+		if removal.Reason != "" {
+			embed.Description += ": " + removal.Reason
+		}
+	}
+	embed.Thumbnail = &discordgo.MessageEmbedThumbnail{URL: m.User.AvatarURL("")}
+
+	timeInServer := "Unknown"
+	roles := "Unknown"
+	if known {
+		if !snapshot.JoinedAt.IsZero() {
+			timeInServer = formatAge(now.Sub(snapshot.JoinedAt))
+		}
+		var mentions []string
+		for _, roleID := range snapshot.Roles {
+			mentions = append(mentions, "<@&"+roleID+">")
+		}
+		roles = "None"
+		if len(mentions) > 0 {
+			roles = truncateField(strings.Join(mentions, " "), 1000)
+		}
+	}
+	embed.Fields = []*discordgo.MessageEmbedField{
+		createField("Time in server", timeInServer, true),
+		createField("Roles", roles, false),
+	}
+	embed.Footer = &discordgo.MessageEmbedFooter{Text: "User ID: " + m.User.ID}
+	embed.Timestamp = now.Format(time.RFC3339)
+	_, err = s.ChannelMessageSendEmbed(channelID, &embed)
+	if err != nil {
+		logError("Failed to send member leave log! " + err.Error())
+	}
+}
+
+/****
+COMMANDS
+****/
+
+/**
+Shows or changes the channel where staff see joins and leaves, and the new account warning age.
+**/
+func handleMemberLog(s *discordgo.Session, m *discordgo.MessageCreate, command []string) {
+	logInfo(strings.Join(command, " "))
+	if !userHasValidPermissions(s, m, discordgo.PermissionManageServer) {
+		logWarning("User attempted to change the member log without proper permissions")
+		_, err := s.ChannelMessageSend(m.ChannelID, "Sorry, you don't have the `Manage Server` permission.")
+		if err != nil {
+			logError("Failed to send permissions message! " + err.Error())
+		}
+		return
+	}
+
+	if len(command) == 1 {
+		response := "This server has no member log channel."
+		if channelID := getGuildSetting(m.GuildID, "memberlog_channel"); channelID != "" {
+			response = "Joins and leaves are logged in <#" + channelID + ">."
+		}
+		days := getGuildSetting(m.GuildID, "memberlog_new_account_days")
+		if days == "" {
+			days = strconv.Itoa(defaultNewAccountDays)
+		}
+		response += " Accounts younger than " + days + " days are flagged."
+		_, err := s.ChannelMessageSend(m.ChannelID, response)
+		if err != nil {
+			logError("Failed to send member log status message! " + err.Error())
+		}
+		return
+	}
+
+	var saved bool
+	var response string
+	switch {
+	case len(command) == 2 && command[1] == "off":
+		saved = deleteGuildSetting(m.GuildID, "memberlog_channel")
+		response = "Joins and leaves will no longer be logged."
+	case len(command) == 2 && strings.HasPrefix(command[1], "<#") && strings.HasSuffix(command[1], ">"):
+		channelID := strings.TrimSuffix(strings.TrimPrefix(command[1], "<#"), ">")
+		saved = setGuildSetting(m.GuildID, "memberlog_channel", channelID)
+		response = "Joins and leaves will now be logged in <#" + channelID + ">."
+	case len(command) == 3 && command[1] == "newaccount":
+		days, err := strconv.Atoi(command[2])
+		if err != nil || days < 0 {
+			_, err = s.ChannelMessageSend(m.ChannelID, "Please input a valid number of days.")
+			if err != nil {
+				logError("Failed to send invalid days message! " + err.Error())
+			}
+			return
+		}
+		saved = setGuildSetting(m.GuildID, "memberlog_new_account_days", command[2])
+		response = "Accounts younger than " + command[2] + " days will now be flagged."
+	default:
+		_, err := s.ChannelMessageSend(m.ChannelID, "Usages: ```~memberlog\n~memberlog <#channel/off>\n~memberlog newaccount <days>```")
+		if err != nil {
+			logError("Failed to send usage message! " + err.Error())
+		}
+		return
+	}
+	if !saved {
+		response = "An error occurred. Please try again in a moment."
+	}
+	_, err := s.ChannelMessageSend(m.ChannelID, response)
+	if err != nil {
+		logError("Failed to send member log result message! " + err.Error())
+		return
+	}
+	logSuccess("Updated member log settings")
+}
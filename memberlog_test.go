@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+/**
+Test anything that uses calculation outside of the discordgo commands.
+Those commands don't need to be tested since they are verified to work
+at github.com/bwmarrin/discordgo
+**/
+func TestMemberLog(t *testing.T) {
+	t.Run("Ages use their two largest units", func(t *testing.T) {
+		cases := map[time.Duration]string{
+			30 * time.Second:                      "less than a minute",
+			61 * time.Minute:                      "1 hour, 1 minute",
+			(365*24+48)*time.Hour + 5*time.Minute: "1 year, 2 days",
+			3 * 24 * time.Hour:                    "3 days, 0 hours",
+		}
+		for age, expected := range cases {
+			if formatted := formatAge(age); formatted != expected {
+				t.Logf("Expected '%s' for %s, got '%s'", expected, age, formatted)
+				t.Fail()
+			}
+		}
+	})
+
+	t.Run("Recent kicks and bans explain a leave", func(t *testing.T) {
+		now := time.Now()
+		kick := discordgo.AuditLogActionMemberKick
+		rename := discordgo.AuditLogActionMemberUpdate
+		recentID := snowflakeAt(now.Add(-5 * time.Second))
+		oldID := snowflakeAt(now.Add(-time.Hour))
+		entries := []*discordgo.AuditLogEntry{
+			{ID: recentID, TargetID: "2", ActionType: &kick},
+			{ID: recentID, TargetID: "1", ActionType: &rename},
+			{ID: oldID, TargetID: "1", ActionType: &kick},
+		}
+		if findRemovalEntry(entries, "1", now) != nil {
+			t.Logf("Matched an unrelated or old entry")
+			t.Fail()
+		}
+		entries = append(entries, &discordgo.AuditLogEntry{ID: recentID, TargetID: "1", ActionType: &kick})
+		if findRemovalEntry(entries, "1", now) == nil {
+			t.Logf("Failed to find the kick")
+			t.Fail()
+		}
+	})
+}
@@ -0,0 +1,562 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// CachedMessage : what is remembered about a message so it can be logged after it is edited or deleted
+type CachedMessage struct {
+	ID          string
+	GuildID     string
+	ChannelID   string
+	AuthorID    string
+	Author      string
+	Content     string
+	Attachments []string
+	SentAt      time.Time
+}
+
+// SnipedEdit : a message's content before and after its latest edit
+type SnipedEdit struct {
+	Before   CachedMessage
+	After    string
+	EditedAt time.Time
+}
+
+// SnipedDelete : a deleted message and when it was deleted
+type SnipedDelete struct {
+	Message   CachedMessage
+	DeletedAt time.Time
+}
+
+// a fixed-size buffer of a channel's most recent messages; the oldest is overwritten first
+type messageRing struct {
+	messages []CachedMessage
+	next     int
+}
+
+// how many recent messages are kept in memory for each channel
+const messageRingSize = 200
+
+// how many days persisted messages are kept unless the guild sets messagelog_retention
+const defaultMessageRetentionDays = 7
+
+var messageRings = map[string]*messageRing{}
+var lastDeleted = map[string]SnipedDelete{}
+var lastEdited = map[string]SnipedEdit{}
+var messageCacheLock sync.Mutex
+
+// whether each guild saves its messages to the database, cached since every message checks it
+var messagePersistSettings = map[string]bool{}
+var messagePersistLock sync.Mutex
+
+/**
+Reports whether the guild saves its messages to the database, loading the setting if it isn't cached.
+*/
+func isMessagePersistOn(guildID string) bool {
+	messagePersistLock.Lock()
+	defer messagePersistLock.Unlock()
+	if on, ok := messagePersistSettings[guildID]; ok {
+		return on
+	}
+	on := getGuildSetting(guildID, "messagelog_persist") == "on"
+	messagePersistSettings[guildID] = on
+	return on
+}
+
+/**
+Saves whether the guild saves its messages to the database and updates the cache.
+*/
+func setMessagePersist(guildID string, value string) bool {
+	if !setGuildSetting(guildID, "messagelog_persist", value) {
+		return false
+	}
+	messagePersistLock.Lock()
+	messagePersistSettings[guildID] = value == "on"
+	messagePersistLock.Unlock()
+	return true
+}
+
+/**
+Adds the message to the ring, overwriting the oldest message once it is full.
+*/
+func (ring *messageRing) add(message CachedMessage) {
+	if len(ring.messages) < messageRingSize {
+		ring.messages = append(ring.messages, message)
+		return
+	}
+	ring.messages[ring.next] = message
+	ring.next = (ring.next + 1) % messageRingSize
+}
+
+/**
+Returns a pointer to the message in the ring so it can be updated, or nil if it has been overwritten.
+*/
+func (ring *messageRing) find(messageID string) *CachedMessage {
+	for i := range ring.messages {
+		if ring.messages[i].ID == messageID {
+			return &ring.messages[i]
+		}
+	}
+	return nil
+}
+
+/**
+Drops every message by the author from the ring, keeping the rest in order.
+*/
+func (ring *messageRing) removeAuthor(authorID string) {
+	var kept []CachedMessage
+	for i := range ring.messages {
+		// start from the oldest message, which is at next once the ring is full
+		message := ring.messages[(ring.next+i)%len(ring.messages)]
+		if message.AuthorID != authorID {
+			kept = append(kept, message)
+		}
+	}
+	ring.messages = kept
+	ring.next = 0
+}
+
+/**
+Forgets the author's messages in memory, including anything ~snipe would show.
+*/
+func forgetMessagesFrom(authorID string) {
+	messageCacheLock.Lock()
+	defer messageCacheLock.Unlock()
+	for _, ring := range messageRings {
+		ring.removeAuthor(authorID)
+	}
+	for channelID, sniped := range lastDeleted {
+		if sniped.Message.AuthorID == authorID {
+			delete(lastDeleted, channelID)
+		}
+	}
+	for channelID, sniped := range lastEdited {
+		if sniped.Before.AuthorID == authorID {
+			delete(lastEdited, channelID)
+		}
+	}
+}
+
+/**
+Converts a message into the form kept in the cache.
+*/
+func cacheableMessage(guildID string, message *discordgo.Message) CachedMessage {
+	cached := CachedMessage{
+		ID:        message.ID,
+		GuildID:   guildID,
+		ChannelID: message.ChannelID,
+		Content:   message.Content,
+		SentAt:    time.Now(),
+	}
+	if message.Author != nil {
+		cached.AuthorID = message.Author.ID
+		cached.Author = message.Author.Username + "#" + message.Author.Discriminator
+	}
+	if timestamp, err := message.Timestamp.Parse(); err == nil {
+		cached.SentAt = timestamp
+	}
+	for _, attachment := range message.Attachments {
+		cached.Attachments = append(cached.Attachments, attachment.Filename)
+	}
+	return cached
+}
+
+/**
+Remembers a new message so it can be logged if it is edited or deleted. If the guild has
+persistence turned on, the message is also saved to the database.
+*/
+func cacheMessage(m *discordgo.MessageCreate) {
+	if m.GuildID == "" || m.Author == nil || m.Author.Bot {
+		return
+	}
+	cached := cacheableMessage(m.GuildID, m.Message)
+	messageCacheLock.Lock()
+	ring, ok := messageRings[m.ChannelID]
+	if !ok {
+		ring = &messageRing{}
+		messageRings[m.ChannelID] = ring
+	}
+	ring.add(cached)
+	messageCacheLock.Unlock()
+
+	if isMessagePersistOn(m.GuildID) {
+		insertSQL := fmt.Sprintf("REPLACE INTO %s (message_id, guild_id, channel_id, author_id, author, content, attachments, sent_at) VALUES ('%s', '%s', '%s', '%s', '%s', '%s', '%s', '%s');",
+			messageCacheTable, cached.ID, cached.GuildID, cached.ChannelID, cached.AuthorID, escapeSQL(cached.Author), escapeSQL(cached.Content), escapeSQL(strings.Join(cached.Attachments, "\n")), sqlTimestamp(cached.SentAt))
+		queryWithoutResults(insertSQL, "Unable to persist message!")
+	}
+}
+
+/**
+Looks for the message in memory first, then in the database. Returns false if it can't be found.
+*/
+func findCachedMessage(channelID string, messageID string) (CachedMessage, bool) {
+	messageCacheLock.Lock()
+	if ring, ok := messageRings[channelID]; ok {
+		if cached := ring.find(messageID); cached != nil {
+			messageCacheLock.Unlock()
+			return *cached, true
+		}
+	}
+	messageCacheLock.Unlock()
+
+	var cached CachedMessage
+	var attachments string
+	var sentAt string
+	selectSQL := fmt.Sprintf("SELECT message_id, guild_id, channel_id, author_id, author, content, attachments, sent_at FROM %s WHERE (message_id = '%s');", messageCacheTable, messageID)
+	err := connection_pool.QueryRow(selectSQL).Scan(&cached.ID, &cached.GuildID, &cached.ChannelID, &cached.AuthorID, &cached.Author, &cached.Content, &attachments, &sentAt)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logError("Unable to read persisted message! " + err.Error())
+		}
+		return cached, false
+	}
+	if attachments != "" {
+		cached.Attachments = strings.Split(attachments, "\n")
+	}
+	cached.SentAt, _ = parseSQLTimestamp(sentAt)
+	return cached, true
+}
+
+/**
+Describes how one version of a message became another as a diff: removed lines start
+with "-", added lines with "+", and unchanged lines with a space.
+*/
+func formatEditDiff(before string, after string) string {
+	beforeLines := strings.Split(before, "\n")
+	afterLines := strings.Split(after, "\n")
+	kept := map[string]int{}
+	for _, line := range afterLines {
+		kept[line]++
+	}
+	var diff []string
+	for _, line := range beforeLines {
+		if kept[line] > 0 {
+			kept[line]--
+			continue
+		}
+		diff = append(diff, "- "+line)
+	}
+	removed := map[string]int{}
+	for _, line := range beforeLines {
+		removed[line]++
+	}
+	for _, line := range afterLines {
+		if removed[line] > 0 {
+			removed[line]--
+			diff = append(diff, "  "+line)
+			continue
+		}
+		diff = append(diff, "+ "+line)
+	}
+	return strings.Join(diff, "\n")
+}
+
+/**
+Fits text into an embed field, cutting it short if needed.
+*/
+func truncateField(text string, limit int) string {
+	if text == "" {
+		return "(no text)"
+	}
+	runes := []rune(text)
+	if len(runes) > limit {
+		return string(runes[:limit]) + "..."
+	}
+	return text
+}
+
+/**
+Logs an edit to the guild's message log channel and remembers it for ~editsnipe.
+*/
+func logMessageEdit(s *discordgo.Session, m *discordgo.MessageUpdate) {
+	// embeds being unfurled also fire updates, but without any content
+	if m.GuildID == "" || m.Author == nil || m.Author.Bot || m.EditedTimestamp == "" {
+		return
+	}
+	before, found := findCachedMessage(m.ChannelID, m.ID)
+	if found && before.Content == m.Content {
+		return
+	}
+
+	messageCacheLock.Lock()
+	if ring, ok := messageRings[m.ChannelID]; ok {
+		if cached := ring.find(m.ID); cached != nil {
+			cached.Content = m.Content
+		}
+	}
+	if found {
+		lastEdited[m.ChannelID] = SnipedEdit{before, m.Content, time.Now()}
+	}
+	messageCacheLock.Unlock()
+	if found && isMessagePersistOn(m.GuildID) {
+		updateSQL := fmt.Sprintf("UPDATE %s SET content = '%s' WHERE (message_id = '%s');", messageCacheTable, escapeSQL(m.Content), m.ID)
+		queryWithoutResults(updateSQL, "Unable to update persisted message!")
+	}
+
+	channelID := getGuildSetting(m.GuildID, "messagelog_channel")
+	if channelID == "" || channelID == m.ChannelID {
+		return
+	}
+	var embed discordgo.MessageEmbed
+	embed.Type = "rich"
+	embed.Title = ":pencil: Message edited"
+	embed.Description = fmt.Sprintf("<@%s> in <#%s> ([jump](https://discord.com/channels/%s/%s/%s))", m.Author.ID, m.ChannelID, m.GuildID, m.ChannelID, m.ID)
+	if found {
+		embed.Fields = []*discordgo.MessageEmbedField{createField("Changes", "```diff\n"+truncateField(strings.ReplaceAll(formatEditDiff(before.Content, m.Content), "`", "'"), 1000)+"```", false)}
+	} else {
+		embed.Fields = []*discordgo.MessageEmbedField{
+			createField("Before", "(not cached)", false),
+			createField("After", truncateField(m.Content, 1000), false),
+		}
+	}
+	embed.Timestamp = time.Now().Format(time.RFC3339)
+	_, err := s.ChannelMessageSendEmbed(channelID, &embed)
+	if err != nil {
+		logError("Failed to send message edit log! " + err.Error())
+	}
+}
+
+/**
+Logs a deleted message to the guild's message log channel and remembers it for ~snipe.
+*/
+func logMessageDelete(s *discordgo.Session, m *discordgo.MessageDelete) {
+	if m.GuildID == "" {
+		return
+	}
+	deleted, found := findCachedMessage(m.ChannelID, m.ID)
+	if !found {
+		// messages from bots or from before the cache started can't be logged
+		return
+	}
+	messageCacheLock.Lock()
+	lastDeleted[m.ChannelID] = SnipedDelete{deleted, time.Now()}
+	messageCacheLock.Unlock()
+
+	channelID := getGuildSetting(m.GuildID, "messagelog_channel")
+	if channelID == "" || channelID == m.ChannelID {
+		return
+	}
+	var embed discordgo.MessageEmbed
+	embed.Type = "rich"
+	embed.Title = ":wastebasket: Message deleted"
+	embed.Description = fmt.Sprintf("<@%s> in <#%s>", deleted.AuthorID, deleted.ChannelID)
+	embed.Fields = []*discordgo.MessageEmbedField{createField("Content", truncateField(deleted.Content, 1000), false)}
+	if len(deleted.Attachments) > 0 {
+		embed.Fields = append(embed.Fields, createField("Attachments", truncateField(strings.Join(deleted.Attachments, "\n"), 1000), false))
+	}
+	embed.Footer = &discordgo.MessageEmbedFooter{Text: "Sent " + deleted.SentAt.UTC().Format("2006-01-02 15:04:05") + " UTC"}
+	embed.Timestamp = time.Now().Format(time.RFC3339)
+	_, err := s.ChannelMessageSendEmbed(channelID, &embed)
+	if err != nil {
+		logError("Failed to send message delete log! " + err.Error())
+	}
+}
+
+/**
+Deletes persisted messages older than each guild's retention window. Runs every hour.
+*/
+func runMessageRetention() {
+	for {
+		query, err := connection_pool.Query(fmt.Sprintf("SELECT DISTINCT guild_id FROM %s;", messageCacheTable))
+		if err != nil {
+			logError("SELECT query error: " + err.Error())
+		} else {
+			var guildIDs []string
+			for query.Next() {
+				var guildID string
+				if err = query.Scan(&guildID); err == nil {
+					guildIDs = append(guildIDs, guildID)
+				}
+			}
+			query.Close()
+			for _, guildID := range guildIDs {
+				days, err := strconv.Atoi(getGuildSetting(guildID, "messagelog_retention"))
+				if err != nil || days < 1 {
+					days = defaultMessageRetentionDays
+				}
+				deleteSQL := fmt.Sprintf("DELETE FROM %s WHERE (guild_id = '%s' AND sent_at < '%s');", messageCacheTable, guildID, sqlTimestamp(time.Now().AddDate(0, 0, -days)))
+				queryWithoutResults(deleteSQL, "Unable to delete expired messages!")
+			}
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+/****
+COMMANDS
+****/
+
+/**
+Shows or changes where edits and deletes are logged, and whether messages are saved to the database.
+**/
+func handleMessageLog(s *discordgo.Session, m *discordgo.MessageCreate, command []string) {
+	logInfo(strings.Join(command, " "))
+	if !userHasValidPermissions(s, m, discordgo.PermissionManageServer) {
+		logWarning("User attempted to change the message log without proper permissions")
+		_, err := s.ChannelMessageSend(m.ChannelID, "Sorry, you don't have the `Manage Server` permission.")
+		if err != nil {
+			logError("Failed to send permissions message! " + err.Error())
+		}
+		return
+	}
+
+	if len(command) == 1 {
+		response := "This server has no message log channel."
+		if channelID := getGuildSetting(m.GuildID, "messagelog_channel"); channelID != "" {
+			response = "Edited and deleted messages are logged in <#" + channelID + ">."
+		}
+		if isMessagePersistOn(m.GuildID) {
+			retention := getGuildSetting(m.GuildID, "messagelog_retention")
+			if retention == "" {
+				retention = strconv.Itoa(defaultMessageRetentionDays)
+			}
+			response += " Messages are saved for " + retention + " days."
+		} else {
+			response += " Messages are only remembered in memory."
+		}
+		_, err := s.ChannelMessageSend(m.ChannelID, response)
+		if err != nil {
+			logError("Failed to send message log status message! " + err.Error())
+		}
+		return
+	}
+
+	var saved bool
+	var response string
+	switch {
+	case len(command) == 2 && command[1] == "off":
+		saved = deleteGuildSetting(m.GuildID, "messagelog_channel")
+		response = "Edited and deleted messages will no longer be logged."
+	case len(command) == 2 && strings.HasPrefix(command[1], "<#") && strings.HasSuffix(command[1], ">"):
+		channelID := strings.TrimSuffix(strings.TrimPrefix(command[1], "<#"), ">")
+		saved = setGuildSetting(m.GuildID, "messagelog_channel", channelID)
+		response = "Edited and deleted messages will now be logged in <#" + channelID + ">."
+	case len(command) == 3 && command[1] == "persist" && (command[2] == "on" || command[2] == "off"):
+		saved = setMessagePersist(m.GuildID, command[2])
+		response = "Messages will now only be remembered in memory."
+		if command[2] == "on" {
+			response = "Messages will now be saved to the database, so edits and deletes can be logged after a restart."
+		} else {
+			saved = saved && queryWithoutResults(fmt.Sprintf("DELETE FROM %s WHERE (guild_id = '%s');", messageCacheTable, m.GuildID), "Unable to delete persisted messages!")
+		}
+	case len(command) == 3 && command[1] == "retention":
+		days, err := strconv.Atoi(command[2])
+		if err != nil || days < 1 {
+			_, err = s.ChannelMessageSend(m.ChannelID, "Please input a valid number of days.")
+			if err != nil {
+				logError("Failed to send invalid days message! " + err.Error())
+			}
+			return
+		}
+		saved = setGuildSetting(m.GuildID, "messagelog_retention", command[2])
+		response = "Saved messages will now be kept for " + command[2] + " days."
+	default:
+		_, err := s.ChannelMessageSend(m.ChannelID, "Usages: ```~messagelog\n~messagelog <#channel/off>\n~messagelog persist on/off\n~messagelog retention <days>```")
+		if err != nil {
+			logError("Failed to send usage message! " + err.Error())
+		}
+		return
+	}
+	if !saved {
+		response = "An error occurred. Please try again in a moment."
+	}
+	_, err := s.ChannelMessageSend(m.ChannelID, response)
+	if err != nil {
+		logError("Failed to send message log result message! " + err.Error())
+		return
+	}
+	logSuccess("Updated message log settings")
+}
+
+/**
+Shows the last deleted message in the channel.
+**/
+func handleSnipe(s *discordgo.Session, m *discordgo.MessageCreate, command []string) {
+	logInfo(strings.Join(command, " "))
+	if !userHasValidPermissions(s, m, discordgo.PermissionManageMessages) {
+		logWarning("User attempted to snipe without proper permissions")
+		_, err := s.ChannelMessageSend(m.ChannelID, "Sorry, you aren't allowed to manage messages.")
+		if err != nil {
+			logError("Failed to send permissions message! " + err.Error())
+		}
+		return
+	}
+
+	messageCacheLock.Lock()
+	sniped, ok := lastDeleted[m.ChannelID]
+	messageCacheLock.Unlock()
+	if !ok {
+		_, err := s.ChannelMessageSend(m.ChannelID, "There's nothing to snipe here.")
+		if err != nil {
+			logError("Failed to send 'nothing to snipe' message! " + err.Error())
+		}
+		return
+	}
+
+	var embed discordgo.MessageEmbed
+	embed.Type = "rich"
+	embed.Author = &discordgo.MessageEmbedAuthor{Name: sniped.Message.Author}
+	embed.Description = truncateField(sniped.Message.Content, 2000)
+	if len(sniped.Message.Attachments) > 0 {
+		embed.Fields = []*discordgo.MessageEmbedField{createField("Attachments", truncateField(strings.Join(sniped.Message.Attachments, "\n"), 1000), false)}
+	}
+	embed.Footer = &discordgo.MessageEmbedFooter{Text: "Deleted"}
+	embed.Timestamp = sniped.DeletedAt.Format(time.RFC3339)
+	_, err := s.ChannelMessageSendEmbed(m.ChannelID, &embed)
+	if err != nil {
+		logError("Failed to send snipe embed! " + err.Error())
+		return
+	}
+	logSuccess("Sent snipe")
+}
+
+/**
+Shows the last edited message in the channel, before and after the edit.
+**/
+func handleEditSnipe(s *discordgo.Session, m *discordgo.MessageCreate, command []string) {
+	logInfo(strings.Join(command, " "))
+	if !userHasValidPermissions(s, m, discordgo.PermissionManageMessages) {
+		logWarning("User attempted to editsnipe without proper permissions")
+		_, err := s.ChannelMessageSend(m.ChannelID, "Sorry, you aren't allowed to manage messages.")
+		if err != nil {
+			logError("Failed to send permissions message! " + err.Error())
+		}
+		return
+	}
+
+	messageCacheLock.Lock()
+	sniped, ok := lastEdited[m.ChannelID]
+	messageCacheLock.Unlock()
+	if !ok {
+		_, err := s.ChannelMessageSend(m.ChannelID, "There's nothing to snipe here.")
+		if err != nil {
+			logError("Failed to send 'nothing to snipe' message! " + err.Error())
+		}
+		return
+	}
+
+	var embed discordgo.MessageEmbed
+	embed.Type = "rich"
+	embed.Author = &discordgo.MessageEmbedAuthor{Name: sniped.Before.Author}
+	embed.Description = fmt.Sprintf("[Jump to message](https://discord.com/channels/%s/%s/%s)", sniped.Before.GuildID, sniped.Before.ChannelID, sniped.Before.ID)
+	embed.Fields = []*discordgo.MessageEmbedField{
+		createField("Before", truncateField(sniped.Before.Content, 1000), false),
+		createField("After", truncateField(sniped.After, 1000), false),
+	}
+	embed.Footer = &discordgo.MessageEmbedFooter{Text: "Edited"}
+	embed.Timestamp = sniped.EditedAt.Format(time.RFC3339)
+	_, err := s.ChannelMessageSendEmbed(m.ChannelID, &embed)
+	if err != nil {
+		logError("Failed to send editsnipe embed! " + err.Error())
+		return
+	}
+	logSuccess("Sent editsnipe")
+}
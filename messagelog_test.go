@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+/**
+Test anything that uses calculation outside of the discordgo commands.
+Those commands don't need to be tested since they are verified to work
+at github.com/bwmarrin/discordgo
+**/
+func TestMessageLog(t *testing.T) {
+	t.Run("The ring keeps only the most recent messages", func(t *testing.T) {
+		ring := &messageRing{}
+		for i := 0; i < messageRingSize+10; i++ {
+			ring.add(CachedMessage{ID: strconv.Itoa(i)})
+		}
+		if len(ring.messages) != messageRingSize || ring.find("9") != nil || ring.find("10") == nil || ring.find(strconv.Itoa(messageRingSize+9)) == nil {
+			t.Logf("Ring kept the wrong messages")
+			t.Fail()
+		}
+	})
+
+	t.Run("Cached messages can be updated in place", func(t *testing.T) {
+		ring := &messageRing{}
+		ring.add(CachedMessage{ID: "1", Content: "before"})
+		ring.find("1").Content = "after"
+		if ring.find("1").Content != "after" {
+			t.Logf("Failed to update the cached message")
+			t.Fail()
+		}
+	})
+
+	t.Run("An author's messages can be forgotten", func(t *testing.T) {
+		ring := &messageRing{}
+		for i := 0; i < messageRingSize+10; i++ {
+			ring.add(CachedMessage{ID: strconv.Itoa(i), AuthorID: strconv.Itoa(i % 2)})
+		}
+		ring.removeAuthor("1")
+		if len(ring.messages) != messageRingSize/2 || ring.find("11") != nil || ring.messages[0].ID != "10" {
+			t.Logf("Ring kept the wrong messages after forgetting an author")
+			t.Fail()
+		}
+		ring.add(CachedMessage{ID: "new"})
+		if ring.messages[len(ring.messages)-1].ID != "new" {
+			t.Logf("New messages should be added after the remaining ones")
+			t.Fail()
+		}
+	})
+
+	t.Run("Edits are shown as a diff", func(t *testing.T) {
+		diff := formatEditDiff("hello\nworld", "hello\nthere")
+		if diff != "- world\n  hello\n+ there" {
+			t.Logf("Unexpected diff: %q", diff)
+			t.Fail()
+		}
+	})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+/**
+Posts the embed to the guild's mod log channel, if one is set.
+*/
+func sendModLog(s *discordgo.Session, guildID string, embed *discordgo.MessageEmbed) {
+	sendModAlert(s, guildID, "", embed)
+}
+
+/**
+Posts the embed to the guild's mod log channel along with a message, such as a ping
+for staff, if a mod log channel is set.
+*/
+func sendModAlert(s *discordgo.Session, guildID string, content string, embed *discordgo.MessageEmbed) {
+	channelID := getGuildSetting(guildID, "modlog_channel")
+	if channelID == "" {
+		return
+	}
+	embed.Type = "rich"
+	_, err := s.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{Content: content, Embed: embed})
+	if err != nil {
+		logError("Failed to send mod log message! " + err.Error())
+	}
+}
+
+/**
+Shows, sets or turns off the channel where moderation events are logged.
+*/
+func handleModLog(s *discordgo.Session, m *discordgo.MessageCreate, command []string) {
+	logInfo(strings.Join(command, " "))
+	if !userHasValidPermissions(s, m, discordgo.PermissionManageServer) {
+		logWarning("User attempted to change the mod log without proper permissions")
+		_, err := s.ChannelMessageSend(m.ChannelID, "Sorry, you don't have the `Manage Server` permission.")
+		if err != nil {
+			logError("Failed to send permissions message! " + err.Error())
+		}
+		return
+	}
+
+	if len(command) == 1 {
+		response := "This server has no mod log channel."
+		if channelID := getGuildSetting(m.GuildID, "modlog_channel"); channelID != "" {
+			response = "Moderation events are logged in <#" + channelID + ">."
+		}
+		_, err := s.ChannelMessageSend(m.ChannelID, response)
+		if err != nil {
+			logError("Failed to send mod log status message! " + err.Error())
+		}
+		return
+	}
+
+	if len(command) != 2 || (command[1] != "off" && (!strings.HasPrefix(command[1], "<#") || !strings.HasSuffix(command[1], ">"))) {
+		_, err := s.ChannelMessageSend(m.ChannelID, "Usage: `~modlog <#channel/off>`")
+		if err != nil {
+			logError("Failed to send usage message! " + err.Error())
+		}
+		return
+	}
+
+	var saved bool
+	var response string
+	if command[1] == "off" {
+		saved = deleteGuildSetting(m.GuildID, "modlog_channel")
+		response = "Moderation events will no longer be logged."
+	} else {
+		channelID := strings.TrimSuffix(strings.TrimPrefix(command[1], "<#"), ">")
+		saved = setGuildSetting(m.GuildID, "modlog_channel", channelID)
+		response = "Moderation events will now be logged in <#" + channelID + ">."
+	}
+	if !saved {
+		response = "An error occurred. Please try again in a moment."
+	}
+	_, err := s.ChannelMessageSend(m.ChannelID, response)
+	if err != nil {
+		logError("Failed to send mod log result message! " + err.Error())
+		return
+	}
+	logSuccess("Updated mod log channel")
+}
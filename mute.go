@@ -0,0 +1,360 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+type Mute struct {
+	GuildID   string `json:"guild_id"`
+	MemberID  string `json:"member_id"`
+	ExpiresAt string `json:"expires_at"`
+	Reason    string `json:"reason"`
+}
+
+// permissions the mute role is denied in every channel
+const mutedPermissions = discordgo.PermissionSendMessages | discordgo.PermissionAddReactions | discordgo.PermissionVoiceSpeak
+
+/**
+Returns the guild's configured mute role. If the guild has none (or it was deleted), a new
+"Muted" role is created, denied the ability to talk in every channel, and saved as the mute role.
+*/
+func getMuteRole(s *discordgo.Session, guildID string) (string, error) {
+	roleID := getGuildSetting(guildID, "mute_role")
+	roles, err := s.GuildRoles(guildID)
+	if err != nil {
+		return "", err
+	}
+	for _, role := range roles {
+		if role.ID == roleID {
+			return roleID, nil
+		}
+	}
+
+	logInfo("Creating a mute role for guild " + guildID)
+	role, err := s.GuildRoleCreate(guildID)
+	if err != nil {
+		return "", err
+	}
+	_, err = s.GuildRoleEdit(guildID, role.ID, "Muted", 0, false, 0, false)
+	if err != nil {
+		logWarning("Unable to name the new mute role. " + err.Error())
+	}
+
+	channels, err := s.GuildChannels(guildID)
+	if err != nil {
+		return "", err
+	}
+	for _, channel := range channels {
+		applyMuteOverwrite(s, channel.ID, role.ID)
+	}
+
+	if !setGuildSetting(guildID, "mute_role", role.ID) {
+		return "", fmt.Errorf("unable to save the new mute role")
+	}
+	return role.ID, nil
+}
+
+/**
+Denies the mute role the ability to talk in the given channel.
+*/
+func applyMuteOverwrite(s *discordgo.Session, channelID string, roleID string) {
+	err := s.ChannelPermissionSet(channelID, roleID, discordgo.PermissionOverwriteTypeRole, 0, mutedPermissions)
+	if err != nil {
+		logWarning("Unable to set mute overwrite on channel " + channelID + ". " + err.Error())
+	}
+}
+
+/**
+Gives the user the mute role and records when it should be lifted.
+*/
+func muteMember(s *discordgo.Session, guildID string, userID string, duration time.Duration, moderator *discordgo.User, reason string) error {
+	roleID, err := getMuteRole(s, guildID)
+	if err != nil {
+		return err
+	}
+	err = s.GuildMemberRoleAdd(guildID, userID, roleID)
+	if err != nil {
+		return err
+	}
+
+	replaceSQL := fmt.Sprintf("REPLACE INTO %s (guild_id, member_id, expires_at, reason) VALUES ('%s', '%s', '%s', '%s');",
+		mutesTable, guildID, userID, sqlTimestamp(time.Now().Add(duration)), escapeSQL(reason))
+	if !queryWithoutResults(replaceSQL, "Unable to save mute!") {
+		return fmt.Errorf("unable to save the mute's expiry")
+	}
+
+	message := fmt.Sprintf("You have been muted in **%s** by %s#%s for %s", getGuildName(s, guildID), moderator.Username, moderator.Discriminator, duration.String())
+	if reason != "" {
+		message += " because: " + reason
+	}
+	dmUser(s, userID, message+"\n")
+	return nil
+}
+
+/**
+Removes the mute role from the user and forgets their mute. If the user is no longer in the
+guild, the mute is simply forgotten.
+*/
+func unmuteMember(s *discordgo.Session, guildID string, userID string) error {
+	roleID := getGuildSetting(guildID, "mute_role")
+	if roleID != "" {
+		err := s.GuildMemberRoleRemove(guildID, userID, roleID)
+		if err != nil {
+			restErr, ok := err.(*discordgo.RESTError)
+			if !ok || restErr.Message == nil || (restErr.Message.Code != discordgo.ErrCodeUnknownMember && restErr.Message.Code != discordgo.ErrCodeUnknownRole) {
+				return err
+			}
+		}
+	}
+	deleteSQL := fmt.Sprintf("DELETE FROM %s WHERE (guild_id = '%s' AND member_id = '%s');", mutesTable, guildID, userID)
+	if !queryWithoutResults(deleteSQL, "Unable to delete mute!") {
+		return fmt.Errorf("unable to remove the mute")
+	}
+	return nil
+}
+
+/**
+Returns the user's mute in the guild, or nil if they are not muted.
+*/
+func getActiveMute(guildID string, userID string) *Mute {
+	selectSQL := fmt.Sprintf("SELECT * FROM %s WHERE (guild_id = '%s' AND member_id = '%s' AND expires_at > '%s');", mutesTable, guildID, userID, sqlTimestamp(time.Now()))
+	query, err := connection_pool.Query(selectSQL)
+	if err != nil {
+		logError("SELECT query error: " + err.Error())
+		return nil
+	}
+	defer query.Close()
+
+	for query.Next() {
+		var mute Mute
+		err = query.Scan(&mute.GuildID, &mute.MemberID, &mute.ExpiresAt, &mute.Reason)
+		if err != nil {
+			logError("Unable to parse database information! Aborting. " + err.Error())
+			return nil
+		}
+		return &mute
+	}
+	return nil
+}
+
+/**
+Puts the mute role back on a user who left and rejoined while muted.
+*/
+func reapplyMute(s *discordgo.Session, guildID string, userID string) {
+	if getActiveMute(guildID, userID) == nil {
+		return
+	}
+	roleID, err := getMuteRole(s, guildID)
+	if err != nil {
+		logError("Unable to get the mute role! " + err.Error())
+		return
+	}
+	err = s.GuildMemberRoleAdd(guildID, userID, roleID)
+	if err != nil {
+		logError("Unable to reapply mute to rejoining member! " + err.Error())
+		return
+	}
+	logSuccess("Reapplied mute to rejoining member")
+}
+
+/**
+Lifts mutes once they expire.
+*/
+func runMuteScheduler(dg *discordgo.Session) {
+	for {
+		selectSQL := fmt.Sprintf("SELECT * FROM %s WHERE (expires_at <= '%s');", mutesTable, sqlTimestamp(time.Now()))
+		query, err := connection_pool.Query(selectSQL)
+		if err != nil {
+			logError("SELECT query error: " + err.Error())
+		} else {
+			var expired []Mute
+			for query.Next() {
+				var mute Mute
+				err = query.Scan(&mute.GuildID, &mute.MemberID, &mute.ExpiresAt, &mute.Reason)
+				if err != nil {
+					logError("Unable to parse database information! Aborting. " + err.Error())
+					break
+				}
+				expired = append(expired, mute)
+			}
+			query.Close()
+
+			for _, mute := range expired {
+				err = unmuteMember(dg, mute.GuildID, mute.MemberID)
+				if err != nil {
+					logError("Unable to lift expired mute! " + err.Error())
+				} else {
+					logSuccess("Lifted expired mute")
+				}
+			}
+		}
+
+		time.Sleep(time.Minute)
+	}
+}
+
+/****
+COMMANDS
+****/
+
+/**
+Mutes a user for a period of time if the invoking user has the permission to kick users.
+Admins can also choose which role is used as the mute role.
+**/
+func handleMute(s *discordgo.Session, m *discordgo.MessageCreate, command []string) {
+	if len(command) == 3 && command[1] == "role" {
+		setMuteRole(s, m, command)
+		return
+	}
+	if !userHasValidPermissions(s, m, discordgo.PermissionKickMembers) {
+		logWarning("User attempted to use mute without proper permissions")
+		_, err := s.ChannelMessageSend(m.ChannelID, "Sorry, you aren't allowed to mute users.")
+		if err != nil {
+			logError("Failed to send permissions message! " + err.Error())
+		}
+		return
+	}
+	attemptMute(s, m, command)
+}
+
+/**
+A helper function for handleMute. Ensures the user targeted a user using @ and gave a valid
+duration; if they did, mute the specified user.
+**/
+func attemptMute(s *discordgo.Session, m *discordgo.MessageCreate, command []string) {
+	logInfo(strings.Join(command, " "))
+	regex := regexp.MustCompile(`^\<\@\!?[0-9]+\>$`)
+	if len(command) < 3 || !regex.MatchString(command[1]) {
+		_, err := s.ChannelMessageSend(m.ChannelID, "Usage: `~mute @<user> <duration, e.g. 30m / 12h / 2d> (reason: optional)`")
+		if err != nil {
+			logError("Failed to send usage message! " + err.Error())
+		}
+		return
+	}
+	userID := stripUserID(command[1])
+	duration, err := parseDuration(command[2])
+	if err != nil || duration <= 0 {
+		_, err = s.ChannelMessageSend(m.ChannelID, "Please input a valid duration, e.g. `30m`, `12h` or `2d`.")
+		if err != nil {
+			logError("Failed to send invalid duration message! " + err.Error())
+		}
+		return
+	}
+	reason := strings.Join(command[3:], " ")
+
+	err = muteMember(s, m.GuildID, userID, duration, m.Author, reason)
+	if err != nil {
+		logError("Failed to mute user! " + err.Error())
+		_, err = s.ChannelMessageSend(m.ChannelID, "Failed to mute the user.")
+		if err != nil {
+			logWarning("Failed to send failure message! " + err.Error())
+		}
+		return
+	}
+
+	response := ":mute: Muted " + command[1] + " for " + duration.String()
+	if reason != "" {
+		response += " for the following reason: '" + reason + "'"
+	}
+	_, err = s.ChannelMessageSend(m.ChannelID, response+".")
+	if err != nil {
+		logWarning("Failed to send success message! " + err.Error())
+		return
+	}
+	logSuccess("Muted user")
+}
+
+/**
+Sets an existing role as the guild's mute role and applies the mute overwrites to it.
+**/
+func setMuteRole(s *discordgo.Session, m *discordgo.MessageCreate, command []string) {
+	logInfo(strings.Join(command, " "))
+	if !userHasValidPermissions(s, m, discordgo.PermissionManageServer) {
+		logWarning("User attempted to set the mute role without proper permissions")
+		_, err := s.ChannelMessageSend(m.ChannelID, "Sorry, you don't have the `Manage Server` permission.")
+		if err != nil {
+			logError("Failed to send permissions message! " + err.Error())
+		}
+		return
+	}
+	regex := regexp.MustCompile(`^\<\@\&[0-9]+\>$`)
+	if !regex.MatchString(command[2]) {
+		_, err := s.ChannelMessageSend(m.ChannelID, "Usage: `~mute role @<role>`")
+		if err != nil {
+			logError("Failed to send usage message! " + err.Error())
+		}
+		return
+	}
+	roleID := strings.TrimSuffix(strings.TrimPrefix(command[2], "<@&"), ">")
+
+	channels, err := s.GuildChannels(m.GuildID)
+	if err != nil {
+		logError("Failed to retrieve guild channels! " + err.Error())
+		_, err = s.ChannelMessageSend(m.ChannelID, "Error retrieving the guild's channels. :frowning:")
+		if err != nil {
+			logError("Failed to send error message! " + err.Error())
+		}
+		return
+	}
+	for _, channel := range channels {
+		applyMuteOverwrite(s, channel.ID, roleID)
+	}
+
+	if !setGuildSetting(m.GuildID, "mute_role", roleID) {
+		_, err = s.ChannelMessageSend(m.ChannelID, "An error occurred. Please try again in a moment.")
+		if err != nil {
+			logError("Failed to send error message! " + err.Error())
+		}
+		return
+	}
+	_, err = s.ChannelMessageSend(m.ChannelID, "Set "+command[2]+" as the mute role and denied it from talking in every channel.")
+	if err != nil {
+		logError("Failed to send mute role result message! " + err.Error())
+		return
+	}
+	logSuccess("Set mute role")
+}
+
+/**
+Lifts a user's mute early if the invoking user has the permission to kick users.
+**/
+func handleUnmute(s *discordgo.Session, m *discordgo.MessageCreate, command []string) {
+	logInfo(strings.Join(command, " "))
+	if !userHasValidPermissions(s, m, discordgo.PermissionKickMembers) {
+		logWarning("User attempted to use unmute without proper permissions")
+		_, err := s.ChannelMessageSend(m.ChannelID, "Sorry, you aren't allowed to unmute users.")
+		if err != nil {
+			logError("Failed to send permissions message! " + err.Error())
+		}
+		return
+	}
+	regex := regexp.MustCompile(`^\<\@\!?[0-9]+\>$`)
+	if len(command) != 2 || !regex.MatchString(command[1]) {
+		_, err := s.ChannelMessageSend(m.ChannelID, "Usage: `~unmute @<user>`")
+		if err != nil {
+			logError("Failed to send usage message! " + err.Error())
+		}
+		return
+	}
+
+	err := unmuteMember(s, m.GuildID, stripUserID(command[1]))
+	if err != nil {
+		logError("Failed to unmute user! " + err.Error())
+		_, err = s.ChannelMessageSend(m.ChannelID, "Failed to unmute the user.")
+		if err != nil {
+			logWarning("Failed to send failure message! " + err.Error())
+		}
+		return
+	}
+	_, err = s.ChannelMessageSend(m.ChannelID, ":speaker: Unmuted "+command[1]+".")
+	if err != nil {
+		logWarning("Failed to send success message! " + err.Error())
+		return
+	}
+	logSuccess("Unmuted user")
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// set with TRACK_PRESENCE, since presence updates need the privileged presence intent
+var trackPresence bool
+
+// members change status often, so last_online is written at most this often per member
+const presenceWriteInterval = 15 * time.Minute
+
+var lastOnlineWrites = map[string]time.Time{}
+var lastOnlineWritesPruned time.Time
+var lastOnlineWritesLock sync.Mutex
+
+/**
+Reports whether enough time has passed since the member's last_online was written, and if so
+remembers that it's being written now. Writes older than the interval no longer matter, so they
+are dropped at most once per interval.
+*/
+func lastOnlineWriteDue(guildID string, memberID string, now time.Time) bool {
+	lastOnlineWritesLock.Lock()
+	defer lastOnlineWritesLock.Unlock()
+	key := guildID + ":" + memberID
+	if written, ok := lastOnlineWrites[key]; ok && now.Sub(written) < presenceWriteInterval {
+		return false
+	}
+	if now.Sub(lastOnlineWritesPruned) >= presenceWriteInterval {
+		for other, written := range lastOnlineWrites {
+			if now.Sub(written) >= presenceWriteInterval {
+				delete(lastOnlineWrites, other)
+			}
+		}
+		lastOnlineWritesPruned = now
+	}
+	lastOnlineWrites[key] = now
+	return true
+}
+
+/**
+Records that the member was online. Going offline counts too, since they were online until then.
+Members without an activity row, and members who opted out, are left alone.
+*/
+func recordLastOnline(guildID string, user *discordgo.User) {
+	if guildID == "" || user == nil || user.Bot {
+		return
+	}
+	now := time.Now()
+	if !lastOnlineWriteDue(guildID, user.ID, now) || isOptedOut(user.ID) {
+		return
+	}
+	updateSQL := fmt.Sprintf("UPDATE %s SET last_online = '%s' WHERE (guild_id = '%s' AND member_id = '%s' AND left_at IS NULL);",
+		activityTable, sqlTimestamp(now), guildID, user.ID)
+	queryWithoutResults(updateSQL, "Unable to update user's last online time!")
+}
+
+/**
+Records everyone who was online when the guild became available.
+*/
+func recordGuildPresences(guild *discordgo.Guild) {
+	for _, presence := range guild.Presences {
+		if presence.Status != discordgo.StatusOffline {
+			recordLastOnline(guild.ID, presence.User)
+		}
+	}
+}
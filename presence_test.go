@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+/**
+Test anything that uses calculation outside of the discordgo commands.
+Those commands don't need to be tested since they are verified to work
+at github.com/bwmarrin/discordgo
+**/
+func TestPresence(t *testing.T) {
+	now := time.Date(2021, 6, 30, 12, 0, 0, 0, time.UTC)
+
+	t.Run("Last online is written at most once per interval", func(t *testing.T) {
+		if !lastOnlineWriteDue("guild", "1", now) {
+			t.Log("Expected the first presence update to be written")
+			t.Fail()
+		}
+		if lastOnlineWriteDue("guild", "1", now.Add(time.Minute)) {
+			t.Log("Expected a presence update a minute later to be skipped")
+			t.Fail()
+		}
+		if !lastOnlineWriteDue("other guild", "1", now.Add(time.Minute)) {
+			t.Log("Expected each guild to be tracked separately")
+			t.Fail()
+		}
+		if !lastOnlineWriteDue("guild", "1", now.Add(presenceWriteInterval)) {
+			t.Log("Expected a presence update after the interval to be written")
+			t.Fail()
+		}
+	})
+
+	t.Run("Old writes are forgotten", func(t *testing.T) {
+		lastOnlineWriteDue("guild", "2", now)
+		lastOnlineWriteDue("guild", "3", now.Add(2*presenceWriteInterval))
+		lastOnlineWritesLock.Lock()
+		_, kept := lastOnlineWrites["guild:2"]
+		lastOnlineWritesLock.Unlock()
+		if kept {
+			t.Log("Expected a write older than the interval to be dropped")
+			t.Fail()
+		}
+	})
+}
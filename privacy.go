@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// PrivacyTable : a table holding data about members, and the column that identifies them
+type PrivacyTable struct {
+	Name      string
+	Table     string
+	Column    string
+	Deletable bool
+}
+
+// pendingPrivacyDelete : a ~privacy delete waiting for the member who asked for it to react
+type pendingPrivacyDelete struct {
+	ChannelID string
+	UserID    string
+	Expires   time.Time
+}
+
+const privacyConfirmWindow = time.Minute
+
+// what the activity table says instead of the last thing a member did, once they've opted out or deleted their data
+const optedOutDescription = "Activity tracking opted out"
+const deletedDescription = "Deleted their data"
+
+var pendingPrivacyDeletes = map[string]pendingPrivacyDelete{}
+var pendingPrivacyDeletesLock sync.Mutex
+
+var optedOutMembers map[string]bool
+var optedOutMembersLock sync.Mutex
+
+/**
+Lists every table that stores something about a member. Moderation records, the roles the bot
+needs to give back, and the opt-out itself are exported but never deleted.
+*/
+func privacyTables() []PrivacyTable {
+	return []PrivacyTable{
+		{Name: "activity", Table: activityTable, Column: "member_id", Deletable: true},
+		{Name: "activity_events", Table: activityEventsTable, Column: "member_id", Deletable: true},
+		{Name: "leaderboard", Table: leaderboardTable, Column: "member_id", Deletable: true},
+		{Name: "voice_sessions", Table: voiceSessionsTable, Column: "member_id", Deletable: true},
+		{Name: "invite_joins", Table: inviteJoinsTable, Column: "member_id", Deletable: true},
+		{Name: "invites_used", Table: inviteJoinsTable, Column: "inviter_id"},
+		{Name: "message_cache", Table: messageCacheTable, Column: "author_id", Deletable: true},
+		{Name: "autokick_warnings", Table: autokickWarningsTable, Column: "member_id", Deletable: true},
+		{Name: "inactive_roles", Table: inactiveMembersTable, Column: "member_id"},
+		{Name: "warnings", Table: warningsTable, Column: "member_id"},
+		{Name: "mutes", Table: mutesTable, Column: "member_id"},
+		{Name: "privacy_optout", Table: privacyOptoutsTable, Column: "member_id"},
+	}
+}
+
+/**
+Reads every row of the table that belongs to the member, as column name to value.
+*/
+func getPrivacyRows(table PrivacyTable, userID string) ([]map[string]string, error) {
+	rows := []map[string]string{}
+	selectSQL := fmt.Sprintf("SELECT * FROM %s WHERE (%s = '%s');", table.Table, table.Column, userID)
+	query, err := connection_pool.Query(selectSQL)
+	if err != nil {
+		return rows, err
+	}
+	defer query.Close()
+	columns, err := query.Columns()
+	if err != nil {
+		return rows, err
+	}
+	for query.Next() {
+		values := make([]sql.NullString, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		err = query.Scan(pointers...)
+		if err != nil {
+			return rows, err
+		}
+		row := map[string]string{}
+		for i, column := range columns {
+			row[column] = values[i].String
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+/**
+Encodes everything stored about the member as an indented JSON document.
+*/
+func privacyExportJSON(userID string, data map[string][]map[string]string, now time.Time) ([]byte, error) {
+	return json.MarshalIndent(struct {
+		MemberID   string                         `json:"member_id"`
+		ExportedAt string                         `json:"exported_at"`
+		Data       map[string][]map[string]string `json:"data"`
+	}{userID, now.UTC().Format(time.RFC3339), data}, "", "  ")
+}
+
+/**
+Reports whether the member has asked not to have their activity or points recorded.
+*/
+func isOptedOut(userID string) bool {
+	optedOutMembersLock.Lock()
+	defer optedOutMembersLock.Unlock()
+	if optedOutMembers == nil {
+		query, err := connection_pool.Query(fmt.Sprintf("SELECT member_id FROM %s;", privacyOptoutsTable))
+		if err != nil {
+			logError("Unable to read privacy opt-outs! " + err.Error())
+			return false
+		}
+		defer query.Close()
+		members := map[string]bool{}
+		for query.Next() {
+			var memberID string
+			if err = query.Scan(&memberID); err == nil {
+				members[memberID] = true
+			}
+		}
+		optedOutMembers = members
+	}
+	return optedOutMembers[userID]
+}
+
+/**
+Records whether the member is opted out, in the database and the cache.
+*/
+func setOptedOut(userID string, optedOut bool) bool {
+	sqlQuery := fmt.Sprintf("DELETE FROM %s WHERE (member_id = '%s');", privacyOptoutsTable, userID)
+	if optedOut {
+		sqlQuery = fmt.Sprintf("INSERT IGNORE INTO %s (member_id, opted_out_at) VALUES ('%s', '%s');", privacyOptoutsTable, userID, sqlTimestamp(time.Now()))
+	}
+	if !queryWithoutResults(sqlQuery, "Unable to update privacy opt-out!") {
+		return false
+	}
+	// make sure the cache is loaded before changing it
+	isOptedOut(userID)
+	optedOutMembersLock.Lock()
+	defer optedOutMembersLock.Unlock()
+	if optedOutMembers != nil {
+		if optedOut {
+			optedOutMembers[userID] = true
+		} else {
+			delete(optedOutMembers, userID)
+		}
+	}
+	return true
+}
+
+/**
+Deletes the member's deletable data from every table, returning how many tables failed. Rows for
+servers the member is still in are reset to their name, whitelist flag and the time they were last
+active rather than deleted, so deleting their data neither hides them from auto-kick nor restarts
+its clock. Their messages are
+also forgotten by ~snipe and the message log.
+*/
+func deletePrivacyData(userID string) int {
+	failed := 0
+	forgetMessagesFrom(userID)
+	for _, table := range privacyTables() {
+		if !table.Deletable {
+			continue
+		}
+		if table.Table == activityTable {
+			description := deletedDescription
+			if isOptedOut(userID) {
+				description = optedOutDescription
+			}
+			deleteSQL := fmt.Sprintf("DELETE FROM %s WHERE (member_id = '%s' AND left_at IS NOT NULL);", activityTable, userID)
+			updateSQL := fmt.Sprintf("UPDATE %s SET description = '%s', rejoin_count = 0, last_online = NULL WHERE (member_id = '%s');",
+				activityTable, description, userID)
+			if !queryWithoutResults(deleteSQL, "Unable to delete member data from activity!") || !queryWithoutResults(updateSQL, "Unable to reset member data in activity!") {
+				failed++
+			}
+			continue
+		}
+		deleteSQL := fmt.Sprintf("DELETE FROM %s WHERE (%s = '%s');", table.Table, table.Column, userID)
+		if !queryWithoutResults(deleteSQL, "Unable to delete member data from "+table.Name+"!") {
+			failed++
+		}
+	}
+	return failed
+}
+
+/****
+COMMANDS
+****/
+
+/**
+Lets members export or delete what the bot stores about them, or stop it recording their activity.
+**/
+func handlePrivacy(s *discordgo.Session, m *discordgo.MessageCreate, command []string) {
+	if len(command) != 2 {
+		_, err := s.ChannelMessageSend(m.ChannelID, "Usage: ```~privacy export/delete/optout/optin```")
+		if err != nil {
+			logError("Failed to send usage message! " + err.Error())
+		}
+		return
+	}
+	switch command[1] {
+	case "export":
+		sendPrivacyExport(s, m)
+	case "delete":
+		requestPrivacyDelete(s, m)
+	case "optout", "optin":
+		optedOut := command[1] == "optout"
+		response := "The bot will no longer record your activity or award you points on any server. Only the time you were last active is kept, for auto-kick. Use `~privacy optin` to undo this."
+		if !optedOut {
+			response = "The bot will record your activity and award you points again."
+		}
+		if !setOptedOut(m.Author.ID, optedOut) {
+			response = "An error occurred. Please try again in a moment."
+		}
+		_, err := s.ChannelMessageSend(m.ChannelID, response)
+		if err != nil {
+			logError("Failed to send privacy opt-out message! " + err.Error())
+			return
+		}
+		logSuccess("Updated privacy opt-out")
+	default:
+		_, err := s.ChannelMessageSend(m.ChannelID, "Usage: ```~privacy export/delete/optout/optin```")
+		if err != nil {
+			logError("Failed to send usage message! " + err.Error())
+		}
+	}
+}
+
+/**
+DMs the member a JSON file of everything stored about them on every server.
+*/
+func sendPrivacyExport(s *discordgo.Session, m *discordgo.MessageCreate) {
+	data := map[string][]map[string]string{}
+	for _, table := range privacyTables() {
+		rows, err := getPrivacyRows(table, m.Author.ID)
+		if err != nil {
+			logError("Unable to read member data from " + table.Name + "! " + err.Error())
+			_, err = s.ChannelMessageSend(m.ChannelID, "An error occurred. Please try again in a moment.")
+			if err != nil {
+				logError("Failed to send error message! " + err.Error())
+			}
+			return
+		}
+		data[table.Name] = rows
+	}
+	export, err := privacyExportJSON(m.Author.ID, data, time.Now())
+	if err != nil {
+		logError("Unable to encode member data! " + err.Error())
+		return
+	}
+
+	response := "I've sent you a DM with everything I store about you."
+	channel, err := s.UserChannelCreate(m.Author.ID)
+	if err == nil {
+		_, err = s.ChannelMessageSendComplex(channel.ID, &discordgo.MessageSend{
+			Content: "Here's everything I store about you across all servers.",
+			Files: []*discordgo.File{{
+				Name:        "privacy-export-" + time.Now().UTC().Format("2006-01-02") + ".json",
+				ContentType: "application/json",
+				Reader:      bytes.NewReader(export),
+			}},
+		})
+	}
+	if err != nil {
+		logError("Failed to DM privacy export! " + err.Error())
+		response = "I couldn't DM you. Please allow direct messages from server members and try again."
+	}
+	_, err = s.ChannelMessageSend(m.ChannelID, response)
+	if err != nil {
+		logError("Failed to send privacy export message! " + err.Error())
+		return
+	}
+	logSuccess("Sent privacy export")
+}
+
+/**
+Asks the member to confirm that they want their data deleted.
+*/
+func requestPrivacyDelete(s *discordgo.Session, m *discordgo.MessageCreate) {
+	message, err := s.ChannelMessageSend(m.ChannelID, "<@"+m.Author.ID+"> This deletes your activity history, points, voice sessions, invite joins and cached messages on every server. "+
+		"Warnings, mutes, roles waiting to be given back and the time you were last active are kept. React with ✅ within a minute to confirm, or ❌ to cancel.")
+	if err != nil {
+		logError("Failed to send privacy delete confirmation! " + err.Error())
+		return
+	}
+	pendingPrivacyDeletesLock.Lock()
+	for messageID, pending := range pendingPrivacyDeletes {
+		if time.Now().After(pending.Expires) {
+			delete(pendingPrivacyDeletes, messageID)
+		}
+	}
+	pendingPrivacyDeletes[message.ID] = pendingPrivacyDelete{ChannelID: m.ChannelID, UserID: m.Author.ID, Expires: time.Now().Add(privacyConfirmWindow)}
+	pendingPrivacyDeletesLock.Unlock()
+	for _, emoji := range []string{"✅", "❌"} {
+		err = s.MessageReactionAdd(m.ChannelID, message.ID, emoji)
+		if err != nil {
+			logError("Failed to add reaction to privacy delete confirmation! " + err.Error())
+		}
+	}
+	logSuccess("Sent privacy delete confirmation")
+}
+
+/**
+Deletes or keeps the member's data when the member who asked reacts to the confirmation.
+*/
+func confirmPrivacyDelete(s *discordgo.Session, m *discordgo.MessageReactionAdd) {
+	if m.Emoji.Name != "✅" && m.Emoji.Name != "❌" {
+		return
+	}
+	pendingPrivacyDeletesLock.Lock()
+	pending, ok := pendingPrivacyDeletes[m.MessageID]
+	if !ok || m.UserID != pending.UserID {
+		pendingPrivacyDeletesLock.Unlock()
+		return
+	}
+	delete(pendingPrivacyDeletes, m.MessageID)
+	pendingPrivacyDeletesLock.Unlock()
+
+	response := "Nothing was deleted."
+	switch {
+	case time.Now().After(pending.Expires):
+		response = "That confirmation expired. Use `~privacy delete` again."
+	case m.Emoji.Name == "✅":
+		response = "Your data has been deleted."
+		if failed := deletePrivacyData(pending.UserID); failed > 0 {
+			response = fmt.Sprintf("Some of your data couldn't be deleted (%d tables failed). Please try again in a moment.", failed)
+		}
+		if !isOptedOut(pending.UserID) {
+			response += " New activity will still be recorded unless you use `~privacy optout`."
+		}
+	}
+	_, err := s.ChannelMessageSend(pending.ChannelID, "<@"+pending.UserID+"> "+response)
+	if err != nil {
+		logError("Failed to send privacy delete result! " + err.Error())
+		return
+	}
+	logSuccess("Handled privacy delete confirmation")
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+/**
+Test anything that uses calculation outside of the discordgo commands.
+Those commands don't need to be tested since they are verified to work
+at github.com/bwmarrin/discordgo
+**/
+func TestPrivacy(t *testing.T) {
+	t.Run("Exports include every table, even empty ones", func(t *testing.T) {
+		data := map[string][]map[string]string{
+			"activity":    {{"member_id": "1", "member_name": "name#0001"}},
+			"leaderboard": {},
+		}
+		export, err := privacyExportJSON("1", data, time.Date(2021, 6, 30, 12, 0, 0, 0, time.UTC))
+		if err != nil {
+			t.Logf("Unexpected error %v", err)
+			t.Fail()
+			return
+		}
+		var decoded struct {
+			MemberID   string                         `json:"member_id"`
+			ExportedAt string                         `json:"exported_at"`
+			Data       map[string][]map[string]string `json:"data"`
+		}
+		err = json.Unmarshal(export, &decoded)
+		if err != nil || decoded.MemberID != "1" || decoded.ExportedAt != "2021-06-30T12:00:00Z" {
+			t.Logf("Unexpected export %s", export)
+			t.Fail()
+		}
+		if rows, ok := decoded.Data["leaderboard"]; !ok || rows == nil || len(decoded.Data["activity"]) != 1 {
+			t.Logf("Expected every table in the export, got %s", export)
+			t.Fail()
+		}
+	})
+
+	t.Run("Moderation records and the opt-out are never deleted", func(t *testing.T) {
+		for _, table := range privacyTables() {
+			switch table.Name {
+			case "warnings", "mutes", "inactive_roles", "privacy_optout", "invites_used":
+				if table.Deletable {
+					t.Logf("Expected %s to be kept", table.Name)
+					t.Fail()
+				}
+			case "activity", "activity_events", "leaderboard", "message_cache":
+				if !table.Deletable {
+					t.Logf("Expected %s to be deleted", table.Name)
+					t.Fail()
+				}
+			}
+		}
+	})
+}
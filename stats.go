@@ -0,0 +1,530 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// ActivityStats : activity in a guild or channel over a period, aggregated from activity events
+type ActivityStats struct {
+	Days       int
+	Members    int
+	Counts     map[string]int
+	Daily      []int
+	HourOfWeek [7][24]int
+}
+
+// DeadChannel : a text channel nobody has written in recently, and when someone last did
+type DeadChannel struct {
+	ChannelID   string
+	LastMessage time.Time
+}
+
+// how many days ~stats looks back when no period is given
+const defaultStatsDays = 30
+
+// chart layout, in pixels
+const (
+	chartWidth       = 720
+	chartTrendHeight = 200
+	chartGap         = 20
+	chartCellHeight  = 20
+)
+
+var chartBackground = color.RGBA{0x2f, 0x31, 0x36, 0xff}
+var chartForeground = color.RGBA{0x58, 0x65, 0xf2, 0xff}
+
+var weekdays = []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+
+/**
+Reads the number of events of each type and the number of distinct members matching the where clause.
+*/
+func getStatsCounts(where string) (map[string]int, int, error) {
+	counts := map[string]int{}
+	query, err := connection_pool.Query(fmt.Sprintf("SELECT event_type, COUNT(*) FROM %s WHERE (%s) GROUP BY event_type;", activityEventsTable, where))
+	if err != nil {
+		return counts, 0, err
+	}
+	defer query.Close()
+	for query.Next() {
+		var eventType string
+		var count int
+		err = query.Scan(&eventType, &count)
+		if err != nil {
+			return counts, 0, err
+		}
+		counts[eventType] = count
+	}
+	var members int
+	err = connection_pool.QueryRow(fmt.Sprintf("SELECT COUNT(DISTINCT member_id) FROM %s WHERE (%s AND event_type != '%s');", activityEventsTable, where, eventScan)).Scan(&members)
+	return counts, members, err
+}
+
+/**
+Reads the number of messages per day matching the where clause, keyed by date (YYYY-MM-DD).
+*/
+func getDailyMessages(where string) (map[string]int, error) {
+	daily := map[string]int{}
+	query, err := connection_pool.Query(fmt.Sprintf("SELECT DATE(created_at), COUNT(*) FROM %s WHERE (%s AND event_type = '%s') GROUP BY DATE(created_at);", activityEventsTable, where, eventMessage))
+	if err != nil {
+		return daily, err
+	}
+	defer query.Close()
+	for query.Next() {
+		var date string
+		var count int
+		err = query.Scan(&date, &count)
+		if err != nil {
+			return daily, err
+		}
+		if len(date) >= 10 {
+			daily[date[:10]] = count
+		}
+	}
+	return daily, nil
+}
+
+/**
+Reads the number of messages, reactions and voice joins matching the where clause by UTC weekday and hour.
+*/
+func getHourOfWeek(where string) ([7][24]int, error) {
+	var hours [7][24]int
+	query, err := connection_pool.Query(fmt.Sprintf("SELECT DAYOFWEEK(created_at) - 1, HOUR(created_at), COUNT(*) FROM %s WHERE (%s AND event_type IN ('%s', '%s', '%s')) GROUP BY 1, 2;",
+		activityEventsTable, where, eventMessage, eventReaction, eventVoiceJoin))
+	if err != nil {
+		return hours, err
+	}
+	defer query.Close()
+	for query.Next() {
+		var day, hour, count int
+		err = query.Scan(&day, &hour, &count)
+		if err != nil {
+			return hours, err
+		}
+		if day >= 0 && day < 7 && hour >= 0 && hour < 24 {
+			hours[day][hour] = count
+		}
+	}
+	return hours, nil
+}
+
+/**
+Reads when a message was last sent in each channel of the guild.
+*/
+func getLastMessages(guildID string) (map[string]time.Time, error) {
+	lastMessages := map[string]time.Time{}
+	query, err := connection_pool.Query(fmt.Sprintf("SELECT channel_id, MAX(created_at) FROM %s WHERE (guild_id = '%s' AND event_type = '%s') GROUP BY channel_id;", activityEventsTable, guildID, eventMessage))
+	if err != nil {
+		return lastMessages, err
+	}
+	defer query.Close()
+	for query.Next() {
+		var channelID, createdAt string
+		err = query.Scan(&channelID, &createdAt)
+		if err != nil {
+			return lastMessages, err
+		}
+		if lastMessages[channelID], err = parseSQLTimestamp(createdAt); err != nil {
+			return lastMessages, err
+		}
+	}
+	return lastMessages, nil
+}
+
+/**
+Reads the members who sent the most messages matching the where clause.
+*/
+func getTopPosters(where string, limit int) ([]string, error) {
+	var lines []string
+	query, err := connection_pool.Query(fmt.Sprintf("SELECT member_id, COUNT(*) FROM %s WHERE (%s AND event_type = '%s') GROUP BY member_id ORDER BY COUNT(*) DESC LIMIT %d;", activityEventsTable, where, eventMessage, limit))
+	if err != nil {
+		return lines, err
+	}
+	defer query.Close()
+	for query.Next() {
+		var memberID string
+		var count int
+		err = query.Scan(&memberID, &count)
+		if err != nil {
+			return lines, err
+		}
+		lines = append(lines, fmt.Sprintf("<@%s>: %d", memberID, count))
+	}
+	return lines, nil
+}
+
+/**
+Loads the stats for everything matching the where clause over the last number of days.
+*/
+func getActivityStats(where string, days int, now time.Time) (ActivityStats, error) {
+	since := now.AddDate(0, 0, -days)
+	where += fmt.Sprintf(" AND created_at >= '%s'", sqlTimestamp(since))
+	stats := ActivityStats{Days: days}
+	var err error
+	stats.Counts, stats.Members, err = getStatsCounts(where)
+	if err != nil {
+		return stats, err
+	}
+	daily, err := getDailyMessages(where)
+	if err != nil {
+		return stats, err
+	}
+	stats.Daily = fillDays(daily, since, days)
+	stats.HourOfWeek, err = getHourOfWeek(where)
+	return stats, err
+}
+
+/**
+Turns counts keyed by date into one count per day, oldest first, with 0 for days without any.
+*/
+func fillDays(daily map[string]int, since time.Time, days int) []int {
+	counts := make([]int, days)
+	for i := range counts {
+		counts[i] = daily[since.UTC().AddDate(0, 0, i+1).Format("2006-01-02")]
+	}
+	return counts
+}
+
+/**
+Returns the busiest hours of the week, e.g. "Sat 20:00 UTC (54)", busiest first.
+*/
+func busiestHours(hours [7][24]int, limit int) []string {
+	type slot struct{ day, hour, count int }
+	var slots []slot
+	for day := range hours {
+		for hour, count := range hours[day] {
+			if count > 0 {
+				slots = append(slots, slot{day, hour, count})
+			}
+		}
+	}
+	sort.SliceStable(slots, func(i, j int) bool { return slots[i].count > slots[j].count })
+	var busiest []string
+	for i := 0; i < limit && i < len(slots); i++ {
+		busiest = append(busiest, fmt.Sprintf("%s %02d:00 UTC (%d)", weekdays[slots[i].day], slots[i].hour, slots[i].count))
+	}
+	return busiest
+}
+
+/**
+Describes how the second half of the period compares to the first, e.g. "up 25%".
+*/
+func describeTrend(daily []int) string {
+	half := len(daily) / 2
+	before, after := 0, 0
+	for i, count := range daily {
+		if i < len(daily)-half {
+			before += count
+		} else {
+			after += count
+		}
+	}
+	// compare per-day averages, since an odd number of days splits unevenly
+	beforeDays, afterDays := len(daily)-half, half
+	if afterDays == 0 || before == 0 {
+		return "not enough data"
+	}
+	change := (float64(after)/float64(afterDays) - float64(before)/float64(beforeDays)) / (float64(before) / float64(beforeDays)) * 100
+	switch {
+	case change >= 1:
+		return fmt.Sprintf("up %.0f%%", change)
+	case change <= -1:
+		return fmt.Sprintf("down %.0f%%", -change)
+	}
+	return "steady"
+}
+
+/**
+Lists the text channels nobody has written in since the given time, never-used channels first,
+then the longest dead.
+*/
+func findDeadChannels(channels []*discordgo.Channel, lastMessages map[string]time.Time, since time.Time) []DeadChannel {
+	var dead []DeadChannel
+	for _, channel := range channels {
+		if channel.Type != discordgo.ChannelTypeGuildText {
+			continue
+		}
+		lastMessage := lastMessages[channel.ID]
+		if lastMessage.Before(since) {
+			dead = append(dead, DeadChannel{ChannelID: channel.ID, LastMessage: lastMessage})
+		}
+	}
+	sort.SliceStable(dead, func(i, j int) bool { return dead[i].LastMessage.Before(dead[j].LastMessage) })
+	return dead
+}
+
+/**
+Blends the chart background towards the foreground by the given fraction.
+*/
+func chartShade(fraction float64) color.RGBA {
+	blend := func(from uint8, to uint8) uint8 {
+		return uint8(float64(from) + (float64(to)-float64(from))*fraction)
+	}
+	return color.RGBA{blend(chartBackground.R, chartForeground.R), blend(chartBackground.G, chartForeground.G), blend(chartBackground.B, chartForeground.B), 0xff}
+}
+
+/**
+Draws a bar chart of the daily counts above a weekday-by-hour heatmap (Sunday at the top,
+midnight UTC on the left).
+*/
+func renderActivityChart(daily []int, hours [7][24]int) *image.RGBA {
+	height := chartTrendHeight + chartGap + 7*chartCellHeight
+	img := image.NewRGBA(image.Rect(0, 0, chartWidth, height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{chartBackground}, image.Point{}, draw.Src)
+
+	most := 0
+	for _, count := range daily {
+		if count > most {
+			most = count
+		}
+	}
+	if len(daily) > 0 && most > 0 {
+		barWidth := chartWidth / len(daily)
+		for i, count := range daily {
+			barHeight := count * chartTrendHeight / most
+			bar := image.Rect(i*barWidth+1, chartTrendHeight-barHeight, (i+1)*barWidth-1, chartTrendHeight)
+			draw.Draw(img, bar, &image.Uniform{chartForeground}, image.Point{}, draw.Src)
+		}
+	}
+
+	most = 0
+	for day := range hours {
+		for _, count := range hours[day] {
+			if count > most {
+				most = count
+			}
+		}
+	}
+	cellWidth := chartWidth / 24
+	top := chartTrendHeight + chartGap
+	for day := range hours {
+		for hour, count := range hours[day] {
+			fraction := 0.0
+			if most > 0 {
+				fraction = float64(count) / float64(most)
+			}
+			cell := image.Rect(hour*cellWidth+1, top+day*chartCellHeight+1, (hour+1)*cellWidth-1, top+(day+1)*chartCellHeight-1)
+			draw.Draw(img, cell, &image.Uniform{chartShade(0.1 + 0.9*fraction)}, image.Point{}, draw.Src)
+		}
+	}
+	return img
+}
+
+/**
+Encodes the chart as a PNG ready to attach to a message.
+*/
+func chartFile(img image.Image, name string) (*discordgo.File, error) {
+	var buffer bytes.Buffer
+	err := png.Encode(&buffer, img)
+	if err != nil {
+		return nil, err
+	}
+	return &discordgo.File{Name: name, ContentType: "image/png", Reader: &buffer}, nil
+}
+
+/**
+Parses a period such as "30d", "2w" or "30" into a number of days.
+*/
+func parseStatsDays(raw string) (int, error) {
+	if days, err := strconv.Atoi(raw); err == nil && days > 0 {
+		return days, nil
+	}
+	duration, err := parseDuration(raw)
+	if err != nil {
+		return 0, err
+	}
+	days := int(duration / (24 * time.Hour))
+	if days < 1 {
+		return 0, fmt.Errorf("a period must be at least a day")
+	}
+	return days, nil
+}
+
+/**
+Sends the stats as an embed with the chart attached.
+*/
+func sendActivityStats(s *discordgo.Session, m *discordgo.MessageCreate, embed *discordgo.MessageEmbed, stats ActivityStats) {
+	embed.Type = "rich"
+	embed.Fields = append([]*discordgo.MessageEmbedField{
+		createField("Active members", strconv.Itoa(stats.Members), true),
+		createField("Messages", strconv.Itoa(stats.Counts[eventMessage]), true),
+		createField("Reactions", strconv.Itoa(stats.Counts[eventReaction]), true),
+		createField("Voice joins", strconv.Itoa(stats.Counts[eventVoiceJoin]), true),
+		createField("Message trend", describeTrend(stats.Daily), true),
+	}, embed.Fields...)
+	busiest := busiestHours(stats.HourOfWeek, 5)
+	if len(busiest) == 0 {
+		busiest = []string{"No activity recorded."}
+	}
+	embed.Fields = append(embed.Fields, createField("Busiest hours", strings.Join(busiest, "\n"), false))
+	embed.Footer = &discordgo.MessageEmbedFooter{Text: fmt.Sprintf("Top: messages per day over the last %d days. Bottom: activity by weekday (Sun-Sat) and hour (00-23 UTC).", stats.Days)}
+
+	file, err := chartFile(renderActivityChart(stats.Daily, stats.HourOfWeek), "stats.png")
+	if err != nil {
+		logError("Unable to render stats chart! " + err.Error())
+	} else {
+		embed.Image = &discordgo.MessageEmbedImage{URL: "attachment://stats.png"}
+	}
+	send := &discordgo.MessageSend{Embed: embed}
+	if file != nil {
+		send.Files = []*discordgo.File{file}
+	}
+	_, err = s.ChannelMessageSendComplex(m.ChannelID, send)
+	if err != nil {
+		logError("Failed to send stats! " + err.Error())
+		return
+	}
+	logSuccess("Sent stats")
+}
+
+/**
+Reports whether the user can see the channel, so stats never reveal channels hidden from them.
+*/
+func canViewChannel(s *discordgo.Session, userID string, channelID string) bool {
+	perms, err := s.UserChannelPermissions(userID, channelID)
+	return err == nil && permissionsInclude(perms, discordgo.PermissionViewChannel)
+}
+
+/****
+COMMANDS
+****/
+
+/**
+Shows when the server is alive, how busy a channel is, or which channels are dead.
+**/
+func handleStats(s *discordgo.Session, m *discordgo.MessageCreate, command []string) {
+	logInfo(strings.Join(command, " "))
+	usage := "Usages: ```~stats server <period: optional, e.g. 30d>\n~stats channel <#channel> <period: optional>\n~stats dead-channels <period: optional>```"
+	if len(command) < 2 {
+		_, err := s.ChannelMessageSend(m.ChannelID, usage)
+		if err != nil {
+			logError("Failed to send usage message! " + err.Error())
+		}
+		return
+	}
+
+	args := command[2:]
+	channelID := ""
+	if command[1] == "channel" && len(args) > 0 && strings.HasPrefix(args[0], "<#") && strings.HasSuffix(args[0], ">") {
+		channelID = strings.TrimSuffix(strings.TrimPrefix(args[0], "<#"), ">")
+		args = args[1:]
+	}
+	if len(args) > 1 || (command[1] != "server" && command[1] != "dead-channels" && (command[1] != "channel" || channelID == "")) {
+		_, err := s.ChannelMessageSend(m.ChannelID, usage)
+		if err != nil {
+			logError("Failed to send usage message! " + err.Error())
+		}
+		return
+	}
+	days := defaultStatsDays
+	if len(args) == 1 {
+		var err error
+		days, err = parseStatsDays(args[0])
+		if err != nil {
+			_, err = s.ChannelMessageSend(m.ChannelID, "Please give the period as a number of days, e.g. `30d`.")
+			if err != nil {
+				logError("Failed to send invalid period message! " + err.Error())
+			}
+			return
+		}
+	}
+	if retention := getActivityRetention(m.GuildID); days > retention {
+		days = retention
+	}
+
+	now := time.Now()
+	var err error
+	switch command[1] {
+	case "server":
+		var stats ActivityStats
+		stats, err = getActivityStats(fmt.Sprintf("guild_id = '%s'", m.GuildID), days, now)
+		if err == nil {
+			sendActivityStats(s, m, &discordgo.MessageEmbed{Title: fmt.Sprintf("Server activity (last %d days)", days)}, stats)
+			return
+		}
+	case "channel":
+		if !canViewChannel(s, m.Author.ID, channelID) {
+			logWarning("User attempted to view stats for a channel they can't see")
+			_, err = s.ChannelMessageSend(m.ChannelID, "Sorry, you don't have the `View Channel` permission in that channel.")
+			if err != nil {
+				logError("Failed to send permissions message! " + err.Error())
+			}
+			return
+		}
+		where := fmt.Sprintf("guild_id = '%s' AND channel_id = '%s'", m.GuildID, channelID)
+		var stats ActivityStats
+		stats, err = getActivityStats(where, days, now)
+		if err == nil {
+			var top []string
+			top, err = getTopPosters(where+fmt.Sprintf(" AND created_at >= '%s'", sqlTimestamp(now.AddDate(0, 0, -days))), 5)
+			if err == nil {
+				embed := &discordgo.MessageEmbed{Title: fmt.Sprintf("Channel activity (last %d days)", days), Description: "<#" + channelID + ">"}
+				if len(top) > 0 {
+					embed.Fields = append(embed.Fields, createField("Top posters", strings.Join(top, "\n"), false))
+				}
+				sendActivityStats(s, m, embed, stats)
+				return
+			}
+		}
+	case "dead-channels":
+		var lastMessages map[string]time.Time
+		lastMessages, err = getLastMessages(m.GuildID)
+		if err == nil {
+			var channels []*discordgo.Channel
+			channels, err = s.GuildChannels(m.GuildID)
+			if err == nil {
+				var visible []*discordgo.Channel
+				for _, channel := range channels {
+					if canViewChannel(s, m.Author.ID, channel.ID) {
+						visible = append(visible, channel)
+					}
+				}
+				sendDeadChannels(s, m, findDeadChannels(visible, lastMessages, now.AddDate(0, 0, -days)), days)
+				return
+			}
+		}
+	}
+	logError("Unable to read activity stats! " + err.Error())
+	_, err = s.ChannelMessageSend(m.ChannelID, "An error occurred. Please try again in a moment.")
+	if err != nil {
+		logError("Failed to send error message! " + err.Error())
+	}
+}
+
+/**
+Lists the dead channels and when each was last written in.
+*/
+func sendDeadChannels(s *discordgo.Session, m *discordgo.MessageCreate, dead []DeadChannel, days int) {
+	var lines []string
+	for _, channel := range dead {
+		last := "no messages recorded"
+		if !channel.LastMessage.IsZero() {
+			last = "last message " + formatAge(time.Since(channel.LastMessage)) + " ago"
+		}
+		lines = append(lines, "<#"+channel.ChannelID+">: "+last)
+	}
+	var embed discordgo.MessageEmbed
+	embed.Type = "rich"
+	embed.Title = fmt.Sprintf("Channels with no messages in %d days", days)
+	embed.Description = "Every text channel has had a message recently."
+	if len(lines) > 0 {
+		embed.Description = truncateField(strings.Join(lines, "\n"), 4000)
+	}
+	embed.Footer = &discordgo.MessageEmbedFooter{Text: fmt.Sprintf("Activity history is kept for %d days.", getActivityRetention(m.GuildID))}
+	_, err := s.ChannelMessageSendEmbed(m.ChannelID, &embed)
+	if err != nil {
+		logError("Failed to send dead channels! " + err.Error())
+		return
+	}
+	logSuccess("Sent dead channels")
+}
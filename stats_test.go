@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+/**
+Test anything that uses calculation outside of the discordgo commands.
+Those commands don't need to be tested since they are verified to work
+at github.com/bwmarrin/discordgo
+**/
+func TestStats(t *testing.T) {
+	now := time.Date(2021, 6, 30, 12, 0, 0, 0, time.UTC)
+
+	t.Run("Missing days are filled with zero, oldest first", func(t *testing.T) {
+		daily := fillDays(map[string]int{"2021-06-28": 4, "2021-06-30": 7}, now.AddDate(0, 0, -3), 3)
+		if len(daily) != 3 || daily[0] != 4 || daily[1] != 0 || daily[2] != 7 {
+			t.Logf("Unexpected daily counts %v", daily)
+			t.Fail()
+		}
+	})
+
+	t.Run("Busiest hours are listed busiest first", func(t *testing.T) {
+		var hours [7][24]int
+		hours[6][20] = 54
+		hours[1][9] = 3
+		busiest := busiestHours(hours, 5)
+		if len(busiest) != 2 || busiest[0] != "Sat 20:00 UTC (54)" || busiest[1] != "Mon 09:00 UTC (3)" {
+			t.Logf("Unexpected busiest hours %v", busiest)
+			t.Fail()
+		}
+	})
+
+	t.Run("Trends compare the two halves of the period", func(t *testing.T) {
+		if trend := describeTrend([]int{2, 2, 3, 3}); trend != "up 50%" {
+			t.Logf("Expected up 50%%, got '%s'", trend)
+			t.Fail()
+		}
+		if trend := describeTrend([]int{4, 4, 2, 2}); trend != "down 50%" {
+			t.Logf("Expected down 50%%, got '%s'", trend)
+			t.Fail()
+		}
+		if trend := describeTrend([]int{0, 0, 2, 2}); trend != "not enough data" {
+			t.Logf("Expected not enough data, got '%s'", trend)
+			t.Fail()
+		}
+	})
+
+	t.Run("Dead channels are text channels without recent messages", func(t *testing.T) {
+		channels := []*discordgo.Channel{
+			{ID: "alive", Type: discordgo.ChannelTypeGuildText},
+			{ID: "old", Type: discordgo.ChannelTypeGuildText},
+			{ID: "never", Type: discordgo.ChannelTypeGuildText},
+			{ID: "voice", Type: discordgo.ChannelTypeGuildVoice},
+		}
+		lastMessages := map[string]time.Time{"alive": now.AddDate(0, 0, -1), "old": now.AddDate(0, 0, -40)}
+		dead := findDeadChannels(channels, lastMessages, now.AddDate(0, 0, -30))
+		if len(dead) != 2 || dead[0].ChannelID != "never" || dead[1].ChannelID != "old" {
+			t.Logf("Unexpected dead channels %v", dead)
+			t.Fail()
+		}
+	})
+
+	t.Run("Charts draw bars for busy days and shade busy hours", func(t *testing.T) {
+		var hours [7][24]int
+		hours[0][0] = 10
+		img := renderActivityChart([]int{0, 10}, hours)
+		if img.Bounds().Dx() != chartWidth {
+			t.Logf("Unexpected chart width %d", img.Bounds().Dx())
+			t.Fail()
+		}
+		if img.RGBAAt(chartWidth/4, chartTrendHeight-1) != chartBackground || img.RGBAAt(chartWidth*3/4, chartTrendHeight-1) != chartForeground {
+			t.Log("Expected only the second day to have a bar")
+			t.Fail()
+		}
+		top := chartTrendHeight + chartGap
+		if img.RGBAAt(5, top+5) != chartShade(1) || img.RGBAAt(35, top+5) != chartShade(0.1) {
+			t.Log("Expected the busiest hour to be fully shaded")
+			t.Fail()
+		}
+		if days, err := parseStatsDays("2w"); err != nil || days != 14 {
+			t.Logf("Expected 2w to be 14 days, got %d %v", days, err)
+			t.Fail()
+		}
+	})
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// VoiceSession : one stay in a voice channel. LeftAt is empty while the member is still connected.
+type VoiceSession struct {
+	ID        int
+	GuildID   string
+	MemberID  string
+	ChannelID string
+	JoinedAt  time.Time
+	LeftAt    time.Time
+}
+
+// VoiceTotal : a member's total time in voice
+type VoiceTotal struct {
+	MemberID string
+	Total    time.Duration
+}
+
+/**
+Returns how long the session lasted, or has lasted so far if it is still open.
+*/
+func (session VoiceSession) duration(now time.Time) time.Duration {
+	if session.LeftAt.IsZero() {
+		return now.Sub(session.JoinedAt)
+	}
+	return session.LeftAt.Sub(session.JoinedAt)
+}
+
+/**
+Returns how much of the session fell after the given time.
+*/
+func (session VoiceSession) durationSince(since time.Time, now time.Time) time.Duration {
+	if session.JoinedAt.Before(since) {
+		session.JoinedAt = since
+	}
+	if duration := session.duration(now); duration > 0 {
+		return duration
+	}
+	return 0
+}
+
+/**
+Returns true if voice activity counts toward the guild's inactivity tracking. It does unless
+the guild has turned it off.
+*/
+func voiceCountsAsActivity(guildID string) bool {
+	return getGuildSetting(guildID, "voice_activity") != "off"
+}
+
+/**
+Formats a duration as hours and minutes, e.g. 3h 05m.
+*/
+func formatVoiceTime(duration time.Duration) string {
+	minutes := int(duration.Minutes())
+	return fmt.Sprintf("%dh %02dm", minutes/60, minutes%60)
+}
+
+/**
+Reads voice sessions matching the WHERE clause.
+*/
+func queryVoiceSessions(where string) ([]VoiceSession, error) {
+	var sessions []VoiceSession
+	selectSQL := fmt.Sprintf("SELECT entry, guild_id, member_id, channel_id, joined_at, left_at FROM %s WHERE (%s) ORDER BY joined_at;", voiceSessionsTable, where)
+	query, err := connection_pool.Query(selectSQL)
+	if err != nil {
+		return sessions, err
+	}
+	defer query.Close()
+	for query.Next() {
+		var session VoiceSession
+		var joinedAt string
+		var leftAt sql.NullString
+		err = query.Scan(&session.ID, &session.GuildID, &session.MemberID, &session.ChannelID, &joinedAt, &leftAt)
+		if err != nil {
+			return sessions, err
+		}
+		session.JoinedAt, err = parseSQLTimestamp(joinedAt)
+		if err != nil {
+			return sessions, err
+		}
+		if leftAt.Valid {
+			session.LeftAt, err = parseSQLTimestamp(leftAt.String)
+			if err != nil {
+				return sessions, err
+			}
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+/**
+Returns the member's open voice session in the guild, or nil if they aren't connected.
+*/
+func getOpenVoiceSession(guildID string, memberID string) *VoiceSession {
+	sessions, err := queryVoiceSessions(fmt.Sprintf("guild_id = '%s' AND member_id = '%s' AND left_at IS NULL", guildID, memberID))
+	if err != nil {
+		logError("Unable to read voice sessions! " + err.Error())
+		return nil
+	}
+	if len(sessions) == 0 {
+		return nil
+	}
+	return &sessions[len(sessions)-1]
+}
+
+/**
+Starts a voice session for the member.
+*/
+func openVoiceSession(guildID string, memberID string, channelID string, joinedAt time.Time) {
+	insertSQL := fmt.Sprintf("INSERT INTO %s (guild_id, member_id, channel_id, joined_at) VALUES ('%s', '%s', '%s', '%s');",
+		voiceSessionsTable, guildID, memberID, channelID, sqlTimestamp(joinedAt))
+	queryWithoutResults(insertSQL, "Unable to open voice session!")
+}
+
+/**
+Ends a voice session, recording when it ended and how long it lasted in seconds.
+*/
+func closeVoiceSession(session VoiceSession, leftAt time.Time) {
+	updateSQL := fmt.Sprintf("UPDATE %s SET left_at = '%s', duration = %d WHERE (entry = %d);",
+		voiceSessionsTable, sqlTimestamp(leftAt), int(leftAt.Sub(session.JoinedAt).Seconds()), session.ID)
+	queryWithoutResults(updateSQL, "Unable to close voice session!")
+}
+
+/**
+Records joins, leaves and moves as voice sessions and posts them to the guild's voice log
+channel. Mute and deafen updates don't change the channel, so they are ignored.
+*/
+func trackVoiceSession(s *discordgo.Session, v *discordgo.VoiceStateUpdate) {
+	now := time.Now()
+	open := getOpenVoiceSession(v.GuildID, v.UserID)
+	if open != nil && open.ChannelID == v.ChannelID {
+		return
+	}
+	if open == nil && v.ChannelID == "" {
+		return
+	}
+
+	var event string
+	if open != nil {
+		closeVoiceSession(*open, now)
+	}
+	if v.ChannelID != "" {
+		openVoiceSession(v.GuildID, v.UserID, v.ChannelID, now)
+	}
+	switch {
+	case open == nil:
+		event = fmt.Sprintf(":green_circle: <@%s> joined <#%s>", v.UserID, v.ChannelID)
+	case v.ChannelID == "":
+		event = fmt.Sprintf(":red_circle: <@%s> left <#%s> after %s", v.UserID, open.ChannelID, formatVoiceTime(open.duration(now)))
+	default:
+		event = fmt.Sprintf(":arrow_right: <@%s> moved from <#%s> to <#%s> after %s", v.UserID, open.ChannelID, v.ChannelID, formatVoiceTime(open.duration(now)))
+	}
+
+	channelID := getGuildSetting(v.GuildID, "voicelog_channel")
+	if channelID == "" {
+		return
+	}
+	var embed discordgo.MessageEmbed
+	embed.Type = "rich"
+	embed.Description = event
+	embed.Timestamp = now.Format(time.RFC3339)
+	_, err := s.ChannelMessageSendEmbed(channelID, &embed)
+	if err != nil {
+		logError("Failed to send voice log message! " + err.Error())
+	}
+}
+
+/**
+Makes the open sessions match who is actually in voice when the bot joins a guild: sessions
+left open by a restart are closed, and members already connected get a session.
+*/
+func reconcileVoiceSessions(guild *discordgo.Guild) {
+	sessions, err := queryVoiceSessions(fmt.Sprintf("guild_id = '%s' AND left_at IS NULL", guild.ID))
+	if err != nil {
+		logError("Unable to read voice sessions! " + err.Error())
+		return
+	}
+	connected := map[string]string{}
+	for _, state := range guild.VoiceStates {
+		connected[state.UserID] = state.ChannelID
+	}
+	now := time.Now()
+	for _, session := range sessions {
+		if connected[session.MemberID] == session.ChannelID {
+			delete(connected, session.MemberID)
+			continue
+		}
+		// the bot can't know when they really left, so the session is recorded with no length rather than guessed
+		closeVoiceSession(session, session.JoinedAt)
+	}
+	for memberID, channelID := range connected {
+		openVoiceSession(guild.ID, memberID, channelID, now)
+	}
+}
+
+/**
+Returns the last time the member was in voice, and whether they ever were. Members who are
+connected right now count as active now.
+*/
+func lastVoiceActivity(guildID string, memberID string) (time.Time, bool) {
+	selectSQL := fmt.Sprintf("SELECT MAX(COALESCE(left_at, '%s')) FROM %s WHERE (guild_id = '%s' AND member_id = '%s');",
+		sqlTimestamp(time.Now()), voiceSessionsTable, guildID, memberID)
+	var lastSeen sql.NullString
+	err := connection_pool.QueryRow(selectSQL).Scan(&lastSeen)
+	if err != nil || !lastSeen.Valid {
+		return time.Time{}, false
+	}
+	parsed, err := parseSQLTimestamp(lastSeen.String)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return parsed, true
+}
+
+/**
+Totals the time spent in voice since the given time, overall and per channel.
+*/
+func summarizeVoiceSessions(sessions []VoiceSession, since time.Time, now time.Time) (time.Duration, map[string]time.Duration) {
+	var total time.Duration
+	byChannel := map[string]time.Duration{}
+	for _, session := range sessions {
+		duration := session.durationSince(since, now)
+		total += duration
+		byChannel[session.ChannelID] += duration
+	}
+	return total, byChannel
+}
+
+/**
+Ranks members by time spent in voice since the given time, longest first.
+*/
+func rankVoiceTime(sessions []VoiceSession, since time.Time, now time.Time) []VoiceTotal {
+	totals := map[string]time.Duration{}
+	for _, session := range sessions {
+		totals[session.MemberID] += session.durationSince(since, now)
+	}
+	var ranked []VoiceTotal
+	for memberID, total := range totals {
+		if total > 0 {
+			ranked = append(ranked, VoiceTotal{memberID, total})
+		}
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Total == ranked[j].Total {
+			return ranked[i].MemberID < ranked[j].MemberID
+		}
+		return ranked[i].Total > ranked[j].Total
+	})
+	return ranked
+}
+
+/****
+COMMANDS
+****/
+
+/**
+Voice time statistics and settings.
+**/
+func handleVoice(s *discordgo.Session, m *discordgo.MessageCreate, command []string) {
+	logInfo(strings.Join(command, " "))
+	usage := "Usages: ```~voice stats @user\n~voice top (optional: week/month)\n~voice log <#channel/off>\n~voice activity on/off```"
+	if len(command) < 2 {
+		_, err := s.ChannelMessageSend(m.ChannelID, usage)
+		if err != nil {
+			logError("Failed to send usage message! " + err.Error())
+		}
+		return
+	}
+
+	switch command[1] {
+	case "stats":
+		if len(command) != 3 || len(m.Mentions) != 1 {
+			break
+		}
+		sendVoiceStats(s, m, m.Mentions[0])
+		return
+	case "top":
+		if len(command) > 3 || (len(command) == 3 && command[2] != "week" && command[2] != "month") {
+			break
+		}
+		period := ""
+		if len(command) == 3 {
+			period = command[2]
+		}
+		sendVoiceTop(s, m, period)
+		return
+	case "log", "activity":
+		if len(command) != 3 {
+			break
+		}
+		if !userHasValidPermissions(s, m, discordgo.PermissionManageServer) {
+			logWarning("User attempted to change voice settings without proper permissions")
+			_, err := s.ChannelMessageSend(m.ChannelID, "Sorry, you don't have the `Manage Server` permission.")
+			if err != nil {
+				logError("Failed to send permissions message! " + err.Error())
+			}
+			return
+		}
+		var saved bool
+		var response string
+		switch {
+		case command[1] == "log" && command[2] == "off":
+			saved = deleteGuildSetting(m.GuildID, "voicelog_channel")
+			response = "Voice activity will no longer be logged."
+		case command[1] == "log" && strings.HasPrefix(command[2], "<#") && strings.HasSuffix(command[2], ">"):
+			channelID := strings.TrimSuffix(strings.TrimPrefix(command[2], "<#"), ">")
+			saved = setGuildSetting(m.GuildID, "voicelog_channel", channelID)
+			response = "Voice joins, leaves and moves will now be logged in <#" + channelID + ">."
+		case command[1] == "activity" && (command[2] == "on" || command[2] == "off"):
+			saved = setGuildSetting(m.GuildID, "voice_activity", command[2])
+			response = "Time in voice now counts as activity, so voice-only members won't be auto-kicked."
+			if command[2] == "off" {
+				response = "Time in voice no longer counts as activity."
+			}
+		default:
+			_, err := s.ChannelMessageSend(m.ChannelID, usage)
+			if err != nil {
+				logError("Failed to send usage message! " + err.Error())
+			}
+			return
+		}
+		if !saved {
+			response = "An error occurred. Please try again in a moment."
+		}
+		_, err := s.ChannelMessageSend(m.ChannelID, response)
+		if err != nil {
+			logError("Failed to send voice settings message! " + err.Error())
+			return
+		}
+		logSuccess("Updated voice settings")
+		return
+	}
+	_, err := s.ChannelMessageSend(m.ChannelID, usage)
+	if err != nil {
+		logError("Failed to send usage message! " + err.Error())
+	}
+}
+
+/**
+Sends the user's voice time for the last week, month and all time, and their most used channels.
+*/
+func sendVoiceStats(s *discordgo.Session, m *discordgo.MessageCreate, user *discordgo.User) {
+	sessions, err := queryVoiceSessions(fmt.Sprintf("guild_id = '%s' AND member_id = '%s'", m.GuildID, user.ID))
+	if err != nil {
+		logError("Unable to read voice sessions! " + err.Error())
+		_, err = s.ChannelMessageSend(m.ChannelID, "An error occurred. Please try again in a moment.")
+		if err != nil {
+			logError("Failed to send error message! " + err.Error())
+		}
+		return
+	}
+
+	now := time.Now()
+	week, _ := summarizeVoiceSessions(sessions, now.AddDate(0, 0, -7), now)
+	month, _ := summarizeVoiceSessions(sessions, now.AddDate(0, -1, 0), now)
+	allTime, byChannel := summarizeVoiceSessions(sessions, time.Time{}, now)
+	var channels []string
+	for channelID := range byChannel {
+		channels = append(channels, channelID)
+	}
+	sort.Slice(channels, func(i, j int) bool { return byChannel[channels[i]] > byChannel[channels[j]] })
+	var topChannels []string
+	for i, channelID := range channels {
+		if i == 5 {
+			break
+		}
+		topChannels = append(topChannels, fmt.Sprintf("<#%s>: %s", channelID, formatVoiceTime(byChannel[channelID])))
+	}
+	if len(topChannels) == 0 {
+		topChannels = append(topChannels, "None yet")
+	}
+
+	var embed discordgo.MessageEmbed
+	embed.Type = "rich"
+	embed.Title = "Voice stats for " + user.Username + "#" + user.Discriminator
+	embed.Thumbnail = &discordgo.MessageEmbedThumbnail{URL: user.AvatarURL("")}
+	embed.Fields = []*discordgo.MessageEmbedField{
+		createField("Last 7 days", formatVoiceTime(week), true),
+		createField("Last month", formatVoiceTime(month), true),
+		createField("All time", formatVoiceTime(allTime), true),
+		createField("Sessions", fmt.Sprintf("%d", len(sessions)), true),
+		createField("Top channels", strings.Join(topChannels, "\n"), false),
+	}
+	_, err = s.ChannelMessageSendEmbed(m.ChannelID, &embed)
+	if err != nil {
+		logError("Failed to send voice stats! " + err.Error())
+		return
+	}
+	logSuccess("Sent voice stats")
+}
+
+/**
+Sends the 10 members who have spent the most time in voice during the period (week, month or all time).
+*/
+func sendVoiceTop(s *discordgo.Session, m *discordgo.MessageCreate, period string) {
+	now := time.Now()
+	since := time.Time{}
+	title := "Most time in voice"
+	where := fmt.Sprintf("guild_id = '%s'", m.GuildID)
+	switch period {
+	case "week":
+		since = now.AddDate(0, 0, -7)
+		title += " this week"
+	case "month":
+		since = now.AddDate(0, -1, 0)
+		title += " this month"
+	}
+	if !since.IsZero() {
+		where += fmt.Sprintf(" AND (left_at IS NULL OR left_at >= '%s')", sqlTimestamp(since))
+	}
+	sessions, err := queryVoiceSessions(where)
+	if err != nil {
+		logError("Unable to read voice sessions! " + err.Error())
+		_, err = s.ChannelMessageSend(m.ChannelID, "An error occurred. Please try again in a moment.")
+		if err != nil {
+			logError("Failed to send error message! " + err.Error())
+		}
+		return
+	}
+
+	var lines []string
+	for i, total := range rankVoiceTime(sessions, since, now) {
+		if i == 10 {
+			break
+		}
+		lines = append(lines, fmt.Sprintf("**%d.** <@%s>: %s", i+1, total.MemberID, formatVoiceTime(total.Total)))
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "Nobody has been in voice yet.")
+	}
+	var embed discordgo.MessageEmbed
+	embed.Type = "rich"
+	embed.Title = title
+	embed.Description = strings.Join(lines, "\n")
+	_, err = s.ChannelMessageSendEmbed(m.ChannelID, &embed)
+	if err != nil {
+		logError("Failed to send voice leaderboard! " + err.Error())
+		return
+	}
+	logSuccess("Sent voice leaderboard")
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+/**
+Test anything that uses calculation outside of the discordgo commands.
+Those commands don't need to be tested since they are verified to work
+at github.com/bwmarrin/discordgo
+**/
+func TestVoice(t *testing.T) {
+	now := time.Date(2021, 5, 10, 12, 0, 0, 0, time.UTC)
+	sessions := []VoiceSession{
+		{MemberID: "1", ChannelID: "a", JoinedAt: now.AddDate(0, 0, -10), LeftAt: now.AddDate(0, 0, -10).Add(2 * time.Hour)},
+		{MemberID: "1", ChannelID: "b", JoinedAt: now.AddDate(0, 0, -7).Add(-time.Hour), LeftAt: now.AddDate(0, 0, -7).Add(time.Hour)},
+		{MemberID: "2", ChannelID: "a", JoinedAt: now.Add(-30 * time.Minute)},
+	}
+
+	t.Run("Sessions are clipped to the period", func(t *testing.T) {
+		total, byChannel := summarizeVoiceSessions(sessions[:2], now.AddDate(0, 0, -7), now)
+		if total != time.Hour || byChannel["a"] != 0 || byChannel["b"] != time.Hour {
+			t.Logf("Expected 1h in channel b, got %s (%v)", total, byChannel)
+			t.Fail()
+		}
+	})
+
+	t.Run("Open sessions count up to now", func(t *testing.T) {
+		if duration := sessions[2].duration(now); duration != 30*time.Minute {
+			t.Logf("Expected 30m, got %s", duration)
+			t.Fail()
+		}
+	})
+
+	t.Run("Members are ranked by time in voice", func(t *testing.T) {
+		ranked := rankVoiceTime(sessions, now.AddDate(0, 0, -7), now)
+		if len(ranked) != 2 || ranked[0].MemberID != "1" || ranked[1].MemberID != "2" || formatVoiceTime(ranked[1].Total) != "0h 30m" {
+			t.Logf("Ranked members incorrectly: %v", ranked)
+			t.Fail()
+		}
+	})
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+type Warning struct {
+	ID          int    `json:"entry"`
+	GuildID     string `json:"guild_id"`
+	MemberID    string `json:"member_id"`
+	ModeratorID string `json:"moderator_id"`
+	Reason      string `json:"reason"`
+	WarnedAt    string `json:"warned_at"`
+}
+
+type EscalationRule struct {
+	ID       int    `json:"entry"`
+	GuildID  string `json:"guild_id"`
+	Warnings int    `json:"warning_count"`
+	Days     int    `json:"days"`
+	Action   string `json:"action"`
+	Duration string `json:"duration"`
+}
+
+/**
+Records a warning against the user, DMs them the reason, and runs the guild's escalation
+rules. Returns a description of any escalation that was applied.
+*/
+func warnMember(s *discordgo.Session, guildID string, userID string, moderator *discordgo.User, reason string) (string, error) {
+	insertSQL := fmt.Sprintf("INSERT INTO %s (guild_id, member_id, moderator_id, reason, warned_at) VALUES ('%s', '%s', '%s', '%s', '%s');",
+		warningsTable, guildID, userID, moderator.ID, escapeSQL(reason), sqlTimestamp(time.Now()))
+	if !queryWithoutResults(insertSQL, "Unable to insert new warning!") {
+		return "", fmt.Errorf("unable to save the warning")
+	}
+
+	dmUser(s, userID, fmt.Sprintf("You have been warned in **%s** by %s#%s because: %s\n", getGuildName(s, guildID), moderator.Username, moderator.Discriminator, reason))
+
+	warnings, err := getWarnings(guildID, userID)
+	if err != nil {
+		return "", err
+	}
+	rules, err := getEscalationRules(guildID)
+	if err != nil {
+		return "", err
+	}
+	var warnedAt []time.Time
+	for _, warning := range warnings {
+		timestamp, err := parseSQLTimestamp(warning.WarnedAt)
+		if err != nil {
+			logWarning("Skipping warning with unreadable timestamp. " + err.Error())
+			continue
+		}
+		warnedAt = append(warnedAt, timestamp)
+	}
+
+	rule := matchEscalationRule(rules, warnedAt, time.Now())
+	if rule == nil {
+		return "", nil
+	}
+	return applyEscalation(s, guildID, userID, *rule)
+}
+
+/**
+Returns every warning the user has received in the guild, oldest first.
+*/
+func getWarnings(guildID string, userID string) ([]Warning, error) {
+	var warnings []Warning
+	selectSQL := fmt.Sprintf("SELECT * FROM %s WHERE (guild_id = '%s' AND member_id = '%s') ORDER BY entry;", warningsTable, guildID, userID)
+	query, err := connection_pool.Query(selectSQL)
+	if err != nil {
+		logError("SELECT query error: " + err.Error())
+		return warnings, err
+	}
+	defer query.Close()
+
+	for query.Next() {
+		var warning Warning
+		err = query.Scan(&warning.ID, &warning.GuildID, &warning.MemberID, &warning.ModeratorID, &warning.Reason, &warning.WarnedAt)
+		if err != nil {
+			logError("Unable to parse database information! Aborting. " + err.Error())
+			return warnings, err
+		}
+		warnings = append(warnings, warning)
+	}
+	return warnings, nil
+}
+
+/**
+Returns the guild's escalation rules, sorted by warning count.
+*/
+func getEscalationRules(guildID string) ([]EscalationRule, error) {
+	var rules []EscalationRule
+	selectSQL := fmt.Sprintf("SELECT * FROM %s WHERE (guild_id = '%s');", escalationTable, guildID)
+	query, err := connection_pool.Query(selectSQL)
+	if err != nil {
+		logError("SELECT query error: " + err.Error())
+		return rules, err
+	}
+	defer query.Close()
+
+	for query.Next() {
+		var rule EscalationRule
+		err = query.Scan(&rule.ID, &rule.GuildID, &rule.Warnings, &rule.Days, &rule.Action, &rule.Duration)
+		if err != nil {
+			logError("Unable to parse database information! Aborting. " + err.Error())
+			return rules, err
+		}
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].Warnings < rules[j].Warnings
+	})
+	return rules, nil
+}
+
+/**
+Picks the strictest rule the user has just reached. A rule is reached when the number of warnings
+within its window (or ever, if the window is 0 days) equals its warning count, so it fires once
+when the threshold is crossed rather than again on every later warning.
+*/
+func matchEscalationRule(rules []EscalationRule, warnedAt []time.Time, now time.Time) *EscalationRule {
+	var matched *EscalationRule
+	for i, rule := range rules {
+		count := 0
+		for _, timestamp := range warnedAt {
+			if rule.Days < 1 || timestamp.After(now.AddDate(0, 0, -rule.Days)) {
+				count++
+			}
+		}
+		if count == rule.Warnings && (matched == nil || rule.Warnings > matched.Warnings) {
+			matched = &rules[i]
+		}
+	}
+	return matched
+}
+
+/**
+Performs the rule's action on the user through the same paths as the manual commands.
+*/
+func applyEscalation(s *discordgo.Session, guildID string, userID string, rule EscalationRule) (string, error) {
+	reason := fmt.Sprintf("Reached %d warnings", rule.Warnings)
+	if rule.Days > 0 {
+		reason += fmt.Sprintf(" within %d days", rule.Days)
+	}
+	botName := s.State.User.Username + "#" + s.State.User.Discriminator
+
+	switch rule.Action {
+	case "mute":
+		duration, err := parseDuration(rule.Duration)
+		if err != nil {
+			return "", err
+		}
+		err = muteMember(s, guildID, userID, duration, s.State.User, reason)
+		if err != nil {
+			return "", err
+		}
+		return "muted for " + duration.String() + " (" + reason + ")", nil
+	case "kick":
+		err := kickMember(s, guildID, userID, botName, reason)
+		if err != nil {
+			return "", err
+		}
+		return "kicked (" + reason + ")", nil
+	case "ban":
+		err := banMember(s, guildID, userID, botName, reason)
+		if err != nil {
+			return "", err
+		}
+		return "banned (" + reason + ")", nil
+	}
+	return "", fmt.Errorf("unknown escalation action '%s'", rule.Action)
+}
+
+/**
+Formats a rule for display, e.g. "3 warnings in 30 days → kick".
+*/
+func describeEscalationRule(rule EscalationRule) string {
+	description := fmt.Sprintf("%d warnings", rule.Warnings)
+	if rule.Days > 0 {
+		description += fmt.Sprintf(" in %d days", rule.Days)
+	}
+	description += " → " + rule.Action
+	if rule.Action == "mute" {
+		description += " for " + rule.Duration
+	}
+	return description
+}
+
+/****
+COMMANDS
+****/
+
+/**
+Warns a user if the invoking user has the permission to kick users.
+**/
+func handleWarn(s *discordgo.Session, m *discordgo.MessageCreate, command []string) {
+	if !userHasValidPermissions(s, m, discordgo.PermissionKickMembers) {
+		logWarning("User attempted to use warn without proper permissions")
+		_, err := s.ChannelMessageSend(m.ChannelID, "Sorry, you aren't allowed to warn users.")
+		if err != nil {
+			logError("Failed to send permissions message! " + err.Error())
+		}
+		return
+	}
+	attemptWarn(s, m, command)
+}
+
+/**
+A helper function for handleWarn. Ensures the user targeted a user using @ and gave a reason;
+if they did, warn the specified user.
+**/
+func attemptWarn(s *discordgo.Session, m *discordgo.MessageCreate, command []string) {
+	logInfo(strings.Join(command, " "))
+	regex := regexp.MustCompile(`^\<\@\!?[0-9]+\>$`)
+	if len(command) < 3 || !regex.MatchString(command[1]) {
+		_, err := s.ChannelMessageSend(m.ChannelID, "Usage: `~warn @<user> <reason>`")
+		if err != nil {
+			logError("Failed to send usage message! " + err.Error())
+		}
+		return
+	}
+	userID := stripUserID(command[1])
+	reason := strings.Join(command[2:], " ")
+
+	escalation, err := warnMember(s, m.GuildID, userID, m.Author, reason)
+	if err != nil {
+		logError("Failed to warn user! " + err.Error())
+		_, err = s.ChannelMessageSend(m.ChannelID, "Failed to warn the user.")
+		if err != nil {
+			logWarning("Failed to send failure message! " + err.Error())
+		}
+		return
+	}
+
+	response := ":warning: Warned " + command[1] + " for the following reason: '" + reason + "'."
+	if escalation != "" {
+		response += "\nThey have been automatically " + escalation + "."
+	}
+	_, err = s.ChannelMessageSend(m.ChannelID, response)
+	if err != nil {
+		logWarning("Failed to send success message! " + err.Error())
+		return
+	}
+	logSuccess("Warned user")
+}
+
+/**
+Lists the warnings a user has received in the guild.
+**/
+func handleWarnings(s *discordgo.Session, m *discordgo.MessageCreate, command []string) {
+	logInfo(strings.Join(command, " "))
+	if !userHasValidPermissions(s, m, discordgo.PermissionKickMembers) {
+		logWarning("User attempted to list warnings without proper permissions")
+		_, err := s.ChannelMessageSend(m.ChannelID, "Sorry, you aren't allowed to view warnings.")
+		if err != nil {
+			logError("Failed to send permissions message! " + err.Error())
+		}
+		return
+	}
+	regex := regexp.MustCompile(`^\<\@\!?[0-9]+\>$`)
+	if len(command) != 2 || !regex.MatchString(command[1]) {
+		_, err := s.ChannelMessageSend(m.ChannelID, "Usage: `~warnings @<user>`")
+		if err != nil {
+			logError("Failed to send usage message! " + err.Error())
+		}
+		return
+	}
+	userID := stripUserID(command[1])
+
+	warnings, err := getWarnings(m.GuildID, userID)
+	if err != nil {
+		_, err = s.ChannelMessageSend(m.ChannelID, "An error occurred. Please try again in a moment.")
+		if err != nil {
+			logError("Failed to send error message! " + err.Error())
+		}
+		return
+	}
+
+	if len(warnings) == 0 {
+		_, err = s.ChannelMessageSend(m.ChannelID, "That user has no warnings.")
+		if err != nil {
+			logError("Failed to send 'no warnings' message! " + err.Error())
+			return
+		}
+		logSuccess("Returned that the user had no warnings")
+		return
+	}
+
+	var embed discordgo.MessageEmbed
+	embed.Type = "rich"
+	embed.Title = fmt.Sprintf("Warnings (%d)", len(warnings))
+	embed.Description = "<@" + userID + ">"
+
+	// embeds can only hold 25 fields, so only show the most recent warnings
+	if len(warnings) > 25 {
+		warnings = warnings[len(warnings)-25:]
+	}
+	var contents []*discordgo.MessageEmbedField
+	for _, warning := range warnings {
+		warnedAt := warning.WarnedAt
+		timestamp, err := parseSQLTimestamp(warning.WarnedAt)
+		if err == nil {
+			warnedAt = timestamp.Format("01/02/2006 15:04:05")
+		}
+		title := fmt.Sprintf("#%d - %s", warning.ID, warnedAt)
+		contents = append(contents, createField(title, warning.Reason+"\n- Warned by <@"+warning.ModeratorID+">", false))
+	}
+	embed.Fields = contents
+
+	_, err = s.ChannelMessageSendEmbed(m.ChannelID, &embed)
+	if err != nil {
+		logError("Failed to send warnings message! " + err.Error())
+		return
+	}
+	logSuccess("Sent user's warnings")
+}
+
+/**
+Removes all of a user's warnings, or a single warning if its number is given.
+**/
+func handleClearWarn(s *discordgo.Session, m *discordgo.MessageCreate, command []string) {
+	logInfo(strings.Join(command, " "))
+	if !userHasValidPermissions(s, m, discordgo.PermissionKickMembers) {
+		logWarning("User attempted to clear warnings without proper permissions")
+		_, err := s.ChannelMessageSend(m.ChannelID, "Sorry, you aren't allowed to clear warnings.")
+		if err != nil {
+			logError("Failed to send permissions message! " + err.Error())
+		}
+		return
+	}
+	regex := regexp.MustCompile(`^\<\@\!?[0-9]+\>$`)
+	if (len(command) != 2 && len(command) != 3) || !regex.MatchString(command[1]) {
+		_, err := s.ChannelMessageSend(m.ChannelID, "Usage: `~clearwarn @<user> (warning number: optional)`")
+		if err != nil {
+			logError("Failed to send usage message! " + err.Error())
+		}
+		return
+	}
+	userID := stripUserID(command[1])
+
+	deleteSQL := fmt.Sprintf("DELETE FROM %s WHERE (guild_id = '%s' AND member_id = '%s');", warningsTable, m.GuildID, userID)
+	response := "Cleared all warnings for " + command[1] + "."
+	if len(command) == 3 {
+		warningID, err := strconv.Atoi(strings.TrimPrefix(command[2], "#"))
+		if err != nil {
+			_, err = s.ChannelMessageSend(m.ChannelID, "Please input a valid warning number.")
+			if err != nil {
+				logError("Failed to send 'invalid number' message! " + err.Error())
+			}
+			return
+		}
+		deleteSQL = fmt.Sprintf("DELETE FROM %s WHERE (guild_id = '%s' AND member_id = '%s' AND entry = %d);", warningsTable, m.GuildID, userID, warningID)
+		response = fmt.Sprintf("Removed warning #%d from %s, if it existed.", warningID, command[1])
+	}
+
+	if !queryWithoutResults(deleteSQL, "Unable to delete warnings!") {
+		_, err := s.ChannelMessageSend(m.ChannelID, "An error occurred. Please try again in a moment.")
+		if err != nil {
+			logError("Failed to send error message! " + err.Error())
+		}
+		return
+	}
+	_, err := s.ChannelMessageSend(m.ChannelID, response)
+	if err != nil {
+		logError("Failed to send clearwarn result message! " + err.Error())
+		return
+	}
+	logSuccess("Cleared user's warnings")
+}
+
+/**
+Lets admins view and change what happens automatically when a user collects warnings.
+**/
+func handleEscalation(s *discordgo.Session, m *discordgo.MessageCreate, command []string) {
+	logInfo(strings.Join(command, " "))
+	if !userHasValidPermissions(s, m, discordgo.PermissionManageServer) {
+		logWarning("User attempted to change escalation rules without proper permissions")
+		_, err := s.ChannelMessageSend(m.ChannelID, "Sorry, you don't have the `Manage Server` permission.")
+		if err != nil {
+			logError("Failed to send permissions message! " + err.Error())
+		}
+		return
+	}
+	usage := "Usages: ```~escalation list\n~escalation add <number of warnings> <days (0 for all time)> <kick/ban/mute> (mute duration)\n~escalation remove <number of warnings>```"
+	if len(command) < 2 {
+		_, err := s.ChannelMessageSend(m.ChannelID, usage)
+		if err != nil {
+			logError("Failed to send usage message! " + err.Error())
+		}
+		return
+	}
+
+	switch command[1] {
+	case "list":
+		rules, err := getEscalationRules(m.GuildID)
+		if err != nil {
+			_, err = s.ChannelMessageSend(m.ChannelID, "An error occurred. Please try again in a moment.")
+			if err != nil {
+				logError("Failed to send error message! " + err.Error())
+			}
+			return
+		}
+		if len(rules) == 0 {
+			_, err = s.ChannelMessageSend(m.ChannelID, "This server has no escalation rules.")
+			if err != nil {
+				logError("Failed to send 'no rules' message! " + err.Error())
+			}
+			return
+		}
+		var lines []string
+		for _, rule := range rules {
+			lines = append(lines, "- "+describeEscalationRule(rule))
+		}
+		var embed discordgo.MessageEmbed
+		embed.Type = "rich"
+		embed.Title = "Warning Escalation Rules"
+		embed.Description = strings.Join(lines, "\n")
+		_, err = s.ChannelMessageSendEmbed(m.ChannelID, &embed)
+		if err != nil {
+			logError("Failed to send escalation rules message! " + err.Error())
+			return
+		}
+		logSuccess("Sent escalation rules")
+	case "add":
+		if len(command) != 5 && len(command) != 6 {
+			_, err := s.ChannelMessageSend(m.ChannelID, usage)
+			if err != nil {
+				logError("Failed to send usage message! " + err.Error())
+			}
+			return
+		}
+		warningCount, err := strconv.Atoi(command[2])
+		if err != nil || warningCount < 1 {
+			_, err = s.ChannelMessageSend(m.ChannelID, "Please input a valid number of warnings.")
+			if err != nil {
+				logError("Failed to send 'invalid number' message! " + err.Error())
+			}
+			return
+		}
+		days, err := strconv.Atoi(command[3])
+		if err != nil || days < 0 {
+			_, err = s.ChannelMessageSend(m.ChannelID, "Please input a valid number of days.")
+			if err != nil {
+				logError("Failed to send 'invalid number' message! " + err.Error())
+			}
+			return
+		}
+		action := strings.ToLower(command[4])
+		if action != "kick" && action != "ban" && action != "mute" {
+			_, err = s.ChannelMessageSend(m.ChannelID, "The action must be `kick`, `ban` or `mute`.")
+			if err != nil {
+				logError("Failed to send invalid action message! " + err.Error())
+			}
+			return
+		}
+		duration := ""
+		if action == "mute" {
+			if len(command) == 6 {
+				duration = command[5]
+			}
+			if parsed, err := parseDuration(duration); err != nil || parsed <= 0 {
+				_, err = s.ChannelMessageSend(m.ChannelID, "Mute rules need a valid duration, e.g. `30m`, `12h` or `2d`.")
+				if err != nil {
+					logError("Failed to send invalid duration message! " + err.Error())
+				}
+				return
+			}
+		}
+
+		// replace any existing rule for the same number of warnings
+		deleteSQL := fmt.Sprintf("DELETE FROM %s WHERE (guild_id = '%s' AND warning_count = %d);", escalationTable, m.GuildID, warningCount)
+		insertSQL := fmt.Sprintf("INSERT INTO %s (guild_id, warning_count, days, action, duration) VALUES ('%s', %d, %d, '%s', '%s');", escalationTable, m.GuildID, warningCount, days, action, duration)
+		if !queryWithoutResults(deleteSQL, "Unable to delete old escalation rule!") || !queryWithoutResults(insertSQL, "Unable to insert escalation rule!") {
+			_, err = s.ChannelMessageSend(m.ChannelID, "An error occurred. Please try again in a moment.")
+			if err != nil {
+				logError("Failed to send error message! " + err.Error())
+			}
+			return
+		}
+		_, err = s.ChannelMessageSend(m.ChannelID, "Added escalation rule: "+describeEscalationRule(EscalationRule{Warnings: warningCount, Days: days, Action: action, Duration: duration}))
+		if err != nil {
+			logError("Failed to send escalation result message! " + err.Error())
+			return
+		}
+		logSuccess("Added escalation rule")
+	case "remove":
+		if len(command) != 3 {
+			_, err := s.ChannelMessageSend(m.ChannelID, usage)
+			if err != nil {
+				logError("Failed to send usage message! " + err.Error())
+			}
+			return
+		}
+		warningCount, err := strconv.Atoi(command[2])
+		if err != nil {
+			_, err = s.ChannelMessageSend(m.ChannelID, "Please input a valid number of warnings.")
+			if err != nil {
+				logError("Failed to send 'invalid number' message! " + err.Error())
+			}
+			return
+		}
+		deleteSQL := fmt.Sprintf("DELETE FROM %s WHERE (guild_id = '%s' AND warning_count = %d);", escalationTable, m.GuildID, warningCount)
+		if !queryWithoutResults(deleteSQL, "Unable to delete escalation rule!") {
+			_, err = s.ChannelMessageSend(m.ChannelID, "An error occurred. Please try again in a moment.")
+			if err != nil {
+				logError("Failed to send error message! " + err.Error())
+			}
+			return
+		}
+		_, err = s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Removed the rule for %d warnings, if there was one.", warningCount))
+		if err != nil {
+			logError("Failed to send escalation result message! " + err.Error())
+			return
+		}
+		logSuccess("Removed escalation rule")
+	default:
+		_, err := s.ChannelMessageSend(m.ChannelID, usage)
+		if err != nil {
+			logError("Failed to send usage message! " + err.Error())
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+/**
+Test anything that uses calculation outside of the discordgo commands.
+Those commands don't need to be tested since they are verified to work
+at github.com/bwmarrin/discordgo
+**/
+func TestWarnings(t *testing.T) {
+	now := time.Now()
+	rules := []EscalationRule{
+		{Warnings: 3, Days: 30, Action: "kick"},
+		{Warnings: 5, Days: 0, Action: "ban"},
+	}
+
+	t.Run("No rule is reached below the lowest threshold", func(t *testing.T) {
+		warnedAt := []time.Time{now, now.AddDate(0, 0, -1)}
+		if rule := matchEscalationRule(rules, warnedAt, now); rule != nil {
+			t.Logf("Expected no rule, got %+v", *rule)
+			t.Fail()
+		}
+	})
+
+	t.Run("Warnings outside a rule's window are ignored", func(t *testing.T) {
+		warnedAt := []time.Time{now, now.AddDate(0, 0, -1), now.AddDate(0, 0, -45)}
+		if rule := matchEscalationRule(rules, warnedAt, now); rule != nil {
+			t.Logf("Expected no rule, got %+v", *rule)
+			t.Fail()
+		}
+	})
+
+	t.Run("A rule only fires when its threshold is reached", func(t *testing.T) {
+		warnedAt := []time.Time{now, now, now}
+		if rule := matchEscalationRule(rules, warnedAt, now); rule == nil || rule.Action != "kick" {
+			t.Logf("Expected the kick rule on the third warning, got %+v", rule)
+			t.Fail()
+		}
+		warnedAt = append(warnedAt, now)
+		if rule := matchEscalationRule(rules, warnedAt, now); rule != nil {
+			t.Logf("Expected the fourth warning not to kick again, got %+v", *rule)
+			t.Fail()
+		}
+	})
+
+	t.Run("The strictest reached rule wins", func(t *testing.T) {
+		warnedAt := []time.Time{now, now, now.AddDate(0, 0, -2), now.AddDate(0, 0, -60), now.AddDate(0, 0, -90)}
+		rule := matchEscalationRule(rules, warnedAt, now)
+		if rule == nil || rule.Action != "ban" {
+			t.Logf("Expected the ban rule, got %+v", rule)
+			t.Fail()
+		}
+	})
+}
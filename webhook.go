@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// name of the webhook the bot creates in channels it posts copied messages to
+const webhookName = "AiO Bot"
+
+// largest attachment a webhook can upload on a server without boosts
+const maxUploadSize = 8 * 1024 * 1024
+
+// longest message content Discord accepts, in characters
+const maxMessageLength = 2000
+
+/**
+Joins the reply link, message text and trailing lines into one message. If it's too long, the
+text is cut short by characters so the reply link and trailing lines are always kept.
+*/
+func assembleWebhookContent(header string, body string, footer string) string {
+	headerRunes := []rune(header)
+	bodyRunes := []rune(body)
+	footerRunes := []rune(footer)
+	room := maxMessageLength - len(headerRunes) - len(footerRunes)
+	if room < 0 {
+		room = 0
+	}
+	if len(bodyRunes) > room {
+		bodyRunes = bodyRunes[:room]
+	}
+	content := []rune(header + string(bodyRunes) + footer)
+	if len(content) > maxMessageLength {
+		content = content[:maxMessageLength]
+	}
+	return string(content)
+}
+
+/**
+Returns the bot's webhook in the channel, creating it if it doesn't exist yet.
+*/
+func getChannelWebhook(s *discordgo.Session, channelID string) (*discordgo.Webhook, error) {
+	webhooks, err := s.ChannelWebhooks(channelID)
+	if err != nil {
+		return nil, err
+	}
+	for _, webhook := range webhooks {
+		if webhook.User != nil && webhook.User.ID == s.State.User.ID && webhook.Token != "" {
+			return webhook, nil
+		}
+	}
+	return s.WebhookCreate(channelID, webhookName, "")
+}
+
+/**
+Posts a message through the webhook and waits for the result. discordgo's WebhookExecute cannot
+upload files, so when there are files the multipart request is built here instead.
+*/
+func executeWebhook(s *discordgo.Session, webhook *discordgo.Webhook, params *discordgo.WebhookParams, files []*discordgo.File) (*discordgo.Message, error) {
+	if len(files) == 0 {
+		return s.WebhookExecute(webhook.ID, webhook.Token, true, params)
+	}
+
+	body := &bytes.Buffer{}
+	bodywriter := multipart.NewWriter(body)
+
+	payload, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", `form-data; name="payload_json"`)
+	header.Set("Content-Type", "application/json")
+	part, err := bodywriter.CreatePart(header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = part.Write(payload); err != nil {
+		return nil, err
+	}
+
+	for i, file := range files {
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file%d"; filename="%s"`, i, strings.NewReplacer("\\", "\\\\", `"`, "\\\"").Replace(file.Name)))
+		contentType := file.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		header.Set("Content-Type", contentType)
+		part, err := bodywriter.CreatePart(header)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = io.Copy(part, file.Reader); err != nil {
+			return nil, err
+		}
+	}
+	err = bodywriter.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	bucket := s.Ratelimiter.LockBucket(discordgo.EndpointWebhookToken("", ""))
+	response, err := s.RequestWithLockedBucket("POST", discordgo.EndpointWebhookToken(webhook.ID, webhook.Token)+"?wait=true", bodywriter.FormDataContentType(), body.Bytes(), bucket, 0)
+	if err != nil {
+		return nil, err
+	}
+	var message discordgo.Message
+	err = json.Unmarshal(response, &message)
+	if err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+/**
+Downloads an attachment so it can be uploaded again somewhere else.
+*/
+func downloadAttachment(attachment *discordgo.MessageAttachment) (*discordgo.File, error) {
+	if attachment.Size > maxUploadSize {
+		return nil, fmt.Errorf("%s is too large to re-upload", attachment.Filename)
+	}
+	res, err := http.Get(attachment.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("attachment download returned status %d", res.StatusCode)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(res.Body, maxUploadSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxUploadSize {
+		return nil, fmt.Errorf("%s is too large to re-upload", attachment.Filename)
+	}
+	return &discordgo.File{
+		Name:        attachment.Filename,
+		ContentType: res.Header.Get("Content-Type"),
+		Reader:      bytes.NewReader(data),
+	}, nil
+}
+
+/**
+Re-posts a message through the webhook under the original author's name and avatar. Attachments
+are uploaded again, rich embeds are forwarded as they are, and replies keep a jump link to the
+message they replied to. nicknames caches each author's nickname for the whole copy, so members
+are only looked up once.
+*/
+func repostMessage(s *discordgo.Session, webhook *discordgo.Webhook, guildID string, message *discordgo.Message, nicknames map[string]string) (*discordgo.Message, error) {
+	var params discordgo.WebhookParams
+	// never re-ping anyone mentioned in the original message
+	params.AllowedMentions = &discordgo.MessageAllowedMentions{}
+
+	if message.Author != nil {
+		params.Username = message.Author.Username
+		nick, ok := nicknames[message.Author.ID]
+		if !ok {
+			// authors who have left have no nickname, so they're remembered with an empty one too
+			if member, err := s.GuildMember(guildID, message.Author.ID); err == nil {
+				nick = member.Nick
+			}
+			nicknames[message.Author.ID] = nick
+		}
+		if nick != "" {
+			params.Username = nick
+		}
+		params.AvatarURL = message.Author.AvatarURL("")
+	}
+
+	header := ""
+	footer := ""
+	if message.MessageReference != nil {
+		referenceGuild := message.MessageReference.GuildID
+		if referenceGuild == "" {
+			referenceGuild = guildID
+		}
+		header = fmt.Sprintf("> Replying to https://discord.com/channels/%s/%s/%s\n", referenceGuild, message.MessageReference.ChannelID, message.MessageReference.MessageID)
+	}
+	if len(message.Reactions) > 0 {
+		var reactions []string
+		for _, reactionSet := range message.Reactions {
+			reactions = append(reactions, reactionSet.Emoji.Name+" x"+strconv.Itoa(reactionSet.Count))
+		}
+		footer += "\n*Reactions: " + strings.Join(reactions, ", ") + "*"
+	}
+
+	// link previews are generated again from the content, so only forward embeds that were sent as embeds
+	for _, embed := range message.Embeds {
+		if embed.Type == "rich" && len(params.Embeds) < 10 {
+			params.Embeds = append(params.Embeds, embed)
+		}
+	}
+
+	var files []*discordgo.File
+	for _, attachment := range message.Attachments {
+		file, err := downloadAttachment(attachment)
+		if err != nil {
+			logWarning("Unable to re-upload attachment, linking it instead. " + err.Error())
+			footer += "\n" + attachment.URL
+			continue
+		}
+		files = append(files, file)
+	}
+
+	params.Content = assembleWebhookContent(header, message.Content, footer)
+
+	if params.Content == "" && len(params.Embeds) == 0 && len(files) == 0 {
+		return nil, fmt.Errorf("message %s has nothing that can be copied", message.ID)
+	}
+	return executeWebhook(s, webhook, &params, files)
+}
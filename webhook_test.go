@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+/**
+Test anything that uses calculation outside of the discordgo commands.
+Those commands don't need to be tested since they are verified to work
+at github.com/bwmarrin/discordgo
+**/
+func TestWebhookContent(t *testing.T) {
+	header := "> Replying to https://discord.com/channels/1/2/3\n"
+	footer := "\n*Reactions: 👍 x2*"
+
+	t.Run("Short messages are kept whole", func(t *testing.T) {
+		content := assembleWebhookContent(header, "hello", footer)
+		if content != header+"hello"+footer {
+			t.Logf("Unexpected content: %q", content)
+			t.Fail()
+		}
+	})
+
+	t.Run("Long messages keep the reply link and reactions", func(t *testing.T) {
+		content := assembleWebhookContent(header, strings.Repeat("a", 2500), footer)
+		if utf8.RuneCountInString(content) != maxMessageLength {
+			t.Logf("Expected %d characters, got %d", maxMessageLength, utf8.RuneCountInString(content))
+			t.Fail()
+		}
+		if !strings.HasPrefix(content, header) || !strings.HasSuffix(content, footer) {
+			t.Logf("Expected the reply link and reactions to survive truncation")
+			t.Fail()
+		}
+	})
+
+	t.Run("Multi-byte characters are never split", func(t *testing.T) {
+		content := assembleWebhookContent("", strings.Repeat("é", 2500), "")
+		if !utf8.ValidString(content) || utf8.RuneCountInString(content) != maxMessageLength {
+			t.Logf("Expected %d valid characters, got %d (valid: %t)", maxMessageLength, utf8.RuneCountInString(content), utf8.ValidString(content))
+			t.Fail()
+		}
+	})
+}